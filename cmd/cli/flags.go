@@ -2,6 +2,7 @@ package cli
 
 import (
 	"os"
+	"sync/atomic"
 
 	"github.com/kenriortega/ngonx/pkg/config"
 )
@@ -12,13 +13,56 @@ var (
 	cfgFile        = "ngonx.yaml"
 	cfgPath, _     = os.Getwd()
 	errConfig      error
+	// runningConfig config.Config in use, the proxy services are updated on reload
+	runningConfig atomic.Value
 
 	// flags
-	flagPort       = "port"
-	flagServerList = "backends"
-	flagGenApiKey  = "genkey"
-	flagPrevKey    = "prevkey"
-	flagCfgFile    = "cfgfile"
-	flagCfgPath    = "cfgpath"
-	flagMetric     = "metric"
+	flagPort             = "port"
+	flagServerList       = "backends"
+	flagGenApiKey        = "genkey"
+	flagPrevKey          = "prevkey"
+	flagRevokeKey        = "revokekey"
+	flagCfgFile          = "cfgfile"
+	flagCfgPath          = "cfgpath"
+	flagMetric           = "metric"
+	flagDeadline         = "deadline"
+	flagOtlpLogs         = "otlplogs"
+	flagBufferResp       = "bufferresp"
+	flagMaxRespHeader    = "maxrespheader"
+	flagProbes           = "probes"
+	flagProbePath        = "probepath"
+	flagTimeout          = "timeout"
+	flagMaxLatency       = "maxlatency"
+	flagMaxErrorRate     = "maxerrorrate"
+	flagAccessLog        = "accesslog"
+	flagMaxConnsPerIP    = "maxconnsperip"
+	flagMaxConns         = "maxconns"
+	flagRejectConns      = "rejectconns"
+	flagFailThreshold    = "failthreshold"
+	flagRiseThreshold    = "risethreshold"
+	flagHealthWorkers    = "healthworkers"
+	flagDrainTimeout     = "draintimeout"
+	flagBreakerThreshold = "breakerthreshold"
+	flagBreakerCooldown  = "breakercooldown"
+	flagHealthInterval   = "healthinterval"
+	flagHealthPath       = "healthpath"
+	flagHealthTimeout    = "healthtimeout"
+	flagHealthTCP        = "healthtcp"
+	flagHealthStatus     = "healthstatus"
+	flagStrategy         = "strategy"
+	flagAffinityCookie   = "affinitycookie"
+	flagAdminPort        = "adminport"
+	flagGenAdminKey      = "genadminkey"
+	flagCrtFile          = "crtfile"
+	flagKeyFile          = "keyfile"
+	flagTLSMinVersion    = "tlsminversion"
+	flagTLSCiphers       = "tlsciphers"
+	flagRetries          = "retries"
+	flagMaxUpstreamCalls = "maxupstreamcalls"
+	flagBackoffMin       = "backoffmin"
+	flagBackoffMax       = "backoffmax"
+	flagBackoffFactor    = "backofffactor"
+	flagCaptureFile      = "file"
+	flagTarget           = "target"
+	flagHeader           = "header"
 )
@@ -44,7 +44,25 @@ var grpcCmd = &cobra.Command{
 			)
 			defer flush()
 			// Exporter Metrics
-			go otelify.ExposeMetricServer(configFromYaml.ProxyGateway.PortExporterProxy)
+			trustedOnly, err := adminFilter(configFromYaml)
+			if err != nil {
+				logger.LogError(errors.Errorf("grpc: metrics disabled %v", err).Error())
+			} else {
+				go otelify.ExposeMetricServer(configFromYaml.ProxyGateway.PortExporterProxy, trustedOnly)
+			}
+		}
+		enableOtlpLogs, err := cmd.Flags().GetBool(flagOtlpLogs)
+		if err != nil {
+			logger.LogError(errors.Errorf("grpc: %v", err).Error())
+		}
+		if enableOtlpLogs {
+			// TODO: pass from yml file object
+			shutdown, err := logger.EnableOTLP("0.0.0.0:55680", "ngonx")
+			if err != nil {
+				logger.LogError(errors.Errorf("grpc: failed to enable otlp logs %v", err).Error())
+			} else {
+				defer shutdown()
+			}
 		}
 
 		var opts []grpc.ServerOption
@@ -163,6 +181,7 @@ var grpcCmd = &cobra.Command{
 
 func init() {
 	grpcCmd.Flags().Bool(flagMetric, false, "Action for enable metrics OTEL")
+	grpcCmd.Flags().Bool(flagOtlpLogs, false, "Action for export logs as OTLP logs correlated by traceID")
 	rootCmd.AddCommand(grpcCmd)
 }
 
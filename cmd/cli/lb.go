@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
@@ -15,6 +20,7 @@ import (
 	handlers "github.com/kenriortega/ngonx/internal/proxy/handlers"
 	"github.com/kenriortega/ngonx/pkg/backoff"
 	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/httpsrv"
 	"github.com/kenriortega/ngonx/pkg/logger"
 	"github.com/spf13/cobra"
 )
@@ -41,29 +47,246 @@ var lbCmd = &cobra.Command{
 			logger.LogError(errors.Errorf("lb: provide one or more backends to load balance %v", err).Error())
 		}
 
-		// parse servers
-		tokens := strings.Split(serverList, ",")
-		for _, tok := range tokens {
-			serverUrl, err := url.Parse(tok)
+		deadline, err := cmd.Flags().GetDuration(flagDeadline)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		bufferResponses, err := cmd.Flags().GetBool(flagBufferResp)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		maxRespHeader, err := cmd.Flags().GetInt64(flagMaxRespHeader)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		accessLogPath, err := cmd.Flags().GetString(flagAccessLog)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		maxConnsPerIP, err := cmd.Flags().GetInt(flagMaxConnsPerIP)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		maxConns, err := cmd.Flags().GetInt(flagMaxConns)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		rejectConns, err := cmd.Flags().GetBool(flagRejectConns)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.FailThreshold, err = cmd.Flags().GetInt(flagFailThreshold)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.RiseThreshold, err = cmd.Flags().GetInt(flagRiseThreshold)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.HealthCheckWorkers, err = cmd.Flags().GetInt(flagHealthWorkers)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		drainTimeout, err := cmd.Flags().GetDuration(flagDrainTimeout)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		healthInterval, err := cmd.Flags().GetDuration(flagHealthInterval)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.HealthCheckPath, err = cmd.Flags().GetString(flagHealthPath)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.HealthCheckTimeout, err = cmd.Flags().GetDuration(flagHealthTimeout)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.HealthCheckTCP, err = cmd.Flags().GetBool(flagHealthTCP)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.HealthCheckStatus, err = cmd.Flags().GetIntSlice(flagHealthStatus)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.BreakerThreshold, err = cmd.Flags().GetInt(flagBreakerThreshold)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.BreakerCooldown, err = cmd.Flags().GetDuration(flagBreakerCooldown)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.Strategy, err = cmd.Flags().GetString(flagStrategy)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		handlers.ServerPool.AffinityCookie, err = cmd.Flags().GetString(flagAffinityCookie)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		adminPort, err := cmd.Flags().GetInt(flagAdminPort)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		generateAdminKey, err := cmd.Flags().GetBool(flagGenAdminKey)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		keysFile := configFromYaml.Admin.APIKeysFile
+		if generateAdminKey {
+			apiKey, err := genAdminKey(keysFile)
 			if err != nil {
-				logger.LogError(errors.Errorf("lb: %v", err).Error())
+				logger.LogError(errors.Errorf("lb: failed genadminkey %v", err).Error())
+				return
+			}
+			// only the hash is stored, the key can't be read back later
+			fmt.Printf("admin apikey: %s\n", apiKey)
+		}
+		var tlsOpts httpsrv.TLSOptions
+		tlsOpts.CrtFile, err = cmd.Flags().GetString(flagCrtFile)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		tlsOpts.KeyFile, err = cmd.Flags().GetString(flagKeyFile)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		tlsOpts.MinVersion, err = cmd.Flags().GetString(flagTLSMinVersion)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		tlsOpts.CipherSuites, err = cmd.Flags().GetStringSlice(flagTLSCiphers)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		if !domain.ValidStrategy(handlers.ServerPool.Strategy) {
+			logger.LogError(errors.Errorf("lb: %w: %q", errors.ErrLBStrategy, handlers.ServerPool.Strategy).Error())
+			return
+		}
+
+		retries, err := cmd.Flags().GetInt(flagRetries)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		maxUpstreamCalls, err := cmd.Flags().GetInt(flagMaxUpstreamCalls)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		backoffMin, err := cmd.Flags().GetDuration(flagBackoffMin)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		backoffMax, err := cmd.Flags().GetDuration(flagBackoffMax)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		backoffFactor, err := cmd.Flags().GetFloat64(flagBackoffFactor)
+		if err != nil {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+		}
+		retryBackoff := backoff.Default
+		if backoffMin > 0 || backoffMax > 0 {
+			retryBackoff = backoff.Exponential(backoffMin, backoffMax, backoffFactor)
+		}
+
+		// newBackend builds a backend from an entry of the server list (see
+		// parseBackend), for the startup list and the admin api alike
+		newBackend := func(tok string) (*domain.Backend, error) {
+			serverUrl, opts, err := parseBackend(tok)
+			if err != nil {
+				return nil, err
+			}
+			opts.transport.MaxResponseHeaderBytes = maxRespHeader
+			transport, err := handlers.NewTransport(opts.transport)
+			if err != nil {
+				return nil, err
+			}
+			if opts.transport.TLS.InsecureSkipVerify {
+				logger.LogWarn(fmt.Sprintf("lb: INSECURE backend %s with insecure=true, its certificate is not verified\n", serverUrl))
 			}
 
 			proxy := httputil.NewSingleHostReverseProxy(serverUrl)
+			proxy.Transport = handlers.TraceUpstream(transport)
+			if bufferResponses {
+				proxy.ModifyResponse = handlers.BufferResponse
+			}
+			if handlers.ServerPool.BreakerThreshold > 0 {
+				modify := proxy.ModifyResponse
+				proxy.ModifyResponse = func(resp *http.Response) error {
+					handlers.ServerPool.RecordResult(serverUrl, resp.StatusCode < http.StatusInternalServerError)
+					if modify != nil {
+						return modify(resp)
+					}
+					return nil
+				}
+			}
+			if opts.host != "" {
+				host := opts.host
+				originalDirector := proxy.Director
+				proxy.Director = func(req *http.Request) {
+					originalDirector(req)
+					req.Host = host
+				}
+			}
+			if strip := handlers.StripHeaders(opts.stripHeaders); strip != nil {
+				originalDirector := proxy.Director
+				proxy.Director = func(req *http.Request) {
+					originalDirector(req)
+					strip(req)
+				}
+			}
 			proxy.ErrorHandler = func(writer http.ResponseWriter, request *http.Request, e error) {
 				logger.LogInfo(fmt.Sprintf("lb: %s %s\n", serverUrl.Host, e.Error()))
+				if handlers.IsBudgetExhausted(request) {
+					logger.LogInfo(fmt.Sprintf("lb: %s(%s) Deadline budget exhausted, terminating\n", request.RemoteAddr, request.URL.Path))
+					handlers.WriteError(writer, http.StatusGatewayTimeout, errors.ErrLBDeadlineBudget)
+					return
+				}
+				if handlers.IsResponseHeaderTooLarge(e) {
+					// a misbehaving backend, retrying would read the same headers again
+					handlers.WriteError(writer, http.StatusBadGateway, errors.ErrUpstreamHeaderTooLarge)
+					return
+				}
+				if errors.ErrorIs(e, errors.ErrUpstreamTruncated) {
+					// the backend already answered, retrying on it makes no sense
+					if !handlers.IsIdempotent(request.Method) {
+						handlers.WriteError(writer, http.StatusBadGateway, errors.ErrUpstreamTruncated)
+						return
+					}
+					attempts := handlers.GetAttemptsFromContext(request)
+					ctx := context.WithValue(request.Context(), domain.ATTEMPTS, attempts+1)
+					handlers.Lbalancer(writer, request.WithContext(ctx))
+					return
+				}
+				if !errors.ErrorIs(e, context.Canceled) {
+					handlers.ServerPool.RecordResult(serverUrl, false)
+				}
 				retry := handlers.GetRetryFromContext(request)
 
-				if retry < 3 {
-					time.Sleep(backoff.Default.Duration(retry))
+				// an open circuit fails over right away instead of paying the retries
+				if retry < retries && !handlers.ServerPool.CircuitOpen(serverUrl) {
+					if !handlers.WaitRetry(request, retryBackoff.Duration(retry)) {
+						handlers.WriteError(writer, http.StatusGatewayTimeout, errors.ErrLBDeadlineBudget)
+						return
+					}
+					if !handlers.TakeUpstreamCall(request) {
+						handlers.WriteError(writer, http.StatusServiceUnavailable, errors.ErrLBMaxUpstreamCalls)
+						return
+					}
 					ctx := context.WithValue(request.Context(), domain.RETRY, retry+1)
 					proxy.ServeHTTP(writer, request.WithContext(ctx))
 
 					return
 				}
 
-				// after 3 retries, mark this backend as down
-				handlers.ServerPool.MarkBackendStatus(serverUrl, false)
+				// after the retries (right away with 0), mark this backend as down, an open
+				// circuit already keeps it out of rotation until its half-open probe
+				if !handlers.ServerPool.CircuitOpen(serverUrl) {
+					handlers.ServerPool.MarkBackendStatus(serverUrl, false)
+				}
 
 				// if the same request routing for few attempts with different backends, increase the count
 				attempts := handlers.GetAttemptsFromContext(request)
@@ -72,34 +295,271 @@ var lbCmd = &cobra.Command{
 				handlers.Lbalancer(writer, request.WithContext(ctx))
 			}
 
-			handlers.ServerPool.AddBackend(&domain.Backend{
+			backend := &domain.Backend{
 				URL:          serverUrl,
 				Alive:        true,
 				ReverseProxy: proxy,
+				Weight:       opts.weight,
+				StripHeaders: opts.stripHeaders,
+				Transport:    transport,
+				Host:         opts.host,
+			}
+			if handlers.ServerPool.Strategy == domain.StrategyLeastBytes {
+				modify := proxy.ModifyResponse
+				proxy.ModifyResponse = func(resp *http.Response) error {
+					if modify != nil {
+						if err := modify(resp); err != nil {
+							return err
+						}
+					}
+					backend.TrackBytes(resp)
+					return nil
+				}
+			}
+			return backend, nil
+		}
+
+		// parse servers
+		var backends []backendSummary
+		tokens := strings.Split(serverList, ",")
+		for _, tok := range tokens {
+			backend, err := newBackend(tok)
+			if err != nil {
+				logger.LogError(errors.Errorf("lb: %v", err).Error())
+				continue
+			}
+			handlers.ServerPool.AddBackend(backend)
+			backends = append(backends, backendSummary{URL: backend.URL.String(), Weight: backend.Weight})
+			logger.LogInfo(fmt.Sprintf("lb: configured server: %s\n", backend.URL))
+		}
+
+		handler := handlers.Recover(handlers.ClientConcurrencyLimit(
+			maxConnsPerIP,
+			handlers.DeadlineBudget(deadline, handlers.MaxUpstreamCalls(maxUpstreamCalls, http.HandlerFunc(handlers.Lbalancer))),
+		))
+		if accessLogPath != "" {
+			accessLog, err := logger.NewAccessLog(logger.FileOptions{
+				Path:        accessLogPath,
+				MaxSize:     100,
+				MaxBackups:  7,
+				MaxAge:      28,
+				RotateEvery: 24 * time.Hour,
 			})
-			logger.LogInfo(fmt.Sprintf("lb: configured server: %s\n", serverUrl))
+			if err != nil {
+				logger.LogError(errors.Errorf("lb: access log disabled %v", err).Error())
+			} else {
+				defer accessLog.Close()
+				handler = accessLog.Handler(handler)
+			}
 		}
 
 		// create http server
 		server := http.Server{
 			Addr:    fmt.Sprintf(":%d", port),
-			Handler: http.HandlerFunc(handlers.Lbalancer),
+			Handler: handler,
+		}
+		if tlsOpts.CrtFile != "" {
+			server.TLSConfig, err = httpsrv.NewTLSConfig(tlsOpts)
+			if err != nil {
+				logger.LogError(errors.Errorf("lb: %v", err).Error())
+				return
+			}
 		}
 
+		// SIGINT/SIGTERM stop the health checks and drain the server
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
 		// start health checking
-		go handlers.HealthCheck()
+		go handlers.HealthCheck(ctx, healthInterval)
+		if adminPort > 0 {
+			go startLBAdmin(adminPort, &lbAdmin{newBackend: newBackend, drainTimeout: drainTimeout, keysFile: keysFile})
+		}
+
+		var middleware []string
+		if deadline > 0 {
+			middleware = append(middleware, "deadline="+deadline.String())
+		}
+		if bufferResponses {
+			middleware = append(middleware, "buffer_responses")
+		}
+		if maxConnsPerIP > 0 {
+			middleware = append(middleware, fmt.Sprintf("max_conns_per_ip=%d", maxConnsPerIP))
+		}
+		if maxConns > 0 {
+			middleware = append(middleware, fmt.Sprintf("max_connections=%d", maxConns))
+		}
+		if accessLogPath != "" {
+			middleware = append(middleware, "access_log")
+		}
+		if pool := &handlers.ServerPool; pool.FailThreshold > 0 || pool.RiseThreshold > 0 {
+			middleware = append(middleware, fmt.Sprintf("health_thresholds=%d/%d", pool.FailThreshold, pool.RiseThreshold))
+		}
+		middleware = append(middleware, fmt.Sprintf("health_workers=%d", handlers.ServerPool.HealthCheckWorkers))
+		middleware = append(middleware, "health_interval="+healthInterval.String())
+		if handlers.ServerPool.HealthCheckTCP {
+			middleware = append(middleware, "health_probe=tcp")
+		} else {
+			middleware = append(middleware, "health_probe=GET "+handlers.ServerPool.HealthCheckPath)
+			if status := handlers.ServerPool.HealthCheckStatus; len(status) > 0 {
+				middleware = append(middleware, fmt.Sprintf("health_status=%v", status))
+			}
+		}
+		if pool := &handlers.ServerPool; pool.BreakerThreshold > 0 {
+			middleware = append(middleware, fmt.Sprintf("circuit_breaker=%d/%s", pool.BreakerThreshold, pool.BreakerCooldown))
+		}
+		middleware = append(middleware, "drain_timeout="+drainTimeout.String())
+		middleware = append(middleware, "strategy="+handlers.ServerPool.Strategy)
+		if handlers.ServerPool.AffinityCookie != "" {
+			middleware = append(middleware, "affinity_cookie="+handlers.ServerPool.AffinityCookie)
+		}
+		middleware = append(middleware, fmt.Sprintf("retries=%d", retries))
+		if maxUpstreamCalls > 0 {
+			middleware = append(middleware, fmt.Sprintf("max_upstream_calls=%d", maxUpstreamCalls))
+		}
+		if adminPort > 0 {
+			middleware = append(middleware, fmt.Sprintf("admin_port=%d", adminPort))
+		}
+		if tlsOpts.CrtFile != "" {
+			middleware = append(middleware, "tls_min_version="+tlsOpts.MinVersion)
+		}
+		logLBSummary(backends, server.Addr, middleware)
 
 		logger.LogInfo(fmt.Sprintf("lb: Load Balancer started at :%d\n", port))
-		if err := server.ListenAndServe(); err != nil {
+		l, err := net.Listen("tcp", server.Addr)
+		if err != nil {
 			logger.LogError(errors.Errorf("lb: %v", err).Error())
+			return
 		}
+		drained := make(chan struct{})
+		go func() {
+			defer close(drained)
+			<-ctx.Done()
+			logger.LogInfo("lb: shutting down, draining the requests in flight")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancel()
+			server.SetKeepAlivesEnabled(false)
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				logger.LogError(errors.Errorf("lb: could not gracefully shutdown %v", err).Error())
+			}
+		}()
+		if tlsOpts.CrtFile != "" {
+			// the certificate is read on each handshake, ServeTLS needs no files
+			err = server.ServeTLS(httpsrv.LimitListener(l, maxConns, rejectConns), "", "")
+		} else {
+			err = server.Serve(httpsrv.LimitListener(l, maxConns, rejectConns))
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.LogError(errors.Errorf("lb: %v", err).Error())
+			return
+		}
+		<-drained
+		logger.LogInfo("lb: stopped")
 
 	},
 }
 
+// backendOptions options for a backend from the server list
+type backendOptions struct {
+	transport handlers.TransportOptions
+	// host overrides the Host header sent to the backend
+	host string
+	// weight share of the traffic, 1 when it`s not set
+	weight int
+	// stripHeaders request headers never sent to the backend
+	stripHeaders []string
+}
+
+// parseBackend parse a backend from the server list, options are
+// separated by `|` e.g. `http://a:8080|3|proxy=socks5://egress:1080|host=api.internal|dial=500ms|response=30s|sni=api.internal|ca=internal-ca.pem|strip=Authorization;X-Internal-*`,
+// a bare number is the weight of the backend
+func parseBackend(tok string) (*url.URL, backendOptions, error) {
+	opts := backendOptions{weight: 1}
+	parts := strings.Split(strings.TrimSpace(tok), "|")
+	serverUrl, err := url.Parse(parts[0])
+	if err != nil {
+		return nil, opts, err
+	}
+	for _, part := range parts[1:] {
+		if weight, err := strconv.Atoi(part); err == nil {
+			if weight < 1 {
+				return nil, opts, errors.Errorf("invalid backend weight %q", part)
+			}
+			opts.weight = weight
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, opts, errors.Errorf("invalid backend option %q", part)
+		}
+		switch kv[0] {
+		case "proxy":
+			opts.transport.OutboundProxy = kv[1]
+		case "host":
+			opts.host = kv[1]
+		case "sni":
+			opts.transport.ServerName = kv[1]
+		case "ca":
+			opts.transport.TLS.CAFile = kv[1]
+		case "cert":
+			opts.transport.TLS.CertFile = kv[1]
+		case "key":
+			opts.transport.TLS.KeyFile = kv[1]
+		case "insecure":
+			if opts.transport.TLS.InsecureSkipVerify, err = strconv.ParseBool(kv[1]); err != nil {
+				return nil, opts, errors.Errorf("invalid backend insecure %q", kv[1])
+			}
+		case "strip":
+			opts.stripHeaders = append(opts.stripHeaders, strings.Split(kv[1], ";")...)
+		case "dial":
+			if opts.transport.DialTimeout, err = time.ParseDuration(kv[1]); err != nil {
+				return nil, opts, errors.Errorf("invalid backend dial timeout %q", kv[1])
+			}
+		case "response":
+			if opts.transport.ResponseTimeout, err = time.ParseDuration(kv[1]); err != nil {
+				return nil, opts, errors.Errorf("invalid backend response timeout %q", kv[1])
+			}
+		default:
+			return nil, opts, errors.Errorf("unknown backend option %q", kv[0])
+		}
+	}
+	return serverUrl, opts, nil
+}
+
 func init() {
 	lbCmd.Flags().String(flagServerList, cfgFile, "Load balanced backends, use commas to separate")
 	lbCmd.Flags().Int(flagPort, 4000, "Port to serve to run load balancing ")
+	lbCmd.Flags().Int(flagRetries, 3, "Retries on a backend before marking it down and trying the next one, 0 fails over right away")
+	lbCmd.Flags().Int(flagMaxUpstreamCalls, 0, "Upstream calls of a request across all backends (retries and failovers), over it 503, 0 unlimited")
+	lbCmd.Flags().Duration(flagBackoffMin, 0, "First delay between retries, with backoffmin and backoffmax at 0 the default policy (up to 5s) is used")
+	lbCmd.Flags().Duration(flagBackoffMax, 0, "Ceiling of the delay between retries")
+	lbCmd.Flags().Float64(flagBackoffFactor, 2, "Growth of the delay between retries")
+	lbCmd.Flags().Int(flagBreakerThreshold, 0, "Failed requests in a row that open the circuit of a backend, 0 disables the breaker")
+	lbCmd.Flags().Duration(flagBreakerCooldown, 30*time.Second, "Time an open circuit gets no traffic before a single request probes the backend")
+	lbCmd.Flags().Duration(flagDrainTimeout, 30*time.Second, "Wait for the requests in flight on SIGINT/SIGTERM before closing the connections")
+	lbCmd.Flags().Duration(flagDeadline, 0, "Overall deadline for a request shared across retries, 0 to disable")
+	lbCmd.Flags().Int64(flagMaxRespHeader, 0, "Max bytes of the backend response headers, 0 uses the default (1MB)")
+	lbCmd.Flags().Bool(flagBufferResp, false, "Buffer responses to failover idempotent requests when a backend closes mid-response")
+	lbCmd.Flags().Int(flagMaxConnsPerIP, 0, "Simultaneous requests of a client ip, over it 429, 0 unlimited")
+	lbCmd.Flags().Int(flagMaxConns, 0, "Concurrent connections of the listener, over it they wait, 0 unlimited")
+	lbCmd.Flags().Bool(flagRejectConns, false, "Close right away the connections over maxconns instead of waiting")
+	lbCmd.Flags().String(flagAccessLog, "", "Access log file rotated daily or at 100MB, empty to disable")
+	lbCmd.Flags().Int(flagFailThreshold, 0, "Failed health checks in a row to mark a backend down, 0 uses the health score")
+	lbCmd.Flags().Int(flagHealthWorkers, 10, "Health checks running at once, smooths the probes of many backends")
+	lbCmd.Flags().Duration(flagHealthInterval, time.Minute, "Interval between health check rounds")
+	lbCmd.Flags().String(flagHealthPath, "/", "Path requested by the health checks, 2xx and 3xx are healthy")
+	lbCmd.Flags().Duration(flagHealthTimeout, 2*time.Second, "Timeout of each health check probe")
+	lbCmd.Flags().IntSlice(flagHealthStatus, nil, "Status codes of a healthy backend e.g. 200,204, empty accepts any 2xx and 3xx")
+	lbCmd.Flags().Bool(flagHealthTCP, false, "Health checks only dial the backend, for non http backends")
+	lbCmd.Flags().String(flagStrategy, domain.StrategyRoundRobin, "Balancing strategy roundrobin|ewma (prefers the fastest backends)|leastbytes (prefers the least response bytes in flight)")
+	lbCmd.Flags().String(flagCrtFile, "", "Certificate file to terminate TLS, reloaded when it changes on disk, empty serves plain http")
+	lbCmd.Flags().String(flagKeyFile, "", "Private key file of the certificate")
+	lbCmd.Flags().String(flagTLSMinVersion, "1.2", "Minimum TLS version 1.2|1.3")
+	lbCmd.Flags().StringSlice(flagTLSCiphers, nil, "TLS 1.2 cipher suites e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, empty keeps the defaults")
+	lbCmd.Flags().Int(flagAdminPort, 0, "Port of the admin api adding and removing backends (api keys of admin.api_keys_file), 0 disables it")
+	lbCmd.Flags().Bool(flagGenAdminKey, false, "Generate an api key of the admin api and append its hash to admin.api_keys_file")
+	lbCmd.Flags().String(flagAffinityCookie, "", "Cookie pinning a client to its backend while it's alive, empty to balance every request")
+	lbCmd.Flags().Int(flagRiseThreshold, 0, "Passing health checks in a row to bring a backend back, 0 uses the health score")
 
 	rootCmd.AddCommand(lbCmd)
 }
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	handlers "github.com/kenriortega/ngonx/internal/proxy/handlers"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/genkey"
+	"github.com/kenriortega/ngonx/pkg/httpsrv"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// maxAdminBody bytes of the body of the admin api requests
+const maxAdminBody = 64 << 10
+
+// lbAdmin admin api adding and removing the backends of the running load balancer
+type lbAdmin struct {
+	// newBackend builds a backend from an entry of the server list
+	newBackend func(string) (*domain.Backend, error)
+	// drainTimeout wait for the requests in flight of a removed backend
+	drainTimeout time.Duration
+	// keysFile hashes of the api keys of the admin api, one per line
+	keysFile string
+}
+
+// backendRequest body of `POST /backends`, the backend uses the syntax of the
+// server list e.g. `http://localhost:5002|2|host=api.internal`
+type backendRequest struct {
+	Backend string `json:"backend"`
+}
+
+// routes of the admin api
+func (a *lbAdmin) routes() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/backends", a.list).Methods(http.MethodGet)
+	r.HandleFunc("/backends", a.add).Methods(http.MethodPost)
+	r.HandleFunc("/backends", a.remove).Methods(http.MethodDelete)
+	return r
+}
+
+// list the backends of the pool with their state
+func (a *lbAdmin) list(w http.ResponseWriter, r *http.Request) {
+	backends := handlers.ServerPool.Backends()
+	list := make([]map[string]interface{}, 0, len(backends))
+	for _, b := range backends {
+		list = append(list, backendState(b))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"backends": list})
+}
+
+// add a backend to the pool, it takes traffic right away and the next health
+// check round probes it
+func (a *lbAdmin) add(w http.ResponseWriter, r *http.Request) {
+	var body backendRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody)).Decode(&body); err != nil || body.Backend == "" {
+		handlers.WriteError(w, http.StatusBadRequest, errors.Errorf("%w: body is {\"backend\": \"<url>|<options>\"}", errors.ErrLBBackendInvalid))
+		return
+	}
+	backend, err := a.newBackend(body.Backend)
+	if err != nil {
+		handlers.WriteError(w, http.StatusBadRequest, errors.Errorf("%w: %v", errors.ErrLBBackendInvalid, err))
+		return
+	}
+	if !handlers.ServerPool.AddNewBackend(backend) {
+		handlers.WriteError(w, http.StatusConflict, errors.ErrLBBackendExists)
+		return
+	}
+	logger.LogInfo(fmt.Sprintf("lb: admin added server: %s\n", backend.URL))
+	writeJSON(w, http.StatusCreated, backendState(backend))
+}
+
+// remove a backend of the pool `?url=`, it answers once the requests in flight
+// ended or drainTimeout elapsed
+func (a *lbAdmin) remove(w http.ResponseWriter, r *http.Request) {
+	backendUrl, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || backendUrl.Host == "" {
+		handlers.WriteError(w, http.StatusBadRequest, errors.Errorf("%w: the url query param is required", errors.ErrLBBackendInvalid))
+		return
+	}
+	inflight, ok := handlers.ServerPool.RemoveBackend(backendUrl, a.drainTimeout)
+	if !ok {
+		handlers.WriteError(w, http.StatusNotFound, errors.ErrLBBackendNotFound)
+		return
+	}
+	logger.LogInfo(fmt.Sprintf("lb: admin removed server: %s\n", backendUrl))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"removed": backendUrl.String(), "inflight": inflight})
+}
+
+// backendState state of a backend as shown by the admin apis
+func backendState(b *domain.Backend) map[string]interface{} {
+	return map[string]interface{}{
+		"url":          b.URL.String(),
+		"weight":       b.Weight,
+		"alive":        b.IsAlive(),
+		"health_score": b.HealthScore(),
+		"circuit":      b.CircuitState(),
+		"draining":     b.Draining(),
+		"inflight":     b.Inflight(),
+	}
+}
+
+// writeJSON write v as the json response with the status code
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// requireKey guards next with the api keys of keysFile, read on every request
+// so the revoked keys stop working right away. Only the hashes of
+// `lb --genadminkey` are accepted, an unreadable file answers 503
+func (a *lbAdmin) requireKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stored, err := readAdminKeys(a.keysFile)
+		if err != nil {
+			logger.LogWarn("lb: admin api keys unavailable", zap.String("file", a.keysFile), zap.Error(err))
+			handlers.WriteError(w, http.StatusServiceUnavailable, errors.ErrSecretStoreUnavailable)
+			return
+		}
+		// every key is compared, the time doesn't tell which one matched
+		matched := false
+		presented := r.Header.Get("X-API-KEY")
+		for _, hash := range stored {
+			if genkey.CompareAPIKey(hash, presented) {
+				matched = true
+			}
+		}
+		if !matched {
+			handlers.WriteError(w, http.StatusUnauthorized, errors.ErrAdminAPIKey)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readAdminKeys the hashes of the file, the blank lines and `#` comments are skipped
+func readAdminKeys(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var stored []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") && genkey.IsHashedAPIKey(line) {
+			stored = append(stored, line)
+		}
+	}
+	return stored, nil
+}
+
+// genAdminKey generate an api key of the admin api and append its hash to the
+// file, the key itself is only returned
+func genAdminKey(file string) (string, error) {
+	apiKey := genkey.ApiKeyGenerator(genkey.StringWithCharset())
+	hash, err := genkey.HashAPIKey(apiKey)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintln(f, hash); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	return apiKey, f.Close()
+}
+
+// startLBAdmin serve the admin api on its own port, behind the admin trusted
+// cidrs and the api keys of `admin.api_keys_file`. The keys of the proxy store
+// aren't used, its badger directory belongs to the proxy process
+func startLBAdmin(port int, admin *lbAdmin) {
+	if admin.keysFile == "" {
+		logger.LogError(errors.Errorf("lb: admin api disabled, admin.api_keys_file is required").Error())
+		return
+	}
+	trustedOnly, err := adminFilter(configFromYaml)
+	if err != nil {
+		logger.LogError(errors.Errorf("lb: admin api disabled %v", err).Error())
+		return
+	}
+	logger.LogInfo(fmt.Sprintf("lb: admin api started at :%d\n", port))
+	srv := httpsrv.NewServer("0.0.0.0", port, trustedOnly(admin.requireKey(admin.routes())))
+	// DELETE answers once the backend drained
+	srv.WriteTimeout = admin.drainTimeout + 15*time.Second
+	srv.Start()
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	handlers "github.com/kenriortega/ngonx/internal/proxy/handlers"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Probe the load balancer backends and report which are healthy and fast",
+	Run: func(cmd *cobra.Command, args []string) {
+		serverList, err := cmd.Flags().GetString(flagServerList)
+		if err != nil {
+			logger.LogError(errors.Errorf("preflight: %v", err).Error())
+		}
+		probes, err := cmd.Flags().GetInt(flagProbes)
+		if err != nil {
+			logger.LogError(errors.Errorf("preflight: %v", err).Error())
+		}
+		path, err := cmd.Flags().GetString(flagProbePath)
+		if err != nil {
+			logger.LogError(errors.Errorf("preflight: %v", err).Error())
+		}
+		timeout, err := cmd.Flags().GetDuration(flagTimeout)
+		if err != nil {
+			logger.LogError(errors.Errorf("preflight: %v", err).Error())
+		}
+		maxLatency, err := cmd.Flags().GetDuration(flagMaxLatency)
+		if err != nil {
+			logger.LogError(errors.Errorf("preflight: %v", err).Error())
+		}
+		maxErrorRate, err := cmd.Flags().GetFloat64(flagMaxErrorRate)
+		if err != nil {
+			logger.LogError(errors.Errorf("preflight: %v", err).Error())
+		}
+		if probes < 1 {
+			probes = 1
+		}
+
+		healthy := true
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "BACKEND\tPROBES\tERRORS\tERROR RATE\tAVG\tP95\tRESULT")
+		for _, tok := range strings.Split(serverList, ",") {
+			report, err := probeBackend(tok, path, probes, timeout)
+			if err != nil {
+				healthy = false
+				fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\tinvalid: %v\n", strings.TrimSpace(tok), err)
+				continue
+			}
+			result := "ok"
+			switch {
+			case report.errorRate() > maxErrorRate:
+				result = "unhealthy"
+			case maxLatency > 0 && report.p95 > maxLatency:
+				result = "slow"
+			}
+			if result != "ok" {
+				healthy = false
+			}
+			fmt.Fprintf(
+				w, "%s\t%d\t%d\t%.1f%%\t%s\t%s\t%s\n",
+				report.backend, report.probes, report.errors, report.errorRate()*100,
+				report.avg.Round(time.Microsecond), report.p95.Round(time.Microsecond), result,
+			)
+		}
+		_ = w.Flush()
+
+		if !healthy {
+			os.Exit(1)
+		}
+	},
+}
+
+// probeReport latency and errors of the probes sent to a backend
+type probeReport struct {
+	backend string
+	probes  int
+	errors  int
+	avg     time.Duration
+	p95     time.Duration
+}
+
+func (r probeReport) errorRate() float64 {
+	return float64(r.errors) / float64(r.probes)
+}
+
+// probeBackend send `probes` sequential GET requests to the backend, transport
+// errors and 5xx responses count as errors
+func probeBackend(tok, path string, probes int, timeout time.Duration) (probeReport, error) {
+	serverUrl, opts, err := parseBackend(tok)
+	if err != nil {
+		return probeReport{}, err
+	}
+	transport, err := handlers.NewTransport(opts.transport)
+	if err != nil {
+		return probeReport{}, err
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	target := strings.TrimSuffix(serverUrl.String(), "/") + path
+
+	report := probeReport{backend: serverUrl.String(), probes: probes}
+	latencies := make([]time.Duration, 0, probes)
+	var total time.Duration
+	for i := 0; i < probes; i++ {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			return probeReport{}, err
+		}
+		if opts.host != "" {
+			req.Host = opts.host
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		latency := time.Since(start)
+		if err != nil {
+			report.errors++
+		} else {
+			_ = resp.Body.Close()
+			if resp.StatusCode >= http.StatusInternalServerError {
+				report.errors++
+			}
+		}
+		latencies = append(latencies, latency)
+		total += latency
+	}
+	transport.CloseIdleConnections()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.avg = total / time.Duration(probes)
+	report.p95 = latencies[(len(latencies)*95+99)/100-1]
+	return report, nil
+}
+
+func init() {
+	preflightCmd.Flags().String(flagServerList, "", "Backends to probe, use commas to separate")
+	preflightCmd.Flags().Int(flagProbes, 10, "Probe requests sent to each backend")
+	preflightCmd.Flags().String(flagProbePath, "/", "Path requested on each backend")
+	preflightCmd.Flags().Duration(flagTimeout, 2*time.Second, "Timeout of each probe request")
+	preflightCmd.Flags().Duration(flagMaxLatency, 0, "Max p95 latency of a healthy backend, 0 to disable")
+	preflightCmd.Flags().Float64(flagMaxErrorRate, 0, "Max error rate (0-1) of a healthy backend")
+
+	rootCmd.AddCommand(preflightCmd)
+}
@@ -2,19 +2,40 @@ package cli
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
 	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
 	handlers "github.com/kenriortega/ngonx/internal/proxy/handlers"
 	services "github.com/kenriortega/ngonx/internal/proxy/services"
 	"github.com/kenriortega/ngonx/pkg/badgerdb"
+	"github.com/kenriortega/ngonx/pkg/capture"
+	"github.com/kenriortega/ngonx/pkg/config"
 	"github.com/kenriortega/ngonx/pkg/errors"
 	"github.com/kenriortega/ngonx/pkg/genkey"
 	"github.com/kenriortega/ngonx/pkg/httpsrv"
 	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/nonce"
 	"github.com/kenriortega/ngonx/pkg/otelify"
+	"github.com/kenriortega/ngonx/pkg/redisdb"
+	"github.com/kenriortega/ngonx/pkg/reqid"
 	"github.com/spf13/cobra"
 )
 
+// reloadRoutes rebuild the proxy routes from the config file (`func() error`),
+// it`s empty until the proxy command is running
+var reloadRoutes atomic.Value
+
+// reloadMu serializes the reloads of SIGHUP and `/reload`, viper isn`t safe
+// for concurrent use
+var reloadMu sync.Mutex
+
 var proxyCmd = &cobra.Command{
 	Use:   "proxy",
 	Short: "Run ngonx as a reverse proxy",
@@ -34,7 +55,25 @@ var proxyCmd = &cobra.Command{
 			)
 			defer flush()
 			// Exporter Metrics
-			go otelify.ExposeMetricServer(configFromYaml.ProxyGateway.PortExporterProxy)
+			trustedOnly, err := adminFilter(configFromYaml)
+			if err != nil {
+				logger.LogError(errors.Errorf("proxy: metrics disabled %v", err).Error())
+			} else {
+				go otelify.ExposeMetricServer(configFromYaml.ProxyGateway.PortExporterProxy, trustedOnly)
+			}
+		}
+		enableOtlpLogs, err := cmd.Flags().GetBool(flagOtlpLogs)
+		if err != nil {
+			logger.LogError(errors.Errorf("proxy: %v", err).Error())
+		}
+		if enableOtlpLogs {
+			// TODO: pass from yml file object
+			shutdown, err := logger.EnableOTLP("0.0.0.0:55680", "ngonx")
+			if err != nil {
+				logger.LogError(errors.Errorf("proxy: failed to enable otlp logs %v", err).Error())
+			} else {
+				defer shutdown()
+			}
 		}
 
 		port, err := cmd.Flags().GetInt(flagPort)
@@ -49,68 +88,314 @@ var proxyCmd = &cobra.Command{
 		if err != nil {
 			logger.LogError(errors.Errorf("proxy: %v", err).Error())
 		}
+		revokeKey, err := cmd.Flags().GetString(flagRevokeKey)
+		if err != nil {
+			logger.LogError(errors.Errorf("proxy: %v", err).Error())
+		}
 
 		// proxy logic
 		engine := configFromYaml.ProxyCache.Engine
 		securityType := configFromYaml.ProxySecurity.Type
 		key := configFromYaml.ProxyCache.Key + "_" + securityType
 
-		var proxyRepository domain.ProxyRepository
-		clientBadger := badgerdb.GetBadgerDB(context.Background(), false)
-		proxyRepository = domain.NewProxyRepository(clientBadger)
+		proxyRepository := newProxyRepository(engine)
+		hmacOpts := configFromYaml.ProxySecurity.HMAC
 		h := handlers.ProxyHandler{
-			Service: services.NewProxyService(proxyRepository),
+			Service:               services.NewProxyService(proxyRepository),
+			AllowDuplicateHeaders: configFromYaml.ProxySecurity.AllowDuplicateHeaders,
+			SkipAuthPreflight:     configFromYaml.ProxySecurity.SkipAuthPreflight,
+			AllowPlaintextAPIKeys: configFromYaml.ProxySecurity.AllowPlaintextAPIKeys,
+			StoreFailOpen:         strings.EqualFold(configFromYaml.ProxySecurity.StoreFailMode, "open"),
+			SlowThreshold:         configFromYaml.SlowRequestThreshold,
+			Via:                   configFromYaml.Via,
+			RequestTimeout:        configFromYaml.RequestTimeout,
+			JWT: handlers.JWTOptions{
+				Alg:           configFromYaml.ProxySecurity.JWTAlg,
+				PublicKeyFile: configFromYaml.ProxySecurity.JWTPublicKey,
+				Issuer:        configFromYaml.ProxySecurity.JWTIssuer,
+				Audience:      configFromYaml.ProxySecurity.JWTAudience,
+				Header:        configFromYaml.ProxySecurity.JWTHeaderName,
+				Scheme:        configFromYaml.ProxySecurity.JWTScheme,
+			},
+			HMAC: handlers.HMACOptions{
+				Tolerance:   hmacOpts.Tolerance,
+				NonceHeader: hmacOpts.NonceHeader,
+				MaxBody:     hmacOpts.MaxBody,
+			},
+		}
+		switch hmacOpts.NonceStore {
+		case "memory":
+			h.HMAC.Nonces = nonce.NewMemoryStore()
+		case "redis":
+			h.HMAC.Nonces = nonce.NewRedisStore(
+				redisdb.GetRedisDbClient(hmacOpts.RedisURI, hmacOpts.RedisPass),
+				"ngonx:nonce:",
+			)
 		}
 
 		if generateApiKey {
 			word := genkey.StringWithCharset()
 			apiKey := genkey.ApiKeyGenerator(word)
-			_, err := h.Service.SaveSecretKEY(engine, key, apiKey)
+			stored, err := saveSecret(&h, engine, key, securityType, apiKey)
 			if err != nil {
 				logger.LogError(errors.Errorf("proxy: failed genkey cmd %v", err).Error())
 			}
+			if stored != apiKey {
+				// only the hash is stored, the key can't be read back later
+				fmt.Printf("apikey: %s (id %s)\n", apiKey, genkey.APIKeyID(stored))
+			}
 			logger.LogInfo("proxy: genkey cmd was susscefull")
 		}
 		if prevKey != "" {
-			_, err := h.Service.SaveSecretKEY(engine, key, prevKey)
+			_, err := saveSecret(&h, engine, key, securityType, prevKey)
 			if err != nil {
 				logger.LogError(errors.Errorf("proxy: failed prevKey cmd %v", err).Error())
 			}
 			logger.LogInfo("proxy: prevKey cmd was Susscefull")
 		}
+		if revokeKey != "" {
+			revoked := 0
+			err := h.Service.UpdateKEYs(engine, key, func(keys []string) []string {
+				var kept []string
+				for _, k := range keys {
+					if handlers.MatchAPIKey(k, revokeKey) {
+						revoked++
+						continue
+					}
+					kept = append(kept, k)
+				}
+				return kept
+			})
+			if err != nil {
+				logger.LogError(errors.Errorf("proxy: failed revokekey cmd %v", err).Error())
+			}
+			logger.LogInfo(fmt.Sprintf("proxy: revokekey cmd revoked %d keys", revoked))
+		}
 
+		if err := otelify.ConfigureLatencyClasses(configFromYaml.LatencyClasses); err != nil {
+			logger.LogError(errors.Errorf("proxy: %v", err).Error())
+			return
+		}
 		for _, endpoints := range configFromYaml.ProxyGateway.EnpointsProxy {
 			h.ProxyGateway(endpoints, engine, key, securityType)
 		}
+		// only the services are reloaded, the gateway settings need a restart
+		reload := func() error {
+			reloadMu.Lock()
+			defer reloadMu.Unlock()
+			cfg, err := config.LoadConfig(cfgPath, cfgFile)
+			if err != nil {
+				return err
+			}
+			if err := h.Reload(cfg.ProxyGateway.EnpointsProxy, engine, key, securityType); err != nil {
+				return err
+			}
+			live, _ := runningConfig.Load().(config.Config)
+			live.ProxyGateway.EnpointsProxy = cfg.ProxyGateway.EnpointsProxy
+			runningConfig.Store(live)
+			return nil
+		}
+		reloadRoutes.Store(reload)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := reload(); err != nil {
+					logger.LogError(errors.Errorf("proxy: reload %v", err).Error())
+				}
+			}
+		}()
+
+		trusted := configFromYaml.TrustedProxies
+		if err := handlers.TrustedProxies.Configure(handlers.TrustOptions{
+			Count: trusted.Count,
+			CIDRs: trusted.CIDRs,
+		}); err != nil {
+			logger.LogError(errors.Errorf("proxy: trusted proxies disabled %v", err).Error())
+		}
+		if keyed := clientIPSettings(configFromYaml); len(keyed) > 0 && trusted.Count <= 0 && len(trusted.CIDRs) == 0 {
+			logger.LogWarn(fmt.Sprintf(
+				"proxy: %s use the leftmost X-Forwarded-For as the client ip, any client can forge it, set trusted_proxies",
+				strings.Join(keyed, ", "),
+			))
+		}
+		debugDelay := configFromYaml.DebugDelay
+		if err := handlers.DebugDelay.Configure(handlers.DelayOptions{
+			Enable:      debugDelay.Enable,
+			Delay:       debugDelay.Delay,
+			ClientCIDRs: debugDelay.ClientCIDRs,
+			Header:      debugDelay.Header,
+		}); err != nil {
+			logger.LogError(errors.Errorf("proxy: debug delay disabled %v", err).Error())
+		}
+		mux, err := handlers.TrailingSlash(configFromYaml.TrailingSlash, http.DefaultServeMux)
+		if err != nil {
+			logger.LogError(errors.Errorf("proxy: trailing slash normalization disabled %v", err).Error())
+		}
+		handler := handlers.Recover(handlers.ClientConcurrencyLimit(
+			configFromYaml.MaxConnsPerIP,
+			handlers.QueryLimit(
+				configFromYaml.MaxQueryLength,
+				configFromYaml.MaxQueryParams,
+				handlers.DebugDelay.Handler(mux),
+			),
+		))
+		forwardTLS := configFromYaml.ProxySSL.ForwardTLS
+		handler = handlers.ForwardTLS(handlers.TLSForwardOptions{
+			Version:    forwardTLS.Version,
+			Cipher:     forwardTLS.Cipher,
+			ClientCert: forwardTLS.ClientCert,
+		}, handler)
+		if accessOpts := configFromYaml.AccessLog; accessOpts.Path != "" {
+			accessLog, err := logger.NewAccessLog(logger.FileOptions{
+				Path:        accessOpts.Path,
+				MaxSize:     accessOpts.MaxSizeMB,
+				MaxBackups:  accessOpts.MaxBackups,
+				MaxAge:      accessOpts.MaxAgeDays,
+				Compress:    accessOpts.Compress,
+				RotateEvery: accessOpts.RotateEvery,
+			})
+			if err != nil {
+				logger.LogError(errors.Errorf("proxy: access log disabled %v", err).Error())
+			} else {
+				defer accessLog.Close()
+				handler = accessLog.Handler(handler)
+			}
+		}
+		if captureOpts := configFromYaml.Capture; captureOpts.Path != "" {
+			requests, err := capture.New(capture.Options{
+				Path:         captureOpts.Path,
+				SampleRate:   captureOpts.SampleRate,
+				Bodies:       captureOpts.Bodies,
+				MaxBodyBytes: captureOpts.MaxBodyBytes,
+				MaxSize:      captureOpts.MaxSizeMB,
+				MaxBackups:   captureOpts.MaxBackups,
+				Redact:       append(h.CredentialHeaders(), captureOpts.Redact...),
+			})
+			if err != nil {
+				logger.LogError(errors.Errorf("proxy: request capture disabled %v", err).Error())
+			} else {
+				defer requests.Close()
+				handler = requests.Handler(handler)
+			}
+		}
+
+		var enabled []string
+		if enableMetric {
+			enabled = append(enabled, "metrics")
+		}
+		if enableOtlpLogs {
+			enabled = append(enabled, "otlp_logs")
+		}
+		// outermost so the access log and every middleware see the id
+		if requestID := configFromYaml.RequestID; requestID.Header != "" && requestID.PropagateOnly {
+			handler = handlers.RequestID(requestID.Header, nil, handler)
+		} else if requestID.Header != "" {
+			generate, err := reqid.NewGenerator(requestID.Format)
+			if err != nil {
+				logger.LogError(errors.Errorf("proxy: request id disabled %v", err).Error())
+			} else {
+				handler = handlers.RequestID(requestID.Header, generate, handler)
+			}
+		}
 
 		if configFromYaml.ProxySSL.Enable {
 			portSSL := configFromYaml.ProxyGateway.Port + configFromYaml.ProxySSL.SSLPort
+			listen := []string{fmt.Sprintf("https://%s:%d", configFromYaml.ProxyGateway.Host, portSSL)}
+			if configFromYaml.ProxySSL.HTTP3 {
+				listen = append(listen, fmt.Sprintf("h3://%s:%d", configFromYaml.ProxyGateway.Host, portSSL))
+			}
+			logProxySummary(configFromYaml, listen, enabled)
 			server := httpsrv.NewServerSSL(
 				configFromYaml.ProxyGateway.Host,
 				portSSL,
-				nil,
+				handler,
 			)
+			server.LimitConnections(configFromYaml.MaxConnections, configFromYaml.MaxConnectionsMode == "reject")
+			server.DrainTimeout(configFromYaml.DrainTimeout)
+			if err := server.ConfigureTLS(httpsrv.TLSOptions{
+				CrtFile:      configFromYaml.ProxySSL.CrtFile,
+				KeyFile:      configFromYaml.ProxySSL.KeyFile,
+				MinVersion:   configFromYaml.ProxySSL.MinVersion,
+				CipherSuites: configFromYaml.ProxySSL.CipherSuites,
+			}); err != nil {
+				logger.LogError(errors.Errorf("proxy: %v", err).Error())
+				return
+			}
+			if configFromYaml.ProxySSL.HTTP3 {
+				server.EnableHTTP3()
+			}
+			if caFile := configFromYaml.ProxySSL.ClientCAFile; caFile != "" {
+				if err := server.VerifyClientCerts(caFile); err != nil {
+					logger.LogError(errors.Errorf("proxy: client certificates disabled %v", err).Error())
+				}
+			}
 			server.StartSSL(
 				configFromYaml.ProxySSL.CrtFile,
 				configFromYaml.ProxySSL.KeyFile,
 			)
 		} else {
 			port = configFromYaml.ProxyGateway.Port + port
+			logProxySummary(configFromYaml, []string{fmt.Sprintf("http://%s:%d", configFromYaml.ProxyGateway.Host, port)}, enabled)
 			server := httpsrv.NewServer(
 				configFromYaml.ProxyGateway.Host,
 				port,
-				nil,
+				handler,
 			)
+			server.LimitConnections(configFromYaml.MaxConnections, configFromYaml.MaxConnectionsMode == "reject")
+			server.DrainTimeout(configFromYaml.DrainTimeout)
 			server.Start()
 		}
 	},
 }
 
+// clientIPSettings the configured settings keyed by the client ip
+func clientIPSettings(cfg config.Config) []string {
+	var keyed []string
+	if cfg.MaxConnsPerIP > 0 {
+		keyed = append(keyed, "max_conns_per_ip")
+	}
+	for _, service := range cfg.ProxyGateway.EnpointsProxy {
+		if len(service.AllowCIDRs) > 0 || len(service.DenyCIDRs) > 0 {
+			keyed = append(keyed, service.Name+" allow_cidrs/deny_cidrs")
+		}
+		if service.RateLimit.RPS > 0 {
+			keyed = append(keyed, service.Name+" rate_limit")
+		}
+	}
+	return keyed
+}
+
+// newProxyRepository returns the secret store of the engine, memory or badger
+func newProxyRepository(engine string) domain.ProxyRepository {
+	if engine == "memory" {
+		return domain.NewProxyRepository()
+	}
+	clientBadger := badgerdb.GetBadgerDB(context.Background(), false)
+	return domain.NewProxyRepository(clientBadger)
+}
+
+// saveSecret save the secret of the security type, api keys are hashed and added
+// to the set of valid keys while the jwt and hmac secrets replace the previous one
+func saveSecret(h *handlers.ProxyHandler, engine, key, securityType, secret string) (string, error) {
+	stored, err := handlers.StoredSecret(securityType, secret)
+	if err != nil {
+		return "", err
+	}
+	if securityType == "apikey" {
+		return stored, h.Service.AddSecretKEY(engine, key, stored)
+	}
+	_, err = h.Service.SaveSecretKEY(engine, key, stored)
+	return stored, err
+}
+
 func init() {
 	proxyCmd.Flags().Int(flagPort, 5000, "Port to serve to run proxy")
 	proxyCmd.Flags().Bool(flagGenApiKey, false, "Action for generate hash for protected routes")
 	proxyCmd.Flags().Bool(flagMetric, false, "Action for enable metrics OTEL")
+	proxyCmd.Flags().Bool(flagOtlpLogs, false, "Action for export logs as OTLP logs correlated by traceID")
 	proxyCmd.Flags().String(flagPrevKey, "", "Action for save a previous hash for protected routes to validate JWT")
+	proxyCmd.Flags().String(flagRevokeKey, "", "Action for revoke an api key, the other keys stay valid")
 	rootCmd.AddCommand(proxyCmd)
 
 }
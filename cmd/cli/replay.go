@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/capture"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay the captured requests against a backend and report the response diffs",
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := cmd.Flags().GetString(flagCaptureFile)
+		if err != nil {
+			logger.LogError(errors.Errorf("replay: %v", err).Error())
+		}
+		target, err := cmd.Flags().GetString(flagTarget)
+		if err != nil {
+			logger.LogError(errors.Errorf("replay: %v", err).Error())
+		}
+		headers, err := cmd.Flags().GetStringArray(flagHeader)
+		if err != nil {
+			logger.LogError(errors.Errorf("replay: %v", err).Error())
+		}
+		timeout, err := cmd.Flags().GetDuration(flagTimeout)
+		if err != nil {
+			logger.LogError(errors.Errorf("replay: %v", err).Error())
+		}
+
+		if target == "" {
+			fmt.Fprintln(os.Stderr, errors.Errorf("replay: %w", errors.ErrReplayTarget))
+			os.Exit(1)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Errorf("replay: %w: %v", errors.ErrCaptureFile, err))
+			os.Exit(1)
+		}
+		records, err := capture.Read(f)
+		_ = f.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Errorf("replay: %v", err))
+			os.Exit(1)
+		}
+		extra := make(http.Header)
+		for _, header := range headers {
+			at := strings.Index(header, ":")
+			if at < 1 {
+				fmt.Fprintln(os.Stderr, errors.Errorf("replay: %w: %q", errors.ErrReplayHeader, header))
+				os.Exit(1)
+			}
+			extra.Add(strings.TrimSpace(header[:at]), strings.TrimSpace(header[at+1:]))
+		}
+
+		client := &http.Client{
+			Timeout: timeout,
+			// the captured response of a redirect is the redirect itself
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		diffs := 0
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "METHOD\tURI\tSTATUS\tRESULT")
+		for _, record := range records {
+			result, status := replayRecord(client, target, extra, record)
+			if result != "same" && !strings.HasPrefix(result, "skipped") {
+				diffs++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", record.Method, record.URI, status, result)
+		}
+		_ = w.Flush()
+		fmt.Printf("%d requests, %d diffs\n", len(records), diffs)
+
+		if diffs > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// maxReplayBody bytes of the candidate response read to compare it
+const maxReplayBody = 16 << 20
+
+// replayRecord send the captured request to the target and compare the response
+// with the captured one, the status column shows captured->candidate
+func replayRecord(client *http.Client, target string, extra http.Header, record capture.Record) (result, status string) {
+	if record.BodyTruncated {
+		return "skipped: request body truncated", fmt.Sprintf("%d", record.Status)
+	}
+	req, err := http.NewRequest(record.Method, strings.TrimSuffix(target, "/")+record.URI, bytes.NewReader(record.Body))
+	if err != nil {
+		return "invalid: " + err.Error(), fmt.Sprintf("%d", record.Status)
+	}
+	for name, values := range record.Header {
+		for _, value := range values {
+			// secrets were never captured, --header supplies them
+			if value != capture.Redacted {
+				req.Header.Add(name, value)
+			}
+		}
+	}
+	for name, values := range extra {
+		req.Header[name] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "error: " + err.Error(), fmt.Sprintf("%d->-", record.Status)
+	}
+	defer resp.Body.Close()
+	status = fmt.Sprintf("%d->%d", record.Status, resp.StatusCode)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxReplayBody))
+	if err != nil {
+		return "error: " + err.Error(), status
+	}
+
+	var diff []string
+	if resp.StatusCode != record.Status {
+		diff = append(diff, "status")
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != record.ContentType {
+		diff = append(diff, "content-type")
+	}
+	if !sameBody(record, body) {
+		diff = append(diff, "body")
+	}
+	if len(diff) == 0 {
+		return "same", status
+	}
+	return "diff: " + strings.Join(diff, ","), status
+}
+
+// sameBody compare the captured response body, json bodies regardless of the
+// order of the keys and truncated ones only the captured prefix
+func sameBody(record capture.Record, body []byte) bool {
+	if record.ResponseTruncated {
+		return len(body) >= len(record.ResponseBody) && bytes.Equal(body[:len(record.ResponseBody)], record.ResponseBody)
+	}
+	var captured, candidate interface{}
+	if json.Unmarshal(record.ResponseBody, &captured) == nil && json.Unmarshal(body, &candidate) == nil {
+		return reflect.DeepEqual(captured, candidate)
+	}
+	return bytes.Equal(body, record.ResponseBody)
+}
+
+func init() {
+	replayCmd.Flags().String(flagCaptureFile, "", "Capture file written by the proxy (proxy.capture.path)")
+	replayCmd.Flags().String(flagTarget, "", "Base url of the candidate backend e.g. http://localhost:5000")
+	replayCmd.Flags().StringArray(flagHeader, nil, "Headers added to every request e.g. \"Authorization: Bearer <token>\"")
+	replayCmd.Flags().Duration(flagTimeout, 10*time.Second, "Timeout of each replayed request")
+
+	rootCmd.AddCommand(replayCmd)
+}
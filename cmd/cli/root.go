@@ -2,20 +2,24 @@ package cli
 
 import (
 	"embed"
+	"encoding/json"
 	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	domain "github.com/kenriortega/ngonx/internal/mngt/domain"
 	handlers "github.com/kenriortega/ngonx/internal/mngt/handlers"
 	services "github.com/kenriortega/ngonx/internal/mngt/services"
+	proxyhandlers "github.com/kenriortega/ngonx/internal/proxy/handlers"
 	"github.com/kenriortega/ngonx/pkg/config"
 	"github.com/kenriortega/ngonx/pkg/errors"
 	"github.com/kenriortega/ngonx/pkg/healthcheck"
 	"github.com/kenriortega/ngonx/pkg/httpsrv"
+	"github.com/kenriortega/ngonx/pkg/ipfilter"
 	"github.com/kenriortega/ngonx/pkg/logger"
 	"github.com/rs/cors"
 	"github.com/spf13/cobra"
@@ -45,6 +49,59 @@ func readinessHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// debugDelayHandler returns the state of the debug delay, PUT `?enable=true|false` toggles it
+func debugDelayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		enable, err := strconv.ParseBool(r.URL.Query().Get("enable"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		proxyhandlers.DebugDelay.SetEnabled(enable)
+	}
+	enabled, delay := proxyhandlers.DebugDelay.Options()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"enable": enabled,
+		"delay":  delay.String(),
+	})
+}
+
+// reloadHandler rebuild the proxy routes from the config file, an invalid
+// config is rejected with 422 and the running routes are kept
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	reload, _ := reloadRoutes.Load().(func() error)
+	if reload == nil {
+		http.Error(w, "proxy is not running", http.StatusServiceUnavailable)
+		return
+	}
+	if err := reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"reloaded": true})
+}
+
+// configHandler returns the effective config as json with the secrets redacted,
+// the proxy services live after the last reload and the lb backends with their state
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, _ := runningConfig.Load().(config.Config)
+	out := config.Export(cfg)
+	if backends := proxyhandlers.ServerPool.Backends(); len(backends) > 0 {
+		list := make([]map[string]interface{}, 0, len(backends))
+		for _, b := range backends {
+			list = append(list, backendState(b))
+		}
+		out["lb"] = map[string]interface{}{
+			"strategy": proxyhandlers.ServerPool.Strategy,
+			"backends": list,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "ngonxctl",
@@ -69,6 +126,7 @@ func init() {
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	configFromYaml, errConfig = config.LoadConfig(cfgPath, cfgFile)
+	runningConfig.Store(configFromYaml)
 
 	if errConfig != nil {
 		logger.LogError(errors.Errorf("ngonx: Yaml file not found please run command setup :%v", errConfig).Error())
@@ -77,6 +135,15 @@ func initConfig() {
 
 }
 
+// adminFilter returns the ip filter for the admin api and metrics listeners
+func adminFilter(config config.Config) (func(http.Handler) http.Handler, error) {
+	nets, err := ipfilter.ParseCIDRs(config.Admin.TrustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return ipfilter.TrustedOnly(nets, config.Admin.DropUntrusted), nil
+}
+
 func StartMngt(config config.Config) {
 
 	stripped, err := fs.Sub(frontend, "ui")
@@ -107,6 +174,9 @@ func StartMngt(config config.Config) {
 	mngtAPI.HandleFunc("/", mh.GetAllEndpoints)
 	mngtAPI.HandleFunc("/health", healthHandler)
 	mngtAPI.HandleFunc("/readiness", readinessHandler)
+	mngtAPI.HandleFunc("/debug-delay", debugDelayHandler).Methods(http.MethodGet, http.MethodPut)
+	mngtAPI.HandleFunc("/reload", reloadHandler).Methods(http.MethodPost)
+	mngtAPI.HandleFunc("/config", configHandler).Methods(http.MethodGet)
 	// Realtime options
 	mngtAPI.HandleFunc("/wss", mh.WssocketHandler)
 
@@ -114,10 +184,15 @@ func StartMngt(config config.Config) {
 	mgntWEB.Handler(http.StripPrefix("/", frontendFS))
 	port := 10_001
 	cors.Default()
+	trustedOnly, err := adminFilter(config)
+	if err != nil {
+		logger.LogError(errors.Errorf("ngonx: admin api disabled :%v", err).Error())
+		return
+	}
 	server := httpsrv.NewServer(
 		"0.0.0.0",
 		port,
-		r,
+		trustedOnly(r),
 	)
 
 	go func() {
@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"fmt"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/config"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// routeSummary effective config of a route in the startup summary
+type routeSummary struct {
+	Service    string   `json:"service"`
+	Path       string   `json:"path"`
+	Upstream   string   `json:"upstream"`
+	Auth       string   `json:"auth"`
+	Middleware []string `json:"middleware,omitempty"`
+}
+
+// backendSummary backend of the load balancer in the startup summary
+type backendSummary struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// logProxySummary log a single line with the effective config of the proxy
+// once every source (file, env and flags) was applied
+func logProxySummary(cfg config.Config, listen []string, flags []string) {
+	gateway := cfg.ProxyGateway
+	var routes []routeSummary
+	for _, service := range gateway.EnpointsProxy {
+		for _, endpoint := range service.Endpoints {
+			auth := "none"
+			if endpoint.PathProtected {
+				auth = gateway.ProxySecurity.Type
+			}
+			upstream := service.HostURI + endpoint.PathEndpoint
+			if endpoint.RewriteTo != "" {
+				upstream = service.HostURI + endpoint.RewriteTo
+			}
+			routes = append(routes, routeSummary{
+				Service:    service.Name,
+				Path:       endpoint.PathToProxy,
+				Upstream:   upstream,
+				Auth:       auth,
+				Middleware: routeMiddleware(service, endpoint),
+			})
+		}
+	}
+
+	middleware := append([]string{}, flags...)
+	if gateway.AccessLog.Path != "" {
+		middleware = append(middleware, "access_log")
+	}
+	if gateway.Capture.Path != "" {
+		middleware = append(middleware, fmt.Sprintf("capture=%g", gateway.Capture.SampleRate))
+	}
+	if gateway.MaxConnsPerIP > 0 {
+		middleware = append(middleware, fmt.Sprintf("max_conns_per_ip=%d", gateway.MaxConnsPerIP))
+	}
+	if gateway.MaxConnections > 0 {
+		middleware = append(middleware, fmt.Sprintf("max_connections=%d", gateway.MaxConnections))
+	}
+	if gateway.MaxQueryLength > 0 {
+		middleware = append(middleware, fmt.Sprintf("max_query_length=%d", gateway.MaxQueryLength))
+	}
+	if gateway.MaxQueryParams > 0 {
+		middleware = append(middleware, fmt.Sprintf("max_query_params=%d", gateway.MaxQueryParams))
+	}
+	if gateway.RequestTimeout > 0 {
+		middleware = append(middleware, "request_timeout="+gateway.RequestTimeout.String())
+	}
+	if gateway.SlowRequestThreshold > 0 {
+		middleware = append(middleware, "slow_request_threshold="+gateway.SlowRequestThreshold.String())
+	}
+	if len(gateway.LatencyClasses) > 0 {
+		middleware = append(middleware, fmt.Sprintf("latency_classes=%d", len(gateway.LatencyClasses)))
+	}
+	if gateway.TrustedProxies.Count > 0 {
+		middleware = append(middleware, fmt.Sprintf("trusted_proxies=%d", gateway.TrustedProxies.Count))
+	}
+	if len(gateway.TrustedProxies.CIDRs) > 0 {
+		middleware = append(middleware, fmt.Sprintf("trusted_proxy_cidrs=%v", gateway.TrustedProxies.CIDRs))
+	}
+	if ssl := gateway.ProxySSL; ssl.Enable && ssl.MinVersion != "" {
+		middleware = append(middleware, "tls_min_version="+ssl.MinVersion)
+	}
+	if ssl := gateway.ProxySSL; ssl.Enable && len(ssl.CipherSuites) > 0 {
+		middleware = append(middleware, fmt.Sprintf("tls_cipher_suites=%v", ssl.CipherSuites))
+	}
+	if gateway.Via != "" {
+		middleware = append(middleware, "via="+gateway.Via)
+	}
+	if gateway.ProxySecurity.Type == "jwt" && gateway.ProxySecurity.JWTAlg != "" {
+		middleware = append(middleware, "jwt_alg="+gateway.ProxySecurity.JWTAlg)
+	}
+	if gateway.ProxySecurity.SkipAuthPreflight {
+		middleware = append(middleware, "skip_auth_preflight")
+	}
+	if gateway.ProxySecurity.AllowPlaintextAPIKeys {
+		middleware = append(middleware, "allow_plaintext_apikeys")
+	}
+	if gateway.ProxySecurity.StoreFailMode != "" {
+		middleware = append(middleware, "store_fail_mode="+gateway.ProxySecurity.StoreFailMode)
+	}
+	if gateway.TrailingSlash != "" {
+		middleware = append(middleware, "trailing_slash="+gateway.TrailingSlash)
+	}
+	if gateway.RequestID.Header != "" {
+		middleware = append(middleware, "request_id="+gateway.RequestID.Header)
+		if gateway.RequestID.PropagateOnly {
+			middleware = append(middleware, "request_id_propagate_only")
+		}
+	}
+	if gateway.DebugDelay.Enable {
+		middleware = append(middleware, "debug_delay="+gateway.DebugDelay.Delay.String())
+	}
+
+	logger.LogInfo(
+		"proxy: startup summary",
+		zap.Int("routes", len(routes)),
+		zap.Any("route_list", routes),
+		zap.String("security", gateway.ProxySecurity.Type),
+		zap.String("cache_engine", gateway.ProxyCache.Engine),
+		zap.Strings("middleware", middleware),
+		zap.Strings("listen", listen),
+	)
+}
+
+// routeMiddleware features enabled on a route
+func routeMiddleware(service domain.ProxyEndpoint, endpoint domain.Endpoint) []string {
+	var middleware []string
+	if service.TLSServerName != "" {
+		middleware = append(middleware, "tls_server_name="+service.TLSServerName)
+	}
+	if service.UpstreamTLS.CAFile != "" {
+		middleware = append(middleware, "upstream_tls_ca_file="+service.UpstreamTLS.CAFile)
+	}
+	if service.UpstreamTLS.CertFile != "" {
+		middleware = append(middleware, "upstream_tls_client_cert")
+	}
+	if service.UpstreamTLS.InsecureSkipVerify {
+		middleware = append(middleware, "upstream_tls_insecure_skip_verify")
+	}
+	if service.Compression != "" {
+		middleware = append(middleware, "compression="+service.Compression)
+	}
+	if service.UpstreamProtocol != "" {
+		middleware = append(middleware, "upstream_protocol="+service.UpstreamProtocol)
+	}
+	if service.HTTP2.MaxConcurrentStreams > 0 {
+		middleware = append(middleware, fmt.Sprintf("http2_max_concurrent_streams=%d", service.HTTP2.MaxConcurrentStreams))
+	}
+	if len(service.Audiences) > 0 && endpoint.PathProtected {
+		middleware = append(middleware, fmt.Sprintf("audiences=%v", service.Audiences))
+	}
+	if len(service.AllowCIDRs) > 0 {
+		middleware = append(middleware, fmt.Sprintf("allow_cidrs=%v", service.AllowCIDRs))
+	}
+	if len(service.DenyCIDRs) > 0 {
+		middleware = append(middleware, fmt.Sprintf("deny_cidrs=%v", service.DenyCIDRs))
+	}
+	if service.RateLimit.RPS > 0 {
+		middleware = append(middleware, fmt.Sprintf("rate_limit=%g/s", service.RateLimit.RPS))
+	}
+	if service.Deprecation.Enable {
+		middleware = append(middleware, "deprecation")
+	}
+	if service.Signing.Scheme != "" {
+		middleware = append(middleware, "signing="+service.Signing.Scheme)
+	}
+	if service.CookieRoute.Name != "" {
+		middleware = append(middleware, "cookie_route="+service.CookieRoute.Name)
+	}
+	if service.SizeRoute.Threshold > 0 {
+		middleware = append(middleware, fmt.Sprintf("size_route>%d", service.SizeRoute.Threshold))
+	}
+	if service.BufferResponses {
+		middleware = append(middleware, "buffer_responses")
+	}
+	if endpoint.UpstreamScheme != "" {
+		middleware = append(middleware, "upstream_scheme="+endpoint.UpstreamScheme)
+	}
+	if endpoint.RewriteTo != "" {
+		middleware = append(middleware, "rewrite_to="+endpoint.RewriteTo)
+	}
+	if len(endpoint.Query) > 0 {
+		middleware = append(middleware, fmt.Sprintf("query=%v", endpoint.Query))
+	}
+	switch {
+	case endpoint.Timeout > 0:
+		middleware = append(middleware, "timeout="+endpoint.Timeout.String())
+	case service.Timeout > 0 && endpoint.StreamIdleTimeout == 0:
+		middleware = append(middleware, "timeout="+service.Timeout.String())
+	}
+	if endpoint.StreamIdleTimeout > 0 {
+		middleware = append(middleware, "stream_idle_timeout="+endpoint.StreamIdleTimeout.String())
+	}
+	if endpoint.RetryAfter.MaxWait > 0 {
+		middleware = append(middleware, "retry_after="+endpoint.RetryAfter.MaxWait.String())
+	}
+	if endpoint.LatencyClass != "" {
+		middleware = append(middleware, "latency_class="+endpoint.LatencyClass)
+	}
+	if endpoint.BodyReadTimeout > 0 {
+		middleware = append(middleware, "body_read_timeout="+endpoint.BodyReadTimeout.String())
+	}
+	if endpoint.WebSocket {
+		middleware = append(middleware, "websocket")
+	}
+	if endpoint.MaxConcurrent > 0 {
+		middleware = append(middleware, fmt.Sprintf("max_concurrent=%d", endpoint.MaxConcurrent))
+	}
+	if endpoint.LeakyBucket.Rate > 0 {
+		middleware = append(middleware, fmt.Sprintf("leaky_bucket=%g/s", endpoint.LeakyBucket.Rate))
+	}
+	if endpoint.RewriteURLs.Enable {
+		middleware = append(middleware, "rewrite_urls")
+	}
+	if endpoint.Buffering.Enable {
+		middleware = append(middleware, "buffering")
+	}
+	if endpoint.Cache.TTL > 0 {
+		middleware = append(middleware, "cache="+endpoint.Cache.TTL.String())
+		if endpoint.Cache.Authenticated {
+			middleware = append(middleware, "cache_authenticated")
+		}
+	}
+	if endpoint.Fallback.Body != "" || endpoint.Fallback.LastKnownGood {
+		middleware = append(middleware, "fallback")
+	}
+	if len(endpoint.StripHeaders) > 0 {
+		middleware = append(middleware, fmt.Sprintf("strip_headers=%v", endpoint.StripHeaders))
+	}
+	if len(endpoint.MethodOverride) > 0 {
+		middleware = append(middleware, "method_override")
+	}
+	if len(endpoint.StatusRemap) > 0 {
+		middleware = append(middleware, "status_remap")
+	}
+	if endpoint.NormalizeHeaders.Canonicalize || len(endpoint.NormalizeHeaders.Merge) > 0 {
+		middleware = append(middleware, "normalize_headers")
+	}
+	if endpoint.ExpectedContentType != "" {
+		middleware = append(middleware, "expected_content_type="+endpoint.ExpectedContentType)
+	}
+	if endpoint.PathParams.Pattern != "" {
+		middleware = append(middleware, "path_params")
+	}
+	return middleware
+}
+
+// logLBSummary log a single line with the effective config of the load balancer
+func logLBSummary(backends []backendSummary, listen string, middleware []string) {
+	logger.LogInfo(
+		"lb: startup summary",
+		zap.Int("backends", len(backends)),
+		zap.Any("backend_list", backends),
+		zap.Strings("middleware", middleware),
+		zap.String("listen", listen),
+	)
+}
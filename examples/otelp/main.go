@@ -98,7 +98,7 @@ func instrumentedServer(handler http.HandlerFunc) *http.Server {
 		ctx := r.Context()
 		traceID := trace.SpanContextFromContext(ctx).TraceID().String()
 
-		otelify.MetricRequestLatencyProxy.(prometheus.ExemplarObserver).ObserveWithExemplar(
+		otelify.MetricRequestLatencyProxy.WithLabelValues(r.URL.Path).(prometheus.ExemplarObserver).ObserveWithExemplar(
 			time.Since(start).Seconds(), prometheus.Labels{"traceID": traceID},
 		)
 
@@ -1,16 +1,22 @@
 package proxy
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/kenriortega/ngonx/pkg/errors"
 	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"go.uber.org/zap"
 )
 
 // Load Balancer data structures...
@@ -19,6 +25,28 @@ type AttemptsOrRetry int
 const (
 	ATTEMPTS AttemptsOrRetry = iota
 	RETRY
+	// UPSTREAM_CALLS state of the upstream calls limit of the request
+	UPSTREAM_CALLS
+)
+
+// balancing strategies of ServerPool
+const (
+	StrategyRoundRobin = "roundrobin"
+	StrategyEWMA       = "ewma"
+	StrategyLeastBytes = "leastbytes"
+)
+
+// defaultHealthCheckTimeout limit of each health check probe
+const defaultHealthCheckTimeout = 2 * time.Second
+
+// ewmaAlpha weight of the last response time (and response size) in the moving average
+const ewmaAlpha = 0.3
+
+// MaxHealthScore health score of a fully healthy backend, failing health checks
+// halve the score and passing ones add healthRecoverStep, at 0 the backend is ejected
+const (
+	MaxHealthScore    = 10
+	healthRecoverStep = 2
 )
 
 // Backend holds the data about a server
@@ -27,15 +55,292 @@ type Backend struct {
 	Alive        bool
 	mux          sync.RWMutex
 	ReverseProxy *httputil.ReverseProxy
+	// Weight share of the traffic relative to the other backends, 0 is 1
+	Weight int
+	// StripHeaders request headers never sent to the backend, see handlers.StripHeaders
+	StripHeaders []string
+	// Transport and Host of the requests to the backend (egress proxy, tls
+	// trust, client certificate, sni and Host override), the health probes use
+	// them too. A nil Transport is the default one
+	Transport http.RoundTripper
+	Host      string
+	health    int
+	// fails and successes consecutive health check results
+	fails     int
+	successes int
+	// current smooth weighted round robin state, guarded by ServerPool.mux
+	current int
+	// ewma moving average of the response time in seconds and inflight
+	// requests of the backend, used by StrategyEWMA
+	ewma     float64
+	inflight int
+	// outstanding response bytes not yet read from the backend and moving
+	// average of its response sizes (the estimate without Content-Length),
+	// used by StrategyLeastBytes
+	outstanding int64
+	avgBytes    float64
+	// circuit breaker state, consecutive failed requests, the end of the open
+	// state (zero when closed) and the half-open probe in flight
+	circuitFails int
+	openUntil    time.Time
+	probing      bool
+	// checking health check of the backend waiting or running
+	checking bool
+	// draining out of rotation until its requests in flight end, see ServerPool.RemoveBackend
+	draining bool
+}
+
+// beginCheck returns false while the previous health check is in flight
+func (b *Backend) beginCheck() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.checking {
+		return false
+	}
+	b.checking = true
+	return true
 }
 
-// SetAlive for this backend
+func (b *Backend) endCheck() {
+	b.mux.Lock()
+	b.checking = false
+	b.mux.Unlock()
+}
+
+// SetAlive for this backend, the health score goes to the max or to 0
 func (b *Backend) SetAlive(alive bool) {
 	b.mux.Lock()
 	b.Alive = alive
+	b.health = 0
+	if alive {
+		b.health = MaxHealthScore
+	}
 	b.mux.Unlock()
 }
 
+// RecordHealth update the health score with the result of a health check,
+// a flapping backend loses weight gradually and recovers it the same way
+func (b *Backend) RecordHealth(ok bool) {
+	b.mux.Lock()
+	if ok {
+		b.health += healthRecoverStep
+		if b.health > MaxHealthScore {
+			b.health = MaxHealthScore
+		}
+	} else {
+		b.health /= 2
+	}
+	b.Alive = b.health > 0
+	b.mux.Unlock()
+}
+
+// recordStreak count the consecutive health check results
+func (b *Backend) recordStreak(ok bool) (fails, successes int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if ok {
+		b.successes++
+		b.fails = 0
+	} else {
+		b.fails++
+		b.successes = 0
+	}
+	return b.fails, b.successes
+}
+
+// degrade update the health score of a backend in rotation without ejecting it,
+// the thresholds decide when it goes down
+func (b *Backend) degrade(ok bool) {
+	b.mux.Lock()
+	if ok {
+		b.health += healthRecoverStep
+		if b.health > MaxHealthScore {
+			b.health = MaxHealthScore
+		}
+	} else if b.health /= 2; b.health < 1 {
+		b.health = 1
+	}
+	b.mux.Unlock()
+}
+
+// HealthScore returns the health score of the backend
+func (b *Backend) HealthScore() (score int) {
+	b.mux.RLock()
+	score = b.health
+	b.mux.RUnlock()
+	return
+}
+
+// EffectiveWeight weight used by the balancer, the configured weight scaled by
+// the health score so degraded backends get less traffic and ejected ones or
+// with an open circuit none
+func (b *Backend) EffectiveWeight() int {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	if !b.Alive || b.draining || b.circuitBlocked(time.Now()) {
+		return 0
+	}
+	weight := b.Weight
+	if weight < 1 {
+		weight = 1
+	}
+	return b.health * weight
+}
+
+// Begin track a request sent to the backend, the returned func must be called
+// once it`s answered to update the moving average of the response time. It
+// releases the half-open probe when the request ended without a result
+func (b *Backend) Begin() func() {
+	start := time.Now()
+	b.mux.Lock()
+	b.inflight++
+	b.mux.Unlock()
+	return func() {
+		elapsed := time.Since(start).Seconds()
+		b.mux.Lock()
+		b.inflight--
+		b.probing = false
+		if b.ewma == 0 {
+			b.ewma = elapsed
+		} else {
+			b.ewma = ewmaAlpha*elapsed + (1-ewmaAlpha)*b.ewma
+		}
+		ewma := b.ewma
+		b.mux.Unlock()
+		otelify.MetricBackendEWMA.WithLabelValues(b.URL.String()).Set(ewma)
+	}
+}
+
+// score cost of sending a request to the backend, lower is better. The response
+// time grows with the queued requests and shrinks with the effective weight
+func (b *Backend) score(weight int) float64 {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.ewma * float64(b.inflight+1) / float64(weight)
+}
+
+// TrackBytes count the response body as outstanding bytes of the backend until
+// it`s read or closed, the Content-Length or the average size of the previous
+// responses when it`s unknown
+func (b *Backend) TrackBytes(resp *http.Response) {
+	b.mux.Lock()
+	estimate := resp.ContentLength
+	if estimate < 0 {
+		estimate = int64(b.avgBytes)
+	}
+	b.outstanding += estimate
+	b.mux.Unlock()
+	otelify.MetricBackendOutstandingBytes.WithLabelValues(b.URL.String()).Add(float64(estimate))
+	resp.Body = &bytesBody{ReadCloser: resp.Body, backend: b, pending: estimate}
+}
+
+// releaseBytes remove read bytes from the outstanding ones
+func (b *Backend) releaseBytes(n int64) {
+	if n <= 0 {
+		return
+	}
+	b.mux.Lock()
+	b.outstanding -= n
+	b.mux.Unlock()
+	otelify.MetricBackendOutstandingBytes.WithLabelValues(b.URL.String()).Sub(float64(n))
+}
+
+// recordSize update the moving average of the response sizes
+func (b *Backend) recordSize(size int64) {
+	b.mux.Lock()
+	if b.avgBytes == 0 {
+		b.avgBytes = float64(size)
+	} else {
+		b.avgBytes = ewmaAlpha*float64(size) + (1-ewmaAlpha)*b.avgBytes
+	}
+	b.mux.Unlock()
+}
+
+// bytesScore outstanding bytes of the backend by unit of effective weight
+func (b *Backend) bytesScore(weight int) float64 {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return float64(b.outstanding) / float64(weight)
+}
+
+// bytesBody release the outstanding bytes of a response as it`s read, the
+// ones left (an aborted or shorter body) on Close
+type bytesBody struct {
+	io.ReadCloser
+	backend *Backend
+	pending int64
+	read    int64
+	once    sync.Once
+}
+
+func (r *bytesBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if done := int64(n); done > 0 && r.pending > 0 {
+		if done > r.pending {
+			done = r.pending
+		}
+		r.pending -= done
+		r.backend.releaseBytes(done)
+	}
+	return n, err
+}
+
+func (r *bytesBody) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(func() {
+		r.backend.releaseBytes(r.pending)
+		r.pending = 0
+		r.backend.recordSize(r.read)
+	})
+	return err
+}
+
+// circuitBlocked returns true while the circuit is open or its half-open
+// probe is in flight, it must be called with the lock held
+func (b *Backend) circuitBlocked(now time.Time) bool {
+	if b.openUntil.IsZero() {
+		return false
+	}
+	return now.Before(b.openUntil) || b.probing
+}
+
+// CircuitState returns closed, open or half-open (the cooldown elapsed)
+func (b *Backend) CircuitState() string {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	switch {
+	case b.openUntil.IsZero():
+		return CircuitClosed
+	case time.Now().Before(b.openUntil):
+		return CircuitOpen
+	}
+	return CircuitHalfOpen
+}
+
+// beginProbe takes the single request of a half-open circuit
+func (b *Backend) beginProbe() {
+	b.mux.Lock()
+	if !b.openUntil.IsZero() {
+		b.probing = true
+	}
+	b.mux.Unlock()
+}
+
+// Inflight returns the requests sent to the backend not answered yet
+func (b *Backend) Inflight() int {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.inflight
+}
+
+// Draining returns true once the backend is being removed from the pool
+func (b *Backend) Draining() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.draining
+}
+
 // IsAlive returns true when backend is alive
 func (b *Backend) IsAlive() (alive bool) {
 	b.mux.RLock()
@@ -44,25 +349,150 @@ func (b *Backend) IsAlive() (alive bool) {
 	return
 }
 
+// ID opaque id of the backend, the value of the affinity cookie doesn`t
+// disclose the backend url
+func (b *Backend) ID() string {
+	sum := sha256.Sum256([]byte(b.URL.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
 // ServerPool holds information about reachable backends
 type ServerPool struct {
 	backends []*Backend
-	current  uint64
+	mux      sync.Mutex
+	// FailThreshold consecutive failed health checks to mark a backend down and
+	// RiseThreshold consecutive passing ones to bring it back, with both at 0
+	// the health score alone ejects and recovers the backends
+	FailThreshold int
+	RiseThreshold int
+	// HealthCheckWorkers health checks running at once, 0 checks one backend at a time
+	HealthCheckWorkers int
+	// healthSlots bounds the probes in flight across the rounds, see HealthCheck
+	healthSlots chan struct{}
+	healthOnce  sync.Once
+	// HealthCheckPath path requested by the probes (`/` when it`s empty), the answers
+	// with a status of HealthCheckStatus (any 2xx and 3xx when it`s empty) are healthy.
+	// HealthCheckTCP only dials the backend, for non http ones
+	HealthCheckPath   string
+	HealthCheckStatus []int
+	HealthCheckTCP    bool
+	// HealthCheckTimeout limit of each probe, 0 uses 2s
+	HealthCheckTimeout time.Duration
+	// Strategy roundrobin (the default), ewma prefers the backends with the best
+	// response time and leastbytes the ones with the least response bytes in flight
+	Strategy string
+	// next first backend looked at by StrategyLeastBytes, rotates the ties
+	next int
+	// BreakerThreshold consecutive failed requests that open the circuit of a
+	// backend, it gets no traffic during BreakerCooldown and then a single request
+	// probes it (half-open). 0 disables the breaker
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// AffinityCookie name of the cookie pinning a client to its backend, empty
+	// balances every request
+	AffinityCookie string
 }
 
-// AddBackend to the server pool
+// circuit states of a backend
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// defaultBreakerCooldown time an open circuit waits before the half-open probe
+const defaultBreakerCooldown = 30 * time.Second
+
+// ValidStrategy returns true for the known balancing strategies
+func ValidStrategy(strategy string) bool {
+	switch strategy {
+	case "", StrategyRoundRobin, StrategyEWMA, StrategyLeastBytes:
+		return true
+	}
+	return false
+}
+
+// AddBackend to the server pool, safe while it serves. The list is copied on
+// write so the slices returned by Backends never change
 func (s *ServerPool) AddBackend(backend *Backend) {
-	s.backends = append(s.backends, backend)
+	backend.SetAlive(backend.Alive)
+	s.mux.Lock()
+	s.backends = append(s.backends[:len(s.backends):len(s.backends)], backend)
+	s.mux.Unlock()
+}
+
+// AddNewBackend add the backend unless one with its url is in the pool,
+// returns false in that case. The check and the insert are atomic
+func (s *ServerPool) AddNewBackend(backend *Backend) bool {
+	backend.SetAlive(backend.Alive)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, b := range s.backends {
+		if b.URL.String() == backend.URL.String() {
+			return false
+		}
+	}
+	s.backends = append(s.backends[:len(s.backends):len(s.backends)], backend)
+	return true
 }
 
-// NextIndex atomically increase the counter and return an index
-func (s *ServerPool) NextIndex() int {
-	return int(atomic.AddUint64(&s.current, uint64(1)) % uint64(len(s.backends)))
+// Backends returns the backends of the pool
+func (s *ServerPool) Backends() []*Backend {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.backends
+}
+
+// Backend returns the backend with the url, nil when it isn`t in the pool
+func (s *ServerPool) Backend(backendUrl *url.URL) *Backend {
+	for _, b := range s.Backends() {
+		if b.URL.String() == backendUrl.String() {
+			return b
+		}
+	}
+	return nil
+}
+
+// drainPoll interval between the checks of the requests in flight of a draining backend
+const drainPoll = 50 * time.Millisecond
+
+// RemoveBackend takes the backend out of rotation, waits up to timeout for its
+// requests in flight and drops it from the pool. It returns the requests still
+// in flight when the timeout elapsed, false when the backend isn`t in the pool
+func (s *ServerPool) RemoveBackend(backendUrl *url.URL, timeout time.Duration) (int, bool) {
+	b := s.Backend(backendUrl)
+	if b == nil {
+		return 0, false
+	}
+	b.mux.Lock()
+	b.draining = true
+	b.mux.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	inflight := b.Inflight()
+	for inflight > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPoll)
+		inflight = b.Inflight()
+	}
+
+	s.mux.Lock()
+	kept := make([]*Backend, 0, len(s.backends))
+	for _, backend := range s.backends {
+		if backend != b {
+			kept = append(kept, backend)
+		}
+	}
+	s.backends = kept
+	s.mux.Unlock()
+	if inflight > 0 {
+		logger.LogWarn("lb: backend removed with requests in flight", zap.String("backend", b.URL.String()), zap.Int("inflight", inflight))
+	}
+	return inflight, true
 }
 
 // MarkBackendStatus changes a status of a backend
 func (s *ServerPool) MarkBackendStatus(backendUrl *url.URL, alive bool) {
-	for _, b := range s.backends {
+	for _, b := range s.Backends() {
 		if b.URL.String() == backendUrl.String() {
 			b.SetAlive(alive)
 			break
@@ -70,39 +500,289 @@ func (s *ServerPool) MarkBackendStatus(backendUrl *url.URL, alive bool) {
 	}
 }
 
-// GetNextPeer returns next active peer to take a connection
-func (s *ServerPool) GetNextPeer() *Backend {
-	// loop entire backends to find out an Alive backend
-	next := s.NextIndex()
-	l := len(s.backends) + next // start from next and move a full cycle
-	for i := next; i < l; i++ {
-		idx := i % len(s.backends)     // take an index by modding
-		if s.backends[idx].IsAlive() { // if we have an alive backend, use it and store if its not the original one
-			if i != next {
-				atomic.StoreUint64(&s.current, uint64(idx))
+// RecordResult update the circuit of the backend with the result of a request,
+// a success closes it and BreakerThreshold failures in a row (or a failed
+// half-open probe) open it for BreakerCooldown
+func (s *ServerPool) RecordResult(backendUrl *url.URL, ok bool) {
+	if s.BreakerThreshold <= 0 {
+		return
+	}
+	for _, b := range s.Backends() {
+		if b.URL.String() != backendUrl.String() {
+			continue
+		}
+		b.mux.Lock()
+		wasOpen := !b.openUntil.IsZero()
+		opened := false
+		if ok {
+			b.circuitFails = 0
+			b.openUntil = time.Time{}
+		} else if b.circuitFails++; b.probing || b.circuitFails >= s.BreakerThreshold {
+			cooldown := s.BreakerCooldown
+			if cooldown <= 0 {
+				cooldown = defaultBreakerCooldown
 			}
-			return s.backends[idx]
+			b.openUntil = time.Now().Add(cooldown)
+			opened = true
+		}
+		b.probing = false
+		b.mux.Unlock()
+		switch {
+		case opened:
+			otelify.MetricCircuitOpens.WithLabelValues(b.URL.String()).Inc()
+			logger.LogWarn("lb: circuit opened", zap.String("backend", b.URL.String()))
+		case ok && wasOpen:
+			logger.LogInfo("lb: circuit closed", zap.String("backend", b.URL.String()))
+		}
+		return
+	}
+}
+
+// CircuitOpen returns true when the circuit of the backend is open, retrying
+// on it is pointless until the cooldown elapses
+func (s *ServerPool) CircuitOpen(backendUrl *url.URL) bool {
+	if b := s.Backend(backendUrl); b != nil {
+		return b.CircuitState() == CircuitOpen
+	}
+	return false
+}
+
+// GetNextPeer returns next active peer to take a connection using a smooth
+// weighted round robin over the effective weights, with every backend
+// healthy it's a plain round robin. StrategyEWMA and StrategyLeastBytes pick
+// the best score instead
+func (s *ServerPool) GetNextPeer() *Backend {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.Strategy == StrategyEWMA || s.Strategy == StrategyLeastBytes {
+		var best *Backend
+		if s.Strategy == StrategyEWMA {
+			best = s.fastestPeer()
+		} else {
+			best = s.leastBytesPeer()
+		}
+		if best != nil {
+			best.beginProbe()
+		}
+		return best
+	}
+
+	var best *Backend
+	total := 0
+	for _, b := range s.backends {
+		weight := b.EffectiveWeight()
+		if weight <= 0 {
+			continue
+		}
+		b.current += weight
+		total += weight
+		if best == nil || b.current > best.current {
+			best = b
 		}
 	}
+	if best != nil {
+		best.current -= total
+		best.beginProbe()
+	}
+	return best
+}
+
+// PeerByID returns the backend with the id taking traffic (alive and not
+// blocked by its circuit), nil when there is none so the caller balances
+func (s *ServerPool) PeerByID(id string) *Backend {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, b := range s.backends {
+		if b.ID() != id {
+			continue
+		}
+		if b.EffectiveWeight() <= 0 {
+			return nil
+		}
+		b.beginProbe()
+		return b
+	}
 	return nil
 }
 
-// HealthCheck pings the backends and update the status
-func (s *ServerPool) HealthCheck() {
+// fastestPeer returns the alive backend with the lowest score, backends without
+// requests yet score 0 so every backend gets measured
+func (s *ServerPool) fastestPeer() *Backend {
+	var best *Backend
+	bestScore := 0.0
 	for _, b := range s.backends {
-		status := "up"
-		alive := isBackendAlive(b.URL)
-		b.SetAlive(alive)
-		if !alive {
-			status = "down"
+		weight := b.EffectiveWeight()
+		if weight <= 0 {
+			continue
+		}
+		if score := b.score(weight); best == nil || score < bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// leastBytesPeer returns the alive backend with the least outstanding response
+// bytes by weight, the ties (e.g. every backend idle) are taken in turns
+func (s *ServerPool) leastBytesPeer() *Backend {
+	var best *Backend
+	bestScore := 0.0
+	bestAt := 0
+	for i := range s.backends {
+		at := (s.next + i) % len(s.backends)
+		b := s.backends[at]
+		weight := b.EffectiveWeight()
+		if weight <= 0 {
+			continue
+		}
+		if score := b.bytesScore(weight); best == nil || score < bestScore {
+			best, bestScore, bestAt = b, score, at
+		}
+	}
+	if best != nil {
+		s.next = bestAt + 1
+	}
+	return best
+}
+
+// HealthCheck starts a probe of every backend and returns without waiting
+// for them, so a slow backend doesn't delay the others. The backends whose
+// previous probe is still waiting or running are skipped, their probes never
+// stack up, and at most HealthCheckWorkers run at once while the rest wait for
+// a slot. It returns the probes started and the backends skipped
+func (s *ServerPool) HealthCheck() (started, skipped int) {
+	s.healthOnce.Do(func() {
+		s.healthSlots = make(chan struct{}, atLeastOne(s.HealthCheckWorkers))
+	})
+	for _, b := range s.Backends() {
+		if !b.beginCheck() {
+			skipped++
+			otelify.MetricHealthChecksSkipped.WithLabelValues(b.URL.String()).Inc()
+			logger.LogWarn("lb: previous health check still running", zap.String("backend", b.URL.String()))
+			continue
+		}
+		started++
+		go func(b *Backend) {
+			defer b.endCheck()
+			s.healthSlots <- struct{}{}
+			defer func() { <-s.healthSlots }()
+			s.checkBackend(b)
+		}(b)
+	}
+	return started, skipped
+}
+
+// checkBackend probe a backend and update its health score
+func (s *ServerPool) checkBackend(b *Backend) {
+	ok := s.probe(b)
+	wasAlive := b.IsAlive()
+	if s.FailThreshold > 0 || s.RiseThreshold > 0 {
+		s.recordThresholds(b, ok)
+	} else {
+		b.RecordHealth(ok)
+	}
+	status := "up"
+	switch score := b.HealthScore(); {
+	case score == 0:
+		status = "down"
+	case score < MaxHealthScore:
+		status = fmt.Sprintf("degraded %d/%d", score, MaxHealthScore)
+	}
+	logger.LogInfo(fmt.Sprintf("lb: %s [%s]\n", b.URL, status))
+	switch alive := b.IsAlive(); {
+	case wasAlive && !alive:
+		logger.LogWarn("lb: backend is down", zap.String("backend", b.URL.String()), zap.String("transition", "alive->dead"))
+	case !wasAlive && alive:
+		logger.LogInfo("lb: backend is up", zap.String("backend", b.URL.String()), zap.String("transition", "dead->alive"))
+	}
+}
+
+// recordThresholds mark the backend down after FailThreshold failures in a row and
+// up after RiseThreshold successes in a row, in between the health score only
+// changes its weight
+func (s *ServerPool) recordThresholds(b *Backend, ok bool) {
+	fails, successes := b.recordStreak(ok)
+	alive := b.IsAlive()
+	switch {
+	case alive && !ok && fails >= atLeastOne(s.FailThreshold):
+		s.MarkBackendStatus(b.URL, false)
+	case !alive && ok && successes >= atLeastOne(s.RiseThreshold):
+		s.MarkBackendStatus(b.URL, true)
+	case alive:
+		b.degrade(ok)
+	}
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// probe checks whether a backend is alive with a GET of HealthCheckPath through
+// the transport of the backend, redirects aren`t followed. With HealthCheckTCP
+// a connection is enough
+func (s *ServerPool) probe(b *Backend) bool {
+	u := b.URL
+	timeout := s.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	if s.HealthCheckTCP {
+		return isBackendAlive(u, timeout)
+	}
+	path := s.HealthCheckPath
+	if path == "" {
+		path = "/"
+	}
+	probeURL := *u
+	probeURL.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	probeURL.RawPath = ""
+	client := &http.Client{
+		Transport: b.Transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		logger.LogError(errors.Errorf("lb: %w: %v", errors.ErrHealthProbe, err).Error())
+		return false
+	}
+	if b.Host != "" {
+		req.Host = b.Host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.LogError(errors.Errorf("lb: %w: %v", errors.ErrHealthProbe, err).Error())
+		return false
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	if !s.healthyStatus(resp.StatusCode) {
+		logger.LogError(errors.Errorf("lb: %w: %s answered %d", errors.ErrHealthProbe, probeURL.String(), resp.StatusCode).Error())
+		return false
+	}
+	return true
+}
+
+// healthyStatus returns true when the status of a probe is an accepted one
+func (s *ServerPool) healthyStatus(status int) bool {
+	if len(s.HealthCheckStatus) == 0 {
+		return status >= 200 && status < 400
+	}
+	for _, accepted := range s.HealthCheckStatus {
+		if status == accepted {
+			return true
 		}
-		logger.LogInfo(fmt.Sprintf("lb: %s [%s]\n", b.URL, status))
 	}
+	return false
 }
 
 // isBackendAlive checks whether a backend is Alive by establishing a TCP connection
-func isBackendAlive(u *url.URL) bool {
-	timeout := 2 * time.Second
+func isBackendAlive(u *url.URL, timeout time.Duration) bool {
 	conn, err := net.DialTimeout("tcp", u.Host, timeout)
 	if err != nil {
 		logger.LogError(errors.Errorf("lb: %v", errors.ErrIsBackendAlive).Error())
@@ -1,17 +1,265 @@
 package proxy
 
+import "time"
+
 // ProxyEndpoint struct for all enpoints
 type ProxyEndpoint struct {
-	Name      string     `mapstructure:"name"`
-	HostURI   string     `mapstructure:"host_uri"`
+	Name          string `mapstructure:"name"`
+	HostURI       string `mapstructure:"host_uri"`
+	OutboundProxy string `mapstructure:"outbound_proxy"`
+	// BufferResponses buffer upstream bodies to answer 502 when the backend
+	// closes the connection mid-response instead of a truncated response
+	BufferResponses bool `mapstructure:"buffer_responses"`
+	// MaxResponseHeaderBytes limit of the upstream response headers, over it the client gets 502
+	MaxResponseHeaderBytes int64 `mapstructure:"max_response_header_bytes"`
+	// DialTimeout, TLSHandshakeTimeout and ResponseTimeout (time to the response headers)
+	// of the upstream transport, 0 uses the defaults
+	DialTimeout         time.Duration `mapstructure:"dial_timeout"`
+	TLSHandshakeTimeout time.Duration `mapstructure:"tls_handshake_timeout"`
+	ResponseTimeout     time.Duration `mapstructure:"response_timeout"`
+	// Timeout of the requests of every route of the service, overrides the gateway
+	// request_timeout and the routes can override it, 0 keeps the gateway one
+	Timeout time.Duration `mapstructure:"timeout"`
+	// TLSServerName SNI sent to the backend and hostname of its certificate,
+	// for backends dialed by ip behind a shared tls frontend
+	TLSServerName string `mapstructure:"tls_server_name"`
+	// UpstreamTLS trust and client certificate of the https backends
+	UpstreamTLS UpstreamTLS `mapstructure:"upstream_tls"`
+	// Compression passthrough (the default)|compress (gzip plain bodies)|decompress
+	Compression string `mapstructure:"compression"`
+	// UpstreamProtocol auto|http1|http2|h2c (http2 with prior knowledge over cleartext)
+	UpstreamProtocol string `mapstructure:"upstream_protocol"`
+	// HTTP2 multiplexing settings of the http2/h2c connections to the backend
+	HTTP2 HTTP2 `mapstructure:"http2"`
+	// Audiences `aud` claims of the jwt accepted by the protected routes, a valid
+	// token for another audience gets 403, empty accepts any
+	Audiences []string `mapstructure:"audiences"`
+	// AllowCIDRs clients (ips or CIDRs) allowed on the routes of the service, empty
+	// allows any. DenyCIDRs are rejected even when allowed, both get 403
+	AllowCIDRs []string `mapstructure:"allow_cidrs"`
+	DenyCIDRs  []string `mapstructure:"deny_cidrs"`
+	// RateLimit requests per second by client ip on each route of the service
+	RateLimit RateLimit `mapstructure:"rate_limit"`
+	// DefaultQuery query params added to every forwarded request,
+	// client supplied values win unless OverrideQuery
+	DefaultQuery  map[string]string `mapstructure:"default_query"`
+	OverrideQuery bool              `mapstructure:"override_query"`
+	// CookieRoute sends the requests carrying the cookie to another backend
+	CookieRoute CookieRoute `mapstructure:"cookie_route"`
+	// SizeRoute sends the large requests to another backend
+	SizeRoute SizeRoute `mapstructure:"size_route"`
+	// Deprecation headers added to the responses while clients migrate off the service
+	Deprecation Deprecation `mapstructure:"deprecation"`
+	// Signing outbound request signing for backends that require it
+	Signing   Signing    `mapstructure:"signing"`
 	Endpoints []Endpoint `mapstructure:"endpoints"`
 }
 
+// CookieRoute struct for cookie based routing, the value of the cookie `Name`
+// (case insensitive) selects the host_uri in Backends, other values use the default host_uri
+type CookieRoute struct {
+	Name     string            `mapstructure:"name"`
+	Backends map[string]string `mapstructure:"backends"`
+}
+
+// SizeRoute struct for size based routing, requests declaring (Content-Length)
+// a body bigger than Threshold bytes use HostURI, the others the default host_uri.
+// Chunked requests don't declare it, Chunked small (the default)|large picks their backend
+type SizeRoute struct {
+	Threshold int64  `mapstructure:"threshold"`
+	HostURI   string `mapstructure:"host_uri"`
+	Chunked   string `mapstructure:"chunked"`
+}
+
+// Deprecation struct for the `Deprecation` and `Sunset` (RFC 8594) headers of a service,
+// Date (deprecated since) and Sunset (removal) are RFC3339 times and Link the
+// migration docs. Without Date the `Deprecation` header is `true`
+type Deprecation struct {
+	Enable bool   `mapstructure:"enable"`
+	Date   string `mapstructure:"date"`
+	Sunset string `mapstructure:"sunset"`
+	Link   string `mapstructure:"link"`
+}
+
+// Signing struct for the credential scheme used to sign the forwarded requests,
+// empty keys are read from `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and `AWS_SESSION_TOKEN`
+type Signing struct {
+	Scheme       string `mapstructure:"scheme"`
+	Region       string `mapstructure:"region"`
+	Service      string `mapstructure:"service"`
+	AccessKey    string `mapstructure:"access_key"`
+	SecretKey    string `mapstructure:"secret_key"`
+	SessionToken string `mapstructure:"session_token"`
+}
+
 // Enpoint struct for enpoint object
 type Endpoint struct {
 	PathEndpoint  string `mapstructure:"path_endpoints"`
 	PathToProxy   string `mapstructure:"path_proxy"`
 	PathProtected bool   `mapstructure:"path_protected"`
+	// RewriteTo replaces the PathToProxy prefix of the client path e.g. /api/v1/users
+	// to /users, the upstream is host_uri alone. Empty strips the prefix and
+	// forwards to host_uri + path_endpoints
+	RewriteTo string `mapstructure:"rewrite_to"`
+	// Query params that select the route among the ones with the same PathToProxy,
+	// `*` only requires the param, names are case-insensitive
+	Query map[string]string `mapstructure:"query"`
+	// Timeout of the requests of the route, overrides the service timeout and the gateway request_timeout
+	Timeout time.Duration `mapstructure:"timeout"`
+	// BodyReadTimeout time the client has to send the whole request body, slower
+	// uploads get 408, 0 disables it
+	BodyReadTimeout time.Duration `mapstructure:"body_read_timeout"`
+	// WebSocket the upgrade handshakes of the route skip the timeout, the
+	// body_read_timeout and the stream_idle_timeout so the tunnel outlives them,
+	// on the other routes they apply
+	WebSocket bool `mapstructure:"websocket"`
+	// LatencyClass class of proxy.latency_classes whose buckets measure the latency of
+	// the route, empty uses ngonx_request_latency_seconds
+	LatencyClass string `mapstructure:"latency_class"`
+	// RetryAfter retries once the idempotent requests the upstream answered 429 or 503 with `Retry-After`
+	RetryAfter RetryAfter `mapstructure:"retry_after"`
+	// StreamIdleTimeout reaps streaming responses (SSE) without a chunk during it, 0 disables it
+	StreamIdleTimeout time.Duration `mapstructure:"stream_idle_timeout"`
+	// MaxConcurrent max in-flight requests for the route, 0 unlimited
+	MaxConcurrent int `mapstructure:"max_concurrent"`
+	// LeakyBucket paces the requests of the route to a steady rate
+	LeakyBucket LeakyBucket `mapstructure:"leaky_bucket"`
+	// PathParams named segments of the client path forwarded as headers
+	PathParams PathParams `mapstructure:"path_params"`
+	// StripHeaders request headers removed before forwarding to the backend,
+	// a trailing `*` matches a prefix e.g. `X-Internal-*`
+	StripHeaders []string `mapstructure:"strip_headers"`
+	// UpstreamScheme http|https forced toward the backend whatever the scheme of host_uri, empty keeps it
+	UpstreamScheme string `mapstructure:"upstream_scheme"`
+	// MethodOverride methods allowed in `X-HTTP-Method-Override` of POST requests, empty disables it
+	MethodOverride []string `mapstructure:"method_override"`
+	// StatusRemap upstream status codes rewritten before answering e.g. 418: 503
+	StatusRemap map[int]int `mapstructure:"status_remap"`
+	// NormalizeHeaders consistent upstream response headers regardless of the backend
+	NormalizeHeaders HeaderNormalization `mapstructure:"normalize_headers"`
+	// ExpectedContentType media type of the upstream responses e.g. application/json,
+	// mismatches are logged and counted, and answered with 502 when RejectUnexpectedContentType
+	ExpectedContentType         string `mapstructure:"expected_content_type"`
+	RejectUnexpectedContentType bool   `mapstructure:"reject_unexpected_content_type"`
+	// RewriteURLs absolute urls of the backend in the json responses rewritten to the gateway
+	RewriteURLs URLRewrite `mapstructure:"rewrite_urls"`
+	// Buffering buffering vs streaming decision by the response content-type
+	Buffering BufferingPolicy `mapstructure:"buffering"`
+	// Cache in memory cache of the GET responses
+	Cache Cache `mapstructure:"cache"`
+	// Fallback response when the upstream is unreachable
+	Fallback Fallback `mapstructure:"fallback"`
+}
+
+// RetryAfter struct for the retry of the upstream 429 and 503 responses, requests
+// without body of the idempotent methods are retried once when the `Retry-After`
+// is at most MaxWait and fits in the request deadline. A max_wait <= 0 disables it
+type RetryAfter struct {
+	MaxWait time.Duration `mapstructure:"max_wait"`
+}
+
+// UpstreamTLS struct for the tls connections to an https backend, CAFile pem bundle
+// trusted instead of the system roots (internal PKI, self-signed staging certs),
+// CertFile and KeyFile client certificate for mTLS with the backend.
+// InsecureSkipVerify accepts any certificate, never use it in production
+type UpstreamTLS struct {
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// HTTP2 struct for the http2 connections to a backend, MaxConcurrentStreams caps
+// the in-flight requests of the service on each of its MaxConns connections
+// (one when MaxConns is 0), StrictMaxConcurrentStreams honors the limit
+// advertised by the backend instead of opening more connections and
+// MaxReadFrameSize is the frame size advertised to the backend
+type HTTP2 struct {
+	MaxConcurrentStreams       int           `mapstructure:"max_concurrent_streams"`
+	StrictMaxConcurrentStreams bool          `mapstructure:"strict_max_concurrent_streams"`
+	MaxConns                   int           `mapstructure:"max_conns"`
+	MaxReadFrameSize           uint32        `mapstructure:"max_read_frame_size"`
+	ReadIdleTimeout            time.Duration `mapstructure:"read_idle_timeout"`
+	PingTimeout                time.Duration `mapstructure:"ping_timeout"`
+}
+
+// RateLimit struct for the token bucket of each client ip, RPS tokens are added per
+// second up to Burst (RPS rounded up when it`s 0) and clients over it get 429.
+// Buckets idle for IdleTTL (10m when it`s 0) are dropped, a rps <= 0 disables it
+type RateLimit struct {
+	RPS     float64       `mapstructure:"rps"`
+	Burst   int           `mapstructure:"burst"`
+	IdleTTL time.Duration `mapstructure:"idle_ttl"`
+}
+
+// LeakyBucket struct for the request smoothing of a route, requests are released
+// at Rate per second and up to Queue wait for their turn, the rest get 429.
+// A rate <= 0 disables it
+type LeakyBucket struct {
+	Rate  float64 `mapstructure:"rate"`
+	Queue int     `mapstructure:"queue"`
+}
+
+// PathParams struct for the path parameter extraction, Pattern is matched against
+// the client path e.g. `/users/:id/*` and Headers maps each param (lowercase) to a header
+type PathParams struct {
+	Pattern string            `mapstructure:"pattern"`
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// HeaderNormalization struct for the upstream response headers, Canonicalize
+// fixes the casing of the names and Merge headers (e.g. Vary, Cache-Control)
+// get their values deduplicated into a single one
+type HeaderNormalization struct {
+	Canonicalize bool     `mapstructure:"canonicalize"`
+	Merge        []string `mapstructure:"merge"`
+}
+
+// URLRewrite struct for the absolute urls of the backend (host_uri and cookie route
+// backends) in json bodies, Fields names (at any depth) whose values are rewritten,
+// empty rewrites every string value. ExternalURL base url of the gateway e.g.
+// https://api.example.com, empty uses the scheme and host of the client request
+type URLRewrite struct {
+	Enable      bool     `mapstructure:"enable"`
+	Fields      []string `mapstructure:"fields"`
+	ExternalURL string   `mapstructure:"external_url"`
+}
+
+// BufferingPolicy struct for the buffering vs streaming decision by the response
+// media type, Stream types are flushed on every chunk and Buffer types are read
+// in full before answering. Empty lists use text/event-stream and
+// application/octet-stream to stream and application/json to buffer
+type BufferingPolicy struct {
+	Enable bool     `mapstructure:"enable"`
+	Stream []string `mapstructure:"stream"`
+	Buffer []string `mapstructure:"buffer"`
+}
+
+// Cache struct for the response cache of a route, a ttl <= 0 disables it.
+// Warm paths (as requested by the clients e.g. `/version/`) are fetched on startup
+// once the upstream is healthy. KeyBy request attributes (`header:X-Tenant-ID`,
+// `cookie:tenant` or `claim:tenant_id` of the verified jwt) added to the key.
+// StaleOnError keeps the expired entries during it to answer when the upstream
+// fails with 5xx or is unreachable. Authenticated requests bypass the cache
+// unless Authenticated, which requires a claim KeyBy to keep the entries per tenant
+type Cache struct {
+	TTL           time.Duration `mapstructure:"ttl"`
+	Warm          []string      `mapstructure:"warm"`
+	KeyBy         []string      `mapstructure:"key_by"`
+	StaleOnError  time.Duration `mapstructure:"stale_on_error"`
+	Authenticated bool          `mapstructure:"authenticated"`
+}
+
+// Fallback struct for the response served when the upstream is unreachable,
+// the last known good response takes precedence over the static body, both
+// only answer GET and HEAD. The authenticated requests get it only with a
+// claim KeyBy, the attributes of Cache.KeyBy
+type Fallback struct {
+	Status        int      `mapstructure:"status"`
+	ContentType   string   `mapstructure:"content_type"`
+	Body          string   `mapstructure:"body"`
+	LastKnownGood bool     `mapstructure:"last_known_good"`
+	KeyBy         []string `mapstructure:"key_by"`
 }
 
 // ProxyRepository interface
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"sync"
 
 	badger "github.com/dgraph-io/badger/v3"
 	"github.com/go-redis/redis/v8"
@@ -15,12 +16,23 @@ import (
 type ProxyRepositoryStorage struct {
 	clientBadger *badger.DB
 	clientRdb    *redis.Client
+	memory       *memoryKeys
+}
+
+// memoryKeys keys of the `memory` engine, for tests and local dev,
+// they are lost when the process exits
+type memoryKeys struct {
+	mu   sync.RWMutex
+	keys map[string]string
 }
 
 // NewProxyRepository return a new ProxyRepositoryStorage
-// with a client `*badger.DB`
+// with a client `*badger.DB` and/or `*redis.Client`,
+// the `memory` engine is always available
 func NewProxyRepository(clients ...interface{}) ProxyRepositoryStorage {
-	var proxyRepositoryDB ProxyRepositoryStorage
+	proxyRepositoryDB := ProxyRepositoryStorage{
+		memory: &memoryKeys{keys: make(map[string]string)},
+	}
 	for _, c := range clients {
 		switch c := c.(type) {
 		case *badger.DB:
@@ -57,6 +69,11 @@ func (r ProxyRepositoryStorage) SaveKEY(engine, key, apikey string) error {
 			otelify.InstrumentedError(span, "redis", traceID, err)
 		}
 		otelify.InstrumentedInfo(span, "repo.SaveKey", traceID)
+	case "memory":
+		r.memory.mu.Lock()
+		r.memory.keys[key] = apikey
+		r.memory.mu.Unlock()
+		otelify.InstrumentedInfo(span, "repo.SaveKey", traceID)
 	}
 	return nil
 }
@@ -72,6 +89,9 @@ func (r ProxyRepositoryStorage) GetKEY(engine, key string) (string, error) {
 	case "badger":
 		if err := r.clientBadger.View(func(txn *badger.Txn) error {
 			item, err := txn.Get([]byte(key))
+			if err == badger.ErrKeyNotFound {
+				return errors.ErrGetkeyNotFound
+			}
 			if err != nil {
 				otelify.InstrumentedError(span, "badger", traceID, err)
 				return errors.ErrGetkeyTX
@@ -87,15 +107,31 @@ func (r ProxyRepositoryStorage) GetKEY(engine, key string) (string, error) {
 			return nil
 		}); err != nil {
 			otelify.InstrumentedError(span, "badger", traceID, err)
+			if errors.ErrorIs(err, errors.ErrGetkeyNotFound) {
+				return "", err
+			}
 			return "", errors.ErrGetkeyView
 		}
 	case "redis":
 		value, err := r.clientRdb.Get(context.TODO(), key).Result()
-		if err == redis.Nil || err != nil {
+		if err == redis.Nil {
+			otelify.InstrumentedError(span, "redis", traceID, err)
+			return "", errors.ErrGetkeyNotFound
+		}
+		if err != nil {
 			otelify.InstrumentedError(span, "redis", traceID, err)
 			return "", err
 		}
 		apikey = value
+	case "memory":
+		r.memory.mu.RLock()
+		value, ok := r.memory.keys[key]
+		r.memory.mu.RUnlock()
+		if !ok {
+			otelify.InstrumentedError(span, "memory", traceID, errors.ErrGetkeyMemory)
+			return "", errors.ErrGetkeyMemory
+		}
+		apikey = value
 	}
 	otelify.InstrumentedInfo(span, "repo.GetKey", traceID)
 
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// maxInMemoryBody bodies inspected by a middleware bigger than it are
+// spooled to a temp file, so large uploads don`t live in memory
+const maxInMemoryBody = 1 << 20
+
+// spoolBody read the request body once through w (e.g. an incremental hash)
+// and replace it with a reader of the same bytes for the upstream. At most
+// `maxInMemoryBody` bytes are kept in memory, the rest goes to a temp file
+// removed when the body is closed. Bodies bigger than limit are refused with
+// ErrRequestBodyTooLarge, the reading stops right after the limit
+func spoolBody(req *http.Request, w io.Writer, limit int64) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if req.ContentLength > limit {
+		return errors.ErrRequestBodyTooLarge
+	}
+	src := io.TeeReader(io.LimitReader(req.Body, limit+1), w)
+	defer req.Body.Close()
+
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, src, maxInMemoryBody+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n > limit {
+		return errors.ErrRequestBodyTooLarge
+	}
+	if n <= maxInMemoryBody {
+		req.Body = io.NopCloser(buf)
+		return nil
+	}
+
+	f, err := os.CreateTemp("", "ngonx-body-*")
+	if err != nil {
+		return err
+	}
+	spooled := &spooledBody{File: f}
+	if _, err := buf.WriteTo(f); err != nil {
+		_ = spooled.Close()
+		return err
+	}
+	rest, err := io.Copy(f, src)
+	if err != nil {
+		_ = spooled.Close()
+		return err
+	}
+	if n+rest > limit {
+		_ = spooled.Close()
+		return errors.ErrRequestBodyTooLarge
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = spooled.Close()
+		return err
+	}
+	req.Body = spooled
+	return nil
+}
+
+// spooledBody request body backed by a temp file
+type spooledBody struct {
+	*os.File
+}
+
+// Close closes and removes the temp file
+func (b *spooledBody) Close() error {
+	err := b.File.Close()
+	_ = os.Remove(b.Name())
+	return err
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// MaxBufferedResponse max bytes of an upstream body buffered to detect truncation,
+// bigger bodies are streamed after the buffered part
+const MaxBufferedResponse = 10 << 20
+
+// IsIdempotent returns true for the methods that are safe to retry
+func IsIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// BufferResponse read the upstream body before the headers are sent to the client,
+// a backend that closes the connection mid response returns `ErrUpstreamTruncated`
+// so the ErrorHandler can retry or answer 502 instead of a truncated response
+func BufferResponse(resp *http.Response) error {
+	buf := &bytes.Buffer{}
+	_, err := io.CopyN(buf, resp.Body, MaxBufferedResponse)
+	if err == io.EOF {
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(buf)
+		return nil
+	}
+	if err != nil {
+		_ = resp.Body.Close()
+		return errors.Errorf("%w: %v", errors.ErrUpstreamTruncated, err)
+	}
+	// the body is bigger than the limit, stream the rest
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(buf, resp.Body), resp.Body}
+	return nil
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bufio"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+)
+
+// default media types of the buffering policy
+var (
+	defaultStreamTypes = []string{"text/event-stream", "application/octet-stream"}
+	defaultBufferTypes = []string{"application/json"}
+)
+
+// bufferingPolicy decides by the response content-type whether the upstream
+// body is streamed to the client chunk by chunk or buffered in full, other
+// media types keep the default behavior of the route
+type bufferingPolicy struct {
+	stream map[string]bool
+	buffer map[string]bool
+}
+
+// newBufferingPolicy return nil when the route has no buffering policy enabled
+func newBufferingPolicy(opts domain.BufferingPolicy) *bufferingPolicy {
+	if !opts.Enable {
+		return nil
+	}
+	stream, buffer := opts.Stream, opts.Buffer
+	if len(stream) == 0 {
+		stream = defaultStreamTypes
+	}
+	if len(buffer) == 0 {
+		buffer = defaultBufferTypes
+	}
+	return &bufferingPolicy{stream: mediaTypeSet(stream), buffer: mediaTypeSet(buffer)}
+}
+
+func mediaTypeSet(types []string) map[string]bool {
+	set := make(map[string]bool, len(types))
+	for _, t := range types {
+		set[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	return set
+}
+
+// mediaType of the content-type header in lowercase, empty when it`s invalid
+func mediaType(h http.Header) string {
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(mt)
+}
+
+// modify ModifyResponse that buffers the bodies of the buffered media types,
+// so the modifiers after it get the full body
+func (p *bufferingPolicy) modify(resp *http.Response) error {
+	if !p.buffer[mediaType(resp.Header)] {
+		return nil
+	}
+	return BufferResponse(resp)
+}
+
+// handler flushes every chunk of the streamed media types as soon as it`s written
+func (p *bufferingPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(&flushWriter{ResponseWriter: w, policy: p}, req)
+	})
+}
+
+// flushWriter decides on WriteHeader if the response is streamed
+type flushWriter struct {
+	http.ResponseWriter
+	policy      *bufferingPolicy
+	wroteHeader bool
+	stream      bool
+}
+
+func (w *flushWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.stream = w.policy.stream[mediaType(w.Header())]
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *flushWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	if w.stream && n > 0 {
+		w.Flush()
+	}
+	return n, err
+}
+
+func (w *flushWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *flushWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *flushWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+)
+
+// bulkhead limits the in-flight requests of a route, requests over the
+// limit get 503. The in-flight count and the limit are exported together
+// as gauges labeled by endpoint so dashboards can show the saturation
+func bulkhead(endpoint string, limit int, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+	sem := make(chan struct{}, limit)
+	inflight := otelify.MetricRouteInflight.WithLabelValues(endpoint)
+	otelify.MetricRouteConcurrencyLimit.WithLabelValues(endpoint).Set(float64(limit))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			inflight.Inc()
+			defer func() {
+				inflight.Dec()
+				<-sem
+			}()
+			next.ServeHTTP(w, req)
+		default:
+			writeResponseMiddleware(w, http.StatusServiceUnavailable, errors.ErrConcurrencyLimit.Error())
+		}
+	})
+}
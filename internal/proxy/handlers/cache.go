@@ -0,0 +1,351 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/backoff"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/healthcheck"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"go.uber.org/zap"
+)
+
+// maxCacheEntries max responses cached by route
+const maxCacheEntries = 1024
+
+// warmAttempts health checks of the upstream before giving up the warming
+const warmAttempts = 10
+
+// cacheKeyCtx context key that carries the cache key of a miss to `store`
+type cacheKeyCtx struct{}
+
+// cacheEntry cached response and its expiration
+type cacheEntry struct {
+	*cachedResponse
+	expires time.Time
+}
+
+// responseCache in memory cache of the GET responses of a route
+type responseCache struct {
+	opts domain.Cache
+	// varyCookie cookie whose value selects the backend, part of the key
+	varyCookie string
+	mu         sync.RWMutex
+	entries    map[string]*cacheEntry
+}
+
+// newResponseCache return nil when the route has no cache configured
+func newResponseCache(opts domain.Cache) *responseCache {
+	if opts.TTL <= 0 {
+		return nil
+	}
+	return &responseCache{
+		opts:    opts,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// validCache check the cache of a route, caching authenticated responses
+// needs a `claim:` key_by that tells the users (tenants) apart, the headers
+// and cookies are set by the client and one tenant could read another's entries
+func validCache(opts domain.Cache) error {
+	if opts.TTL > 0 && opts.Authenticated && !hasClaimKey(opts.KeyBy) {
+		return errors.Errorf("%w: authenticated requires a claim: key_by", errors.ErrCacheConfig)
+	}
+	return nil
+}
+
+// hasClaimKey reports whether a `claim:` attribute is part of the key
+func hasClaimKey(keyBy []string) bool {
+	for _, attr := range keyBy {
+		if kind, _ := splitKeyBy(attr); kind == "claim" {
+			return true
+		}
+	}
+	return false
+}
+
+// handler answer GET requests from the cache, misses continue to next
+// and are stored by `store` once the upstream answers. Websocket handshakes
+// always reach the upstream. Authenticated requests bypass the cache unless the
+// route opts in: the responses of protected routes are often personalized and
+// a shared entry would leak one user's data to another
+func (c *responseCache) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || isUpgrade(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if !c.opts.Authenticated && isAuthenticated(req) {
+			w.Header().Set("X-Ngonx-Cache", "BYPASS")
+			next.ServeHTTP(w, req)
+			return
+		}
+		key, ok := c.key(req)
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+		c.mu.RLock()
+		entry, ok := c.entries[key]
+		c.mu.RUnlock()
+		if ok && time.Now().Before(entry.expires) {
+			entry.write(w, "X-Ngonx-Cache", "HIT")
+			return
+		}
+		ctx := context.WithValue(req.Context(), cacheKeyCtx{}, key)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// key of the request in the cache, see requestKey
+func (c *responseCache) key(req *http.Request) (string, bool) {
+	return requestKey(req, c.varyCookie, c.opts.KeyBy)
+}
+
+// requestKey key of the request uri, the `keyBy` attributes (`header:<name>`,
+// `cookie:<name>` or `claim:<name>`) isolate the entries of each tenant.
+// Headers like `Authorization` are only part of the key when they are
+// configured. Returns false when a claim is configured but the request has no
+// verified jwt, those requests bypass the cache
+func requestKey(req *http.Request, varyCookie string, keyBy []string) (string, bool) {
+	var b strings.Builder
+	b.WriteString(req.URL.RequestURI())
+	if varyCookie != "" {
+		if cookie, err := req.Cookie(varyCookie); err == nil {
+			b.WriteString("|" + varyCookie + "=" + strings.ToLower(cookie.Value))
+		}
+	}
+	for _, attr := range keyBy {
+		kind, name := splitKeyBy(attr)
+		var value string
+		switch kind {
+		case "header":
+			value = strings.Join(req.Header.Values(name), ",")
+		case "cookie":
+			if cookie, err := req.Cookie(name); err == nil {
+				value = cookie.Value
+			}
+		case "claim":
+			claims, ok := req.Context().Value(jwtClaimsCtx{}).(map[string]interface{})
+			if !ok {
+				return "", false
+			}
+			if v, ok := claims[name]; ok {
+				value = fmt.Sprint(v)
+			}
+		}
+		b.WriteString("|" + attr + "=" + value)
+	}
+	return b.String(), true
+}
+
+// keyedVary reports whether every header of the `Vary` of the response is part
+// of the key: the key_by headers, `Cookie` with a cookie in the key and
+// `Accept-Encoding` when the body isn't encoded, it suits any client
+func (c *responseCache) keyedVary(resp *http.Response) bool {
+	keyed := map[string]bool{"accept-encoding": resp.Header.Get("Content-Encoding") == ""}
+	if c.varyCookie != "" {
+		keyed["cookie"] = true
+	}
+	for _, attr := range c.opts.KeyBy {
+		switch kind, name := splitKeyBy(attr); kind {
+		case "header":
+			keyed[strings.ToLower(name)] = true
+		case "cookie":
+			keyed["cookie"] = true
+		}
+	}
+	for _, vary := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !keyed[name] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// splitKeyBy split `kind:name`, a bare name is a header
+func splitKeyBy(attr string) (string, string) {
+	kv := strings.SplitN(attr, ":", 2)
+	if len(kv) != 2 {
+		return "header", attr
+	}
+	return strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+}
+
+// store ModifyResponse that caches the 200 responses of the misses,
+// responses marked `no-store`/`private`, setting cookies or varying on a
+// header outside the key are never cached.
+// A 5xx is replaced by the stale entry when it`s within `stale_on_error`
+func (c *responseCache) store(resp *http.Response) error {
+	if resp.Request == nil {
+		return nil
+	}
+	key, ok := resp.Request.Context().Value(cacheKeyCtx{}).(string)
+	if !ok {
+		return nil
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		if entry := c.stale(key); entry != nil {
+			c.logStale(resp.Request, resp.Status)
+			_ = resp.Body.Close()
+			resp.StatusCode = entry.status
+			resp.Status = fmt.Sprintf("%d %s", entry.status, http.StatusText(entry.status))
+			resp.Header = entry.header.Clone()
+			resp.Header.Set("X-Ngonx-Cache", "STALE")
+			resp.Body = io.NopCloser(bytes.NewReader(entry.body))
+			resp.ContentLength = int64(len(entry.body))
+			resp.Header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+		}
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	resp.Header.Set("X-Ngonx-Cache", "MISS")
+	cacheControl := strings.ToLower(resp.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") ||
+		resp.Header.Get("Set-Cookie") != "" || !c.keyedVary(resp) {
+		return nil
+	}
+	cached, err := readCachedResponse(resp)
+	if err != nil || cached == nil {
+		return err
+	}
+	cached.header.Del("X-Ngonx-Cache")
+
+	c.mu.Lock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCacheEntries {
+		c.evict()
+	}
+	c.entries[key] = &cacheEntry{cachedResponse: cached, expires: time.Now().Add(c.opts.TTL)}
+	c.mu.Unlock()
+	return nil
+}
+
+// stale returns the expired entry of the key while it`s within `stale_on_error`
+func (c *responseCache) stale(key string) *cacheEntry {
+	if c.opts.StaleOnError <= 0 {
+		return nil
+	}
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok || time.Now().After(entry.expires.Add(c.opts.StaleOnError)) {
+		return nil
+	}
+	return entry
+}
+
+// serveStale write the stale entry of an unreachable upstream,
+// returns false when there is nothing to serve
+func (c *responseCache) serveStale(w http.ResponseWriter, req *http.Request, cause error) bool {
+	if c == nil {
+		return false
+	}
+	key, ok := req.Context().Value(cacheKeyCtx{}).(string)
+	if !ok {
+		return false
+	}
+	entry := c.stale(key)
+	if entry == nil {
+		return false
+	}
+	c.logStale(req, cause.Error())
+	entry.write(w, "X-Ngonx-Cache", "STALE")
+	return true
+}
+
+func (c *responseCache) logStale(req *http.Request, cause string) {
+	logger.LogWarn(
+		"proxy: upstream failing, serving stale cache entry",
+		zap.String("path", req.URL.Path),
+		zap.String("cause", cause),
+	)
+}
+
+// evict remove the entries expired beyond `stale_on_error` or a random one
+// when none expired, it must be called with the lock held
+func (c *responseCache) evict() {
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires.Add(c.opts.StaleOnError)) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) < maxCacheEntries {
+		return
+	}
+	for k := range c.entries {
+		delete(c.entries, k)
+		return
+	}
+}
+
+// warm wait for the upstream to be healthy and request the configured paths
+// through the route handler so the first clients get cache hits
+func (c *responseCache) warm(endpoint string, upstream *url.URL, handler http.Handler) {
+	if len(c.opts.Warm) == 0 {
+		return
+	}
+	healthy := false
+	for attempt := 0; attempt < warmAttempts; attempt++ {
+		if healthcheck.IsBackendAlive(upstream) {
+			healthy = true
+			break
+		}
+		time.Sleep(backoff.Default.Duration(attempt))
+	}
+
+	for _, path := range c.opts.Warm {
+		result := "failure"
+		status := 0
+		if healthy {
+			req, err := http.NewRequest(http.MethodGet, path, nil)
+			if err == nil {
+				req.RequestURI = req.URL.RequestURI()
+				w := &warmResponseWriter{header: http.Header{}, status: http.StatusOK}
+				handler.ServeHTTP(w, req)
+				status = w.status
+				key, _ := c.key(req)
+				c.mu.RLock()
+				_, cached := c.entries[key]
+				c.mu.RUnlock()
+				if cached {
+					result = "success"
+				}
+			}
+		}
+		otelify.MetricCacheWarm.WithLabelValues(endpoint, result).Inc()
+		logger.LogInfo(
+			"proxy: cache warming",
+			zap.String("route", endpoint),
+			zap.String("path", path),
+			zap.Int("status", status),
+			zap.String("result", result),
+		)
+	}
+}
+
+// warmResponseWriter discards the body of the warming requests
+type warmResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *warmResponseWriter) Header() http.Header         { return w.header }
+func (w *warmResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *warmResponseWriter) WriteHeader(code int)        { w.status = code }
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/ipfilter"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"go.uber.org/zap"
+)
+
+// maxTrackedIPs bound of the clients tracked by ClientConcurrencyLimit,
+// new clients over it are rejected until some finish
+const maxTrackedIPs = 65536
+
+// TrustedProxies how the client ip is read from `X-Forwarded-For`, used by the
+// rate limits, the client ip lists, the metrics and the logs
+var TrustedProxies ProxyTrust
+
+// TrustOptions options of the `X-Forwarded-For` handling. Count proxies of
+// our own (load balancer, CDN) append to the header, the client is the Count-th
+// address from the right and the ones on its left, set by the client, are
+// ignored. With CIDRs the header is only honored when the direct peer belongs
+// to them. The zero value keeps the leftmost address
+type TrustOptions struct {
+	Count int
+	CIDRs []string
+}
+
+// ProxyTrust resolves the client ip of the requests
+type ProxyTrust struct {
+	mu    sync.RWMutex
+	count int
+	nets  []*net.IPNet
+}
+
+// Configure replace the options of the trusted proxies
+func (p *ProxyTrust) Configure(opts TrustOptions) error {
+	nets, err := ipfilter.ParseCIDRs(opts.CIDRs)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.count = opts.Count
+	p.nets = nets
+	p.mu.Unlock()
+	return nil
+}
+
+// clientIP the address of the client in the forwarded chain, empty when the
+// header isn't honored
+func (p *ProxyTrust) clientIP(req *http.Request, peer string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.nets) > 0 && !ipfilter.Contains(p.nets, net.ParseIP(peer)) {
+		return ""
+	}
+	var chain []string
+	for _, xff := range req.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(xff, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				chain = append(chain, ip)
+			}
+		}
+	}
+	if len(chain) == 0 {
+		return ""
+	}
+	if p.count <= 0 {
+		return chain[0]
+	}
+	// a shorter chain than the proxies, its first address is the farthest known
+	at := len(chain) - p.count
+	if at < 0 {
+		at = 0
+	}
+	return chain[at]
+}
+
+// extractIpAddr returns the ip of the client, the address of `X-Forwarded-For`
+// selected by TrustedProxies when it's honored otherwise the direct peer
+func extractIpAddr(req *http.Request) string {
+	peer, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		peer = req.RemoteAddr
+	}
+	if ip := TrustedProxies.clientIP(req, peer); ip != "" {
+		return ip
+	}
+	return peer
+}
+
+// clientLimiter in-flight requests by client ip, an entry is dropped as soon
+// as its last request ends so idle clients never stay in the map
+type clientLimiter struct {
+	limit   int
+	mu      sync.Mutex
+	clients map[string]int
+}
+
+func (l *clientLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inflight, ok := l.clients[ip]
+	if !ok && len(l.clients) >= maxTrackedIPs {
+		return false
+	}
+	if inflight >= l.limit {
+		return false
+	}
+	l.clients[ip] = inflight + 1
+	otelify.MetricTrackedClientIPs.Set(float64(len(l.clients)))
+	return true
+}
+
+func (l *clientLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients[ip] <= 1 {
+		delete(l.clients, ip)
+	} else {
+		l.clients[ip]--
+	}
+	otelify.MetricTrackedClientIPs.Set(float64(len(l.clients)))
+}
+
+// ClientConcurrencyLimit limits the simultaneous requests of a client ip,
+// requests over the limit get 429. A limit <= 0 disables it
+func ClientConcurrencyLimit(limit int, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+	l := &clientLimiter{limit: limit, clients: make(map[string]int)}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip := extractIpAddr(req)
+		if !l.acquire(ip) {
+			logger.LogWarn(
+				"proxy: client concurrency limit",
+				zap.String("client", ip),
+				zap.String("path", req.URL.Path),
+			)
+			writeResponseMiddleware(w, http.StatusTooManyRequests, errors.ErrClientConcurrencyLimit.Error())
+			return
+		}
+		defer l.release(ip)
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// Test_extractIpAddr the client ip with and without trusted proxies
+func Test_extractIpAddr(t *testing.T) {
+	defer func() { _ = TrustedProxies.Configure(TrustOptions{}) }()
+
+	tests := []struct {
+		name       string
+		opts       TrustOptions
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"no header", TrustOptions{}, "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"leftmost by default", TrustOptions{}, "10.0.0.1:4000", []string{"6.6.6.6, 1.2.3.4"}, "6.6.6.6"},
+		{"one proxy", TrustOptions{Count: 1}, "10.0.0.1:4000", []string{"6.6.6.6, 1.2.3.4"}, "1.2.3.4"},
+		{"two proxies", TrustOptions{Count: 2}, "10.0.0.1:4000", []string{"6.6.6.6, 1.2.3.4", "10.0.0.9"}, "1.2.3.4"},
+		{"shorter chain", TrustOptions{Count: 3}, "10.0.0.1:4000", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"trusted peer", TrustOptions{Count: 1, CIDRs: []string{"10.0.0.0/8"}}, "10.0.0.1:4000", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"untrusted peer", TrustOptions{Count: 1, CIDRs: []string{"10.0.0.0/8"}}, "6.6.6.6:4000", []string{"1.2.3.4"}, "6.6.6.6"},
+	}
+	for _, tt := range tests {
+		if err := TrustedProxies.Configure(tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, xff := range tt.xff {
+			req.Header.Add("X-Forwarded-For", xff)
+		}
+		if got := extractIpAddr(req); got != tt.want {
+			t.Errorf("%s: Expected client %s and result are %s", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// compression modes of a service
+const (
+	CompressionPassthrough = "passthrough"
+	CompressionCompress    = "compress"
+	CompressionDecompress  = "decompress"
+)
+
+// compression what the gateway does with the encoding of the responses,
+// passthrough (the default) forwards them as the backend sent them
+type compression struct {
+	mode string
+}
+
+// newCompression returns nil with passthrough
+func newCompression(mode string) (*compression, error) {
+	switch mode {
+	case "", CompressionPassthrough:
+		return nil, nil
+	case CompressionCompress, CompressionDecompress:
+		return &compression{mode: mode}, nil
+	}
+	return nil, errors.Errorf("%w: %q", errors.ErrCompressionMode, mode)
+}
+
+// director with decompress asks the backend for a plain body, the transport
+// requests gzip on its own and decompresses it transparently
+func (c *compression) director() func(*http.Request) {
+	if c == nil || c.mode != CompressionDecompress {
+		return nil
+	}
+	return func(req *http.Request) {
+		req.Header.Del("Accept-Encoding")
+	}
+}
+
+// decompress ModifyResponse that removes the gzip encoding the transport
+// didn`t, it must run before the modifiers that read the body
+func (c *compression) decompress(resp *http.Response) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		// an empty or broken body is forwarded as it came
+		return nil
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{zr, resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// compress ModifyResponse that gzips the plain compressible bodies for the
+// clients accepting it, the bodies already encoded are left untouched
+func (c *compression) compress(resp *http.Response) error {
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Range") != "" ||
+		resp.Request.Method == http.MethodHead || !bodyAllowed(resp.StatusCode) ||
+		!acceptsGzip(resp.Request.Header.Get("Accept-Encoding")) || !compressible(mediaType(resp.Header)) {
+		return nil
+	}
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, body)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+		_ = body.Close()
+		pw.CloseWithError(err)
+	}()
+	resp.Body = pr
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Add("Vary", "Accept-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// bodyAllowed returns false for the status codes without body
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// acceptsGzip returns true when the `Accept-Encoding` allows gzip
+func acceptsGzip(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressible media types, images, archives and streams are served as they are
+func compressible(mediaType string) bool {
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// cookieRoute serve the request with the backend mapped to the value of the
+// cookie, requests without the cookie or with unknown values use next
+func cookieRoute(name string, backends map[string]http.Handler, next http.Handler) http.Handler {
+	routes := make(map[string]http.Handler, len(backends))
+	for value, backend := range backends {
+		routes[strings.ToLower(value)] = backend
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if cookie, err := req.Cookie(name); err == nil {
+			if backend, ok := routes[strings.ToLower(cookie.Value)]; ok {
+				backend.ServeHTTP(w, req)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
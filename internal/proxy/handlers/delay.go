@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/ipfilter"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// DebugDelay latency injected to the requests of specific clients, it can be
+// toggled at runtime from the admin api
+var DebugDelay DelayInjector
+
+// DelayOptions options of the targeted latency injection, a request matches
+// when the client ip belongs to ClientCIDRs or it carries the Header
+type DelayOptions struct {
+	Enable      bool
+	Delay       time.Duration
+	ClientCIDRs []string
+	Header      string
+}
+
+// DelayInjector delays only the matching requests to debug the timeout
+// handling of some clients, the rest of the traffic is untouched
+type DelayInjector struct {
+	mu      sync.RWMutex
+	enabled bool
+	delay   time.Duration
+	nets    []*net.IPNet
+	header  string
+}
+
+// Configure replace the options of the injector
+func (d *DelayInjector) Configure(opts DelayOptions) error {
+	nets, err := ipfilter.ParseCIDRs(opts.ClientCIDRs)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.enabled = opts.Enable
+	d.delay = opts.Delay
+	d.nets = nets
+	d.header = opts.Header
+	d.mu.Unlock()
+	return nil
+}
+
+// SetEnabled toggle the injection keeping the options
+func (d *DelayInjector) SetEnabled(enabled bool) {
+	d.mu.Lock()
+	d.enabled = enabled
+	d.mu.Unlock()
+}
+
+// Options returns the current state of the injector
+func (d *DelayInjector) Options() (enabled bool, delay time.Duration) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.enabled, d.delay
+}
+
+// match returns the delay of the request, 0 when it doesn`t match
+func (d *DelayInjector) match(req *http.Request) time.Duration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if !d.enabled || d.delay <= 0 {
+		return 0
+	}
+	if d.header != "" && req.Header.Get(d.header) != "" {
+		return d.delay
+	}
+	if len(d.nets) > 0 && ipfilter.Contains(d.nets, net.ParseIP(extractIpAddr(req))) {
+		return d.delay
+	}
+	return 0
+}
+
+// Handler wait the delay before the matching requests continue,
+// a client that leaves during the delay isn`t forwarded
+func (d *DelayInjector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		delay := d.match(req)
+		if delay <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		logger.LogWarn(
+			"proxy: injecting debug delay",
+			zap.String("client", extractIpAddr(req)),
+			zap.String("path", req.URL.Path),
+			zap.Duration("delay", delay),
+		)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			next.ServeHTTP(w, req)
+		case <-req.Context().Done():
+		}
+	})
+}
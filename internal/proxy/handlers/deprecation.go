@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+)
+
+// newDeprecation returns by endpoint the ModifyResponse that adds the `Deprecation`,
+// `Sunset` (RFC 8594) and `Link` headers to the responses of a deprecated service
+// and counts them. It returns nil when the service isn`t deprecated
+func newDeprecation(service string, opts domain.Deprecation) (func(endpoint string) func(*http.Response) error, error) {
+	if !opts.Enable {
+		return nil, nil
+	}
+	// without a date the service is deprecated since now
+	deprecation := "true"
+	if opts.Date != "" {
+		date, err := time.Parse(time.RFC3339, opts.Date)
+		if err != nil {
+			return nil, errors.Errorf("%w: date %v", errors.ErrDeprecationDate, err)
+		}
+		deprecation = "@" + strconv.FormatInt(date.Unix(), 10)
+	}
+	var sunset string
+	if opts.Sunset != "" {
+		date, err := time.Parse(time.RFC3339, opts.Sunset)
+		if err != nil {
+			return nil, errors.Errorf("%w: sunset %v", errors.ErrDeprecationDate, err)
+		}
+		sunset = date.UTC().Format(http.TimeFormat)
+	}
+	var link string
+	if opts.Link != "" {
+		link = fmt.Sprintf("<%s>; rel=\"deprecation\"", opts.Link)
+	}
+
+	return func(endpoint string) func(*http.Response) error {
+		counter := otelify.MetricDeprecatedRequests.WithLabelValues(service, endpoint)
+		return func(resp *http.Response) error {
+			counter.Inc()
+			resp.Header.Set("Deprecation", deprecation)
+			if sunset != "" {
+				resp.Header.Set("Sunset", sunset)
+			}
+			if link != "" {
+				resp.Header.Add("Link", link)
+			}
+			return nil
+		}
+	}, nil
+}
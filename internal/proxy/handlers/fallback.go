@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"github.com/kenriortega/ngonx/pkg/reqid"
+	"go.uber.org/zap"
+)
+
+// maxLastKnownGood max entries remembered by route for the fallback
+const maxLastKnownGood = 1024
+
+// cachedResponse upstream response kept in memory
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// readCachedResponse copy the upstream response and restore its body,
+// returns nil when the body is bigger than `MaxBufferedResponse`. The bigger
+// chunked bodies keep streaming after the bytes already read
+func readCachedResponse(resp *http.Response) (*cachedResponse, error) {
+	if resp.ContentLength > MaxBufferedResponse {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxBufferedResponse+1))
+	if err == nil && len(body) > MaxBufferedResponse {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil, nil
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return &cachedResponse{
+		status: resp.StatusCode,
+		header: resp.Header.Clone(),
+		body:   body,
+	}, nil
+}
+
+// write the response with an extra header telling where it comes from
+func (c *cachedResponse) write(w http.ResponseWriter, key, value string) {
+	for k, v := range c.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(c.body)))
+	w.Header().Set(key, value)
+	w.WriteHeader(c.status)
+	_, _ = w.Write(c.body)
+}
+
+// fallback serves a static or the last known good response of a route
+// when the upstream is unreachable
+type fallback struct {
+	opts     domain.Fallback
+	mu       sync.RWMutex
+	lastGood map[string]*cachedResponse
+}
+
+// newFallback return nil when the route has no fallback configured
+func newFallback(opts domain.Fallback) *fallback {
+	if opts.Body == "" && !opts.LastKnownGood {
+		return nil
+	}
+	return &fallback{
+		opts:     opts,
+		lastGood: make(map[string]*cachedResponse),
+	}
+}
+
+// key of the request among the last known good responses, see requestKey.
+// Authenticated requests have none without a `claim:` key_by, their responses
+// are often personalized and one user's would be served to another
+func (f *fallback) key(req *http.Request) (string, bool) {
+	if isAuthenticated(req) && !hasClaimKey(f.opts.KeyBy) {
+		return "", false
+	}
+	return requestKey(req, "", f.opts.KeyBy)
+}
+
+// record ModifyResponse that remembers the successful GET responses
+func (f *fallback) record(resp *http.Response) error {
+	if resp.Request == nil || resp.Request.Method != http.MethodGet ||
+		resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil
+	}
+	key, ok := f.key(resp.Request)
+	if !ok {
+		return nil
+	}
+	cached, err := readCachedResponse(resp)
+	if err != nil || cached == nil {
+		return err
+	}
+
+	f.mu.Lock()
+	if _, ok := f.lastGood[key]; !ok && len(f.lastGood) >= maxLastKnownGood {
+		for k := range f.lastGood {
+			delete(f.lastGood, k)
+			break
+		}
+	}
+	f.lastGood[key] = cached
+	f.mu.Unlock()
+	return nil
+}
+
+// serve write the fallback response, returns false when there is nothing to serve.
+// Only GET and HEAD get it, a write answered with a 2xx would look successful
+func (f *fallback) serve(w http.ResponseWriter, req *http.Request) bool {
+	if f == nil || req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if key, ok := f.key(req); ok && f.opts.LastKnownGood {
+		f.mu.RLock()
+		cached, ok := f.lastGood[key]
+		f.mu.RUnlock()
+		if ok {
+			cached.write(w, "X-Ngonx-Fallback", "last-known-good")
+			f.log(req, "last-known-good")
+			return true
+		}
+	}
+	if f.opts.Body == "" {
+		return false
+	}
+	status := f.opts.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := f.opts.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Ngonx-Fallback", "static")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(f.opts.Body))
+	f.log(req, "static")
+	return true
+}
+
+func (f *fallback) log(req *http.Request, kind string) {
+	logger.LogWarn(
+		"proxy: upstream unreachable, serving fallback",
+		zap.String("path", req.URL.Path),
+		zap.String("fallback", kind),
+	)
+}
+
+// chainModifyResponse run the modifiers in order until one fails
+func chainModifyResponse(modifiers ...func(*http.Response) error) func(*http.Response) error {
+	if len(modifiers) == 1 {
+		return modifiers[0]
+	}
+	return func(resp *http.Response) error {
+		for _, modify := range modifiers {
+			if err := modify(resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// statusClientClosedRequest status recorded (metrics and access log) for the
+// requests whose client left before the upstream answered, as nginx does
+const statusClientClosedRequest = 499
+
+// proxyErrorHandler answer with the stale cache entry or the fallback of the route
+// when there is one, otherwise with a 502 `ResponseMiddleware` (504 for the
+// timeouts). A rejected content-type always gets its own 502. Requests whose client disconnected are only logged and counted,
+// nobody reads the answer
+func proxyErrorHandler(endpoint string, fb *fallback, cache *responseCache) func(http.ResponseWriter, *http.Request, error) {
+	disconnects := otelify.MetricClientDisconnects.WithLabelValues(endpoint)
+	bodyTimeouts := otelify.MetricBodyReadTimeouts.WithLabelValues(endpoint)
+	return func(w http.ResponseWriter, req *http.Request, err error) {
+		// the client was too slow sending the body, it's neither the upstream nor a disconnect
+		if bodyTimedOut(req) {
+			bodyTimeouts.Inc()
+			logger.LogWarn(
+				"proxy: request body not received in time",
+				zap.String("endpoint", endpoint),
+				zap.String("path", req.URL.Path),
+				zap.String("request_id", reqid.FromContext(req.Context())),
+			)
+			w.Header().Set("Connection", "close")
+			writeResponseMiddleware(w, http.StatusRequestTimeout, errors.ErrBodyReadTimeout.Error())
+			return
+		}
+		if errors.ErrorIs(err, context.Canceled) && errors.ErrorIs(req.Context().Err(), context.Canceled) {
+			disconnects.Inc()
+			logger.LogDebug(
+				"proxy: client disconnected before the upstream answered",
+				zap.String("endpoint", endpoint),
+				zap.String("path", req.URL.Path),
+				zap.String("request_id", reqid.FromContext(req.Context())),
+			)
+			w.WriteHeader(statusClientClosedRequest)
+			return
+		}
+		if IsResponseHeaderTooLarge(err) {
+			logger.LogError(errors.Errorf("proxy: %v", err).Error(), zap.String("path", req.URL.Path))
+			writeResponseMiddleware(w, http.StatusBadGateway, errors.ErrUpstreamHeaderTooLarge.Error())
+			return
+		}
+		// the upstream answered, a stale entry or the fallback would hide the wrong response as an outage
+		if errors.ErrorIs(err, errors.ErrUnexpectedContentType) {
+			writeResponseMiddleware(w, http.StatusBadGateway, errors.ErrUnexpectedContentType.Error())
+			return
+		}
+		if cache.serveStale(w, req, err) || fb.serve(w, req) {
+			return
+		}
+		// the deadline of the request (timeout/request_timeout) or one of the transport
+		if errors.ErrorIs(req.Context().Err(), context.DeadlineExceeded) {
+			writeResponseMiddleware(w, http.StatusGatewayTimeout, errors.ErrRequestTimeout.Error())
+			return
+		}
+		if isTimeout(err) {
+			logger.LogWarn(
+				"proxy: upstream timeout",
+				zap.String("endpoint", endpoint),
+				zap.String("path", req.URL.Path),
+				zap.String("request_id", reqid.FromContext(req.Context())),
+				zap.Error(err),
+			)
+			writeResponseMiddleware(w, http.StatusGatewayTimeout, errors.ErrUpstreamTimeout.Error())
+			return
+		}
+		// the transport error (addresses of the backend) is only logged
+		logger.LogError(
+			errors.Errorf("proxy: %w: %v", errors.ErrUpstreamUnavailable, err).Error(),
+			zap.String("endpoint", endpoint),
+			zap.String("path", req.URL.Path),
+			zap.String("request_id", reqid.FromContext(req.Context())),
+		)
+		writeResponseMiddleware(w, http.StatusBadGateway, errors.ErrUpstreamUnavailable.Error())
+	}
+}
+
+// isTimeout returns true for the timeouts of the transport (dial, tls handshake
+// and response headers)
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.ErrorAs(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// closeRecorder body remembering it was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// Test_readCachedResponse_Chunked chunked bodies over the limit aren't kept
+// and reach the client whole
+func Test_readCachedResponse_Chunked(t *testing.T) {
+	const size = MaxBufferedResponse + 1000
+	for name, modify := range map[string]func(*http.Response) error{
+		"last known good": newFallback(domain.Fallback{LastKnownGood: true}).record,
+		"cache":           newResponseCache(domain.Cache{TTL: time.Minute}).store,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/large", nil)
+		upstream := &closeRecorder{Reader: strings.NewReader(strings.Repeat("x", size))}
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			ContentLength: -1,
+			Body:          upstream,
+			Request:       req.WithContext(context.WithValue(req.Context(), cacheKeyCtx{}, "/large")),
+		}
+		if err := modify(resp); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if upstream.closed {
+			t.Errorf("%s: Expected the upstream body open and result is closed", name)
+		}
+		n, _ := io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if n != size || !upstream.closed {
+			t.Errorf("%s: Expected %d bytes and the upstream body closed and result are %d %v", name, size, n, upstream.closed)
+		}
+	}
+}
+
+// Test_fallback_Authenticated the authenticated responses are kept and served
+// only per claim key_by tenant
+func Test_fallback_Authenticated(t *testing.T) {
+	tenant := func(id string) *http.Request {
+		req := withAuthenticated(httptest.NewRequest(http.MethodGet, "/profile", nil))
+		claims := map[string]interface{}{"tenant_id": id}
+		return req.WithContext(context.WithValue(req.Context(), jwtClaimsCtx{}, claims))
+	}
+	record := func(f *fallback, req *http.Request) {
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("profile of " + req.URL.Path)),
+			Request:    req,
+		}
+		if err := f.record(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, keyBy := range map[string][]string{"no key_by": nil, "header key_by": {"header:X-Tenant-ID"}} {
+		shared := newFallback(domain.Fallback{LastKnownGood: true, KeyBy: keyBy})
+		record(shared, tenant("a"))
+		if len(shared.lastGood) != 0 {
+			t.Errorf("%s: Expected no authenticated entry and result are %d", name, len(shared.lastGood))
+		}
+		if shared.serve(httptest.NewRecorder(), tenant("a")) {
+			t.Errorf("%s: Expected no fallback and result is served", name)
+		}
+	}
+
+	keyed := newFallback(domain.Fallback{LastKnownGood: true, KeyBy: []string{"claim:tenant_id"}})
+	record(keyed, tenant("a"))
+	if keyed.serve(httptest.NewRecorder(), tenant("b")) {
+		t.Error("Expected no fallback for another tenant and result is served")
+	}
+	w := httptest.NewRecorder()
+	if !keyed.serve(w, tenant("a")) || w.Header().Get("X-Ngonx-Fallback") != "last-known-good" {
+		t.Errorf("Expected the last known good of the tenant and result are %q", w.Header().Get("X-Ngonx-Fallback"))
+	}
+}
+
+// Test_ProxyGateway_FallbackMethods the fallbacks answer the reads of an
+// unreachable upstream, the writes get 502
+func Test_ProxyGateway_FallbackMethods(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "orders")
+	}))
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI: backend.URL,
+		Endpoints: []domain.Endpoint{{PathEndpoint: "/", PathToProxy: "/fallback/methods/", Fallback: domain.Fallback{
+			LastKnownGood: true, Body: `{"orders": []}`,
+		}}},
+	}, "", "", "none")
+	serve := func(method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(method, "/fallback/methods/orders", strings.NewReader("{}")))
+		return rec
+	}
+	if rec := serve(http.MethodGet); rec.Body.String() != "orders" {
+		t.Fatalf("Expected the upstream response and result are %d %q", rec.Code, rec.Body.String())
+	}
+	backend.Close()
+
+	if rec := serve(http.MethodGet); rec.Header().Get("X-Ngonx-Fallback") != "last-known-good" || rec.Body.String() != "orders" {
+		t.Errorf("Expected the last known good of the GET and result are %q %q", rec.Header().Get("X-Ngonx-Fallback"), rec.Body.String())
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		if rec := serve(method); rec.Code != http.StatusBadGateway || rec.Header().Get("X-Ngonx-Fallback") != "" {
+			t.Errorf("%s: Expected status %d without fallback and result are %d %q", method, http.StatusBadGateway, rec.Code, rec.Header().Get("X-Ngonx-Fallback"))
+		}
+	}
+}
+
+// Test_ProxyGateway_RejectedContentType a rejected content-type gets its own
+// 502, neither the stale entry nor the fallback of the route
+func Test_ProxyGateway_RejectedContentType(t *testing.T) {
+	contentType := "application/json"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.WriteString(w, `{"ok": true}`)
+	}))
+	defer backend.Close()
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI: backend.URL,
+		Endpoints: []domain.Endpoint{{
+			PathEndpoint: "/", PathToProxy: "/contenttype/reject/",
+			ExpectedContentType: "application/json", RejectUnexpectedContentType: true,
+			Cache:    domain.Cache{TTL: time.Millisecond, StaleOnError: time.Minute},
+			Fallback: domain.Fallback{LastKnownGood: true, Body: `{"ok": false}`},
+		}},
+	}, "", "", "none")
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/contenttype/reject/status", nil))
+		return rec
+	}
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d and result are %d", http.StatusOK, rec.Code)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	contentType = "text/html"
+	rec := serve()
+	want := `{"message":"` + errors.ErrUnexpectedContentType.Error() + `","code":502}`
+	if rec.Code != http.StatusBadGateway || rec.Body.String() != want {
+		t.Errorf("Expected %d %s and result are %d %s", http.StatusBadGateway, want, rec.Code, rec.Body.String())
+	}
+	if cache, fallback := rec.Header().Get("X-Ngonx-Cache"), rec.Header().Get("X-Ngonx-Fallback"); cache == "STALE" || fallback != "" {
+		t.Errorf("Expected no stale entry nor fallback and result are %q %q", cache, fallback)
+	}
+}
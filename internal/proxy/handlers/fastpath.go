@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// newFastProxy returns the reverse proxy for public routes without middleware.
+// Everything that doesn`t depend on the request (traceID field, ModifyResponse)
+// is built once, so the hot path only pays for the url rewrite, the optional
+// rewrites and the access log instead of the per request allocations
+// of otelRegisterByRequest
+func newFastProxy(
+	target *url.URL,
+	transport http.RoundTripper,
+	traceID string,
+	start time.Time,
+	rewrite func(*http.Request),
+) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+
+	traceField := zap.String("traceID", traceID)
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		if rewrite != nil {
+			rewrite(req)
+		}
+		latency := time.Since(start)
+		logger.LogInfo(
+			"proxy.Director.Metric",
+			traceField,
+			zap.String("path", req.URL.Path),
+			zap.Duration("latency", latency),
+		)
+	}
+	// the error handler is the one of the route, see ProxyGateway
+	proxy.ModifyResponse = setProxyHeader
+	return proxy
+}
+
+// setProxyHeader ModifyResponse shared by every public route
+func setProxyHeader(resp *http.Response) error {
+	resp.Header.Set("X-Proxy", "Ngonx")
+	return nil
+}
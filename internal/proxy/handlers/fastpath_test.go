@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var benchTarget, _ = url.Parse("http://localhost:3000/api/v1/health/")
+
+// Benchmark_Director_Default director used for public routes before the fast path
+func Benchmark_Director_Default(b *testing.B) {
+	ctx, span := otel.Tracer("proxy.bench").Start(context.Background(), "bench")
+	defer span.End()
+	start := time.Now()
+
+	proxy := httputil.NewSingleHostReverseProxy(benchTarget)
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		otelRegisterByRequest(ctx, start, req, nil)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/health/?q=1", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		proxy.Director(req.Clone(req.Context()))
+	}
+}
+
+// Benchmark_Director_FastPath director used for public routes by newFastProxy
+func Benchmark_Director_FastPath(b *testing.B) {
+	ctx, span := otel.Tracer("proxy.bench").Start(context.Background(), "bench")
+	defer span.End()
+	traceID := trace.SpanContextFromContext(ctx).TraceID().String()
+
+	proxy := newFastProxy(benchTarget, http.DefaultTransport, traceID, time.Now(), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/health/?q=1", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		proxy.Director(req.Clone(req.Context()))
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+)
+
+// normalizeHeaders returns the ModifyResponse that canonicalizes the names of
+// the upstream headers, joining the values of names that only differ in casing,
+// and merges the values of the configured headers into a single deduplicated
+// one. `Set-Cookie` can't be merged and is always left as is
+func normalizeHeaders(opts domain.HeaderNormalization) func(*http.Response) error {
+	merge := make([]string, 0, len(opts.Merge))
+	for _, name := range opts.Merge {
+		if name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)); name != "Set-Cookie" {
+			merge = append(merge, name)
+		}
+	}
+	return func(resp *http.Response) error {
+		if opts.Canonicalize {
+			for name, values := range resp.Header {
+				canonical := textproto.CanonicalMIMEHeaderKey(name)
+				if canonical == name {
+					continue
+				}
+				delete(resp.Header, name)
+				resp.Header[canonical] = append(resp.Header[canonical], values...)
+			}
+		}
+		for _, name := range merge {
+			values := resp.Header.Values(name)
+			if len(values) == 0 {
+				continue
+			}
+			resp.Header.Set(name, strings.Join(dedupValues(values), ", "))
+		}
+		return nil
+	}
+}
+
+// dedupValues split the comma separated values keeping the first occurrence,
+// the comparison ignores the casing
+func dedupValues(values []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" || seen[strings.ToLower(v)] {
+				continue
+			}
+			seen[strings.ToLower(v)] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
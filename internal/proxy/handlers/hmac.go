@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/nonce"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// HeaderSignature hex HMAC-SHA256 of the canonical request
+	HeaderSignature = "X-Signature"
+	// HeaderTimestamp unix seconds when the request was signed
+	HeaderTimestamp = "X-Timestamp"
+	// DefaultNonceHeader header with the unique value of each signed request
+	DefaultNonceHeader = "X-Nonce"
+	// DefaultHMACTolerance max clock skew accepted for the timestamp
+	DefaultHMACTolerance = 5 * time.Minute
+	// DefaultHMACMaxBody max bytes of a signed request body
+	DefaultHMACMaxBody = 10 << 20
+)
+
+// HMACOptions options for the `hmac` security type
+type HMACOptions struct {
+	// Tolerance max age of the signed timestamp
+	Tolerance time.Duration
+	// NonceHeader header name of the request nonce
+	NonceHeader string
+	// MaxBody max bytes of the body hashed before the signature is verified,
+	// bigger requests get 413. 0 uses DefaultHMACMaxBody
+	MaxBody int64
+	// Nonces records the nonces during the tolerance to reject replays,
+	// nil disables the replay protection
+	Nonces nonce.Store
+}
+
+func (o HMACOptions) tolerance() time.Duration {
+	if o.Tolerance <= 0 {
+		return DefaultHMACTolerance
+	}
+	return o.Tolerance
+}
+
+func (o HMACOptions) maxBody() int64 {
+	if o.MaxBody <= 0 {
+		return DefaultHMACMaxBody
+	}
+	return o.MaxBody
+}
+
+func (o HMACOptions) nonceHeader() string {
+	if o.NonceHeader == "" {
+		return DefaultNonceHeader
+	}
+	return o.NonceHeader
+}
+
+// canonicalRequest build the string to sign
+// METHOD\nREQUEST_URI\nTIMESTAMP\nNONCE\nHEX(SHA256(BODY))
+func canonicalRequest(req *http.Request, timestamp, nonce string, bodySum []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(req.Method)
+	b.WriteByte('\n')
+	b.WriteString(req.URL.RequestURI())
+	b.WriteByte('\n')
+	b.WriteString(timestamp)
+	b.WriteByte('\n')
+	b.WriteString(nonce)
+	b.WriteByte('\n')
+	b.WriteString(hex.EncodeToString(bodySum))
+	return b.Bytes()
+}
+
+// checkHMAC check the request signature, the timestamp tolerance
+// and when a nonce store is configured that the nonce wasn`t used before.
+// The body hash is computed while the body is spooled, only once the headers
+// and the secret are valid and up to the max body, the upstream only gets the
+// body once the signature is valid
+func checkHMAC(
+	ctx context.Context,
+	req *http.Request,
+	ph *ProxyHandler,
+	engine, key string,
+) (err error) {
+	ctx, span := otel.Tracer("proxy.gateway.checkHMAC").Start(ctx, "checkHMAC")
+	defer span.End()
+	traceID := trace.SpanContextFromContext(ctx).TraceID().String()
+
+	signature, err := hex.DecodeString(req.Header.Get(HeaderSignature))
+	if err != nil || len(signature) == 0 {
+		otelify.InstrumentedError(span, "checkHMAC.signature", traceID, errors.ErrHMACSignatureFormat)
+		return errors.ErrHMACSignatureFormat
+	}
+	timestamp := req.Header.Get(HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		otelify.InstrumentedError(span, "checkHMAC.timestamp", traceID, errors.ErrHMACTimestamp)
+		return errors.ErrHMACTimestamp
+	}
+	signed := time.Unix(unix, 0)
+	skew := time.Since(signed)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > ph.HMAC.tolerance() {
+		otelify.InstrumentedError(span, "checkHMAC.tolerance", traceID, errors.ErrHMACTimestamp)
+		return errors.ErrHMACTimestamp
+	}
+	nonceValue := req.Header.Get(ph.HMAC.nonceHeader())
+	if ph.HMAC.Nonces != nil && nonceValue == "" {
+		otelify.InstrumentedError(span, "checkHMAC.nonce", traceID, errors.ErrHMACNonce)
+		return errors.ErrHMACNonce
+	}
+
+	secret, err := getSecret(ph, engine, key)
+	if err != nil {
+		otelify.InstrumentedError(span, "checkHMAC.GetKEY", traceID, err)
+		return err
+	}
+
+	bodyHash := sha256.New()
+	if err := spoolBody(req, bodyHash, ph.HMAC.maxBody()); err != nil {
+		otelify.InstrumentedError(span, "checkHMAC.body", traceID, err)
+		return err
+	}
+	defer func() {
+		// rejected requests never reach the transport that closes the body
+		if err != nil && req.Body != nil {
+			_ = req.Body.Close()
+		}
+	}()
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(canonicalRequest(req, timestamp, nonceValue, bodyHash.Sum(nil)))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		otelify.InstrumentedError(span, "checkHMAC.verify", traceID, errors.ErrTokenHMACValidation)
+		return errors.ErrTokenHMACValidation
+	}
+
+	// the nonce is only recorded for valid signatures, so forged requests
+	// can`t burn the nonce of a legit client. It`s kept while the timestamp is
+	// accepted, up to twice the tolerance for a timestamp in the future
+	if ph.HMAC.Nonces != nil {
+		ttl := time.Until(signed.Add(ph.HMAC.tolerance()))
+		if ttl < time.Second {
+			ttl = time.Second
+		}
+		seen, err := ph.HMAC.Nonces.Seen(ctx, key+":"+nonceValue, ttl)
+		if err != nil {
+			otelify.InstrumentedError(span, "checkHMAC.nonceStore", traceID, err)
+			return errors.ErrHMACNonce
+		}
+		if seen {
+			otelify.InstrumentedError(span, "checkHMAC.replay", traceID, errors.ErrHMACReplay)
+			return errors.ErrHMACReplay
+		}
+	}
+	otelify.InstrumentedInfo(span, "checkHMAC", traceID)
+	return nil
+}
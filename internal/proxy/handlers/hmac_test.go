@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	services "github.com/kenriortega/ngonx/internal/proxy/services"
+	"github.com/kenriortega/ngonx/pkg/nonce"
+)
+
+const testHMACSecret = "hmac-secret"
+
+// countingReader body counting the bytes read by the gateway
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += n
+	return n, err
+}
+
+// signedRequest request signed as the clients of the hmac security type do
+func signedRequest(method, target, body string, signed time.Time, nonce string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	timestamp := strconv.FormatInt(signed.Unix(), 10)
+	bodySum := sha256.Sum256([]byte(body))
+	mac := hmac.New(sha256.New, []byte(testHMACSecret))
+	_, _ = mac.Write(canonicalRequest(req, timestamp, nonce, bodySum[:]))
+	req.Header.Set(HeaderSignature, hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(DefaultNonceHeader, nonce)
+	return req
+}
+
+// hmacGateway route of the hmac security type on path
+func hmacGateway(t *testing.T, path string, opts HMACOptions) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	}))
+	t.Cleanup(backend.Close)
+	ph := &ProxyHandler{Service: services.NewProxyService(domain.NewProxyRepository()), HMAC: opts}
+	if _, err := ph.Service.SaveSecretKEY("memory", "key_hmac", testHMACSecret); err != nil {
+		t.Fatal(err)
+	}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:   backend.URL,
+		Endpoints: []domain.Endpoint{{PathEndpoint: "/", PathToProxy: path, PathProtected: true}},
+	}, "memory", "key_hmac", "hmac")
+}
+
+// Test_ProxyGateway_HMACMaxBody bodies over the max get 413 without being read
+// further, unsigned requests never get their body read
+func Test_ProxyGateway_HMACMaxBody(t *testing.T) {
+	hmacGateway(t, "/hmac/maxbody/", HMACOptions{MaxBody: 16})
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(signedRequest(http.MethodPost, "/hmac/maxbody/upload", "small body", time.Now(), "n1")); rec.Code != http.StatusOK || rec.Body.String() != "small body" {
+		t.Errorf("Expected status 200 and the body forwarded and result are %d %q", rec.Code, rec.Body.String())
+	}
+
+	large := strings.Repeat("x", 1<<20)
+	if rec := serve(signedRequest(http.MethodPost, "/hmac/maxbody/upload", large, time.Now(), "n2")); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for a declared large body and result are %d", rec.Code)
+	}
+
+	// chunked, the length is only known while it`s read
+	req := signedRequest(http.MethodPost, "/hmac/maxbody/upload", large, time.Now(), "n3")
+	chunked := &countingReader{Reader: strings.NewReader(large)}
+	req.Body, req.ContentLength = io.NopCloser(chunked), -1
+	if rec := serve(req); rec.Code != http.StatusRequestEntityTooLarge || chunked.n > 16+1 {
+		t.Errorf("Expected status 413 reading up to the limit and result are %d after %d bytes", rec.Code, chunked.n)
+	}
+
+	// an expired timestamp is refused before the body is read
+	req = signedRequest(http.MethodPost, "/hmac/maxbody/upload", large, time.Now().Add(-time.Hour), "n4")
+	unsigned := &countingReader{Reader: strings.NewReader(large)}
+	req.Body, req.ContentLength = io.NopCloser(unsigned), -1
+	if rec := serve(req); rec.Code != http.StatusUnauthorized || unsigned.n != 0 {
+		t.Errorf("Expected status 401 without reading the body and result are %d after %d bytes", rec.Code, unsigned.n)
+	}
+}
+
+// Test_ProxyGateway_HMACReplay a nonce is remembered while its timestamp is
+// accepted, also for a timestamp signed ahead of the gateway clock
+func Test_ProxyGateway_HMACReplay(t *testing.T) {
+	const tolerance = 2 * time.Second
+	hmacGateway(t, "/hmac/replay/", HMACOptions{Tolerance: tolerance, Nonces: nonce.NewMemoryStore()})
+	serve := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(signedRequest(http.MethodGet, "/hmac/replay/users", "", time.Now(), "now")); code != http.StatusOK {
+		t.Fatalf("Expected status 200 and result are %d", code)
+	}
+	if code := serve(signedRequest(http.MethodGet, "/hmac/replay/users", "", time.Now(), "now")); code != http.StatusUnauthorized {
+		t.Errorf("Expected the replay rejected with 401 and result are %d", code)
+	}
+
+	future := time.Now().Add(tolerance)
+	if code := serve(signedRequest(http.MethodGet, "/hmac/replay/users", "", future, "future")); code != http.StatusOK {
+		t.Fatalf("Expected status 200 for a timestamp within the tolerance and result are %d", code)
+	}
+	// one tolerance later the timestamp is still accepted, the nonce must be too
+	time.Sleep(tolerance + 100*time.Millisecond)
+	if code := serve(signedRequest(http.MethodGet, "/hmac/replay/users", "", future, "future")); code != http.StatusUnauthorized {
+		t.Errorf("Expected the late replay rejected with 401 and result are %d", code)
+	}
+}
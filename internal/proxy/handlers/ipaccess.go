@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/ipfilter"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"github.com/kenriortega/ngonx/pkg/reqid"
+	"go.uber.org/zap"
+)
+
+// ipAccess allow and deny lists of the client ips (see extractIpAddr) of a
+// service, parsed once when the routes are built
+type ipAccess struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// newIPAccess parse the lists, nil when both are empty
+func newIPAccess(allowCIDRs, denyCIDRs []string) (*ipAccess, error) {
+	if len(allowCIDRs) == 0 && len(denyCIDRs) == 0 {
+		return nil, nil
+	}
+	allow, err := ipfilter.ParseCIDRs(allowCIDRs)
+	if err != nil {
+		return nil, errors.Errorf("allow_cidrs: %w", err)
+	}
+	deny, err := ipfilter.ParseCIDRs(denyCIDRs)
+	if err != nil {
+		return nil, errors.Errorf("deny_cidrs: %w", err)
+	}
+	return &ipAccess{allow: allow, deny: deny}, nil
+}
+
+// check returns the list that rejects the ip, empty when it's allowed. Deny
+// takes precedence and an unknown ip is only allowed without allow list
+func (a *ipAccess) check(ip net.IP) string {
+	if ip != nil && ipfilter.Contains(a.deny, ip) {
+		return "deny"
+	}
+	if len(a.allow) > 0 && (ip == nil || !ipfilter.Contains(a.allow, ip)) {
+		return "allow"
+	}
+	return ""
+}
+
+// handler answers 403 to the rejected clients, they never reach the upstream
+func (a *ipAccess) handler(endpoint string, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client := extractIpAddr(req)
+		list := a.check(net.ParseIP(client))
+		if list == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		otelify.MetricClientIPRejected.WithLabelValues(endpoint, list).Inc()
+		logger.LogWarn(
+			"proxy: client ip rejected",
+			zap.String("endpoint", endpoint),
+			zap.String("client", client),
+			zap.String("list", list),
+			zap.String("request_id", reqid.FromContext(req.Context())),
+		)
+		writeResponseMiddleware(w, http.StatusForbidden, errors.ErrClientIPForbidden.Error())
+	})
+}
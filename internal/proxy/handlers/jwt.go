@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"strings"
+
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// algorithms of the `jwt` security type
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgRS256 = "RS256"
+	JWTAlgES256 = "ES256"
+)
+
+// JWTOptions options for the `jwt` security type, HS256 (the default) uses the
+// secret key and RS256/ES256 the PEM public key of PublicKeyFile.
+// Issuer and Audience reject the tokens minted for others, empty skips the claim.
+// The token is read from Header after the Scheme prefix (none when it`s empty),
+// an empty Header uses `Authorization: Bearer <token>`
+type JWTOptions struct {
+	Header        string
+	Scheme        string
+	Alg           string
+	PublicKeyFile string
+	Issuer        string
+	Audience      string
+}
+
+// newJWTAlgorithm returns the algorithm that verifies the tokens
+func newJWTAlgorithm(opts JWTOptions, secret string) (jwt.Algorithm, error) {
+	switch strings.ToUpper(opts.Alg) {
+	case "", JWTAlgHS256:
+		return jwt.NewHS256([]byte(secret)), nil
+	case JWTAlgRS256:
+		pub, err := readPublicKey(opts.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.Errorf("%w: %s is not a RSA key", errors.ErrJWTPublicKey, opts.PublicKeyFile)
+		}
+		return jwt.NewRS256(jwt.RSAPublicKey(rsaKey)), nil
+	case JWTAlgES256:
+		pub, err := readPublicKey(opts.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ecKey, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.Errorf("%w: %s is not an ECDSA key", errors.ErrJWTPublicKey, opts.PublicKeyFile)
+		}
+		return jwt.NewES256(jwt.ECDSAPublicKey(ecKey)), nil
+	}
+	return nil, errors.Errorf("%w: %q", errors.ErrUnsupportedJWTAlg, opts.Alg)
+}
+
+// readPublicKey parse a PEM `PUBLIC KEY`, `RSA PUBLIC KEY` or `CERTIFICATE`
+func readPublicKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Errorf("%w: %v", errors.ErrJWTPublicKey, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("%w: no PEM block in %s", errors.ErrJWTPublicKey, path)
+	}
+	var pub interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.Errorf("%w: %v", errors.ErrJWTPublicKey, err)
+	}
+	return pub, nil
+}
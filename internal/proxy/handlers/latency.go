@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// latencyTimerCtx context key of the latencyTimer of the request
+type latencyTimerCtx struct{}
+
+// latencyTimer observes once the latency of a request
+type latencyTimer struct {
+	start    time.Time
+	observer prometheus.Observer
+	once     sync.Once
+}
+
+func (t *latencyTimer) observe(ctx context.Context) {
+	t.once.Do(func() {
+		elapsed := time.Since(t.start).Seconds()
+		sc := trace.SpanContextFromContext(ctx)
+		if exemplar, ok := t.observer.(prometheus.ExemplarObserver); ok && sc.HasTraceID() {
+			exemplar.ObserveWithExemplar(elapsed, prometheus.Labels{"traceID": sc.TraceID().String()})
+			return
+		}
+		t.observer.Observe(elapsed)
+	})
+}
+
+// observeLatency records in the histogram of the route class (see
+// otelify.LatencyObserver) the time from the request receipt to the upstream
+// response headers (observeResponseLatency), requests answered without them
+// (auth, limits, timeouts, unreachable upstreams) are observed when the handler returns
+func observeLatency(observer prometheus.Observer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		timer := &latencyTimer{start: time.Now(), observer: observer}
+		req = req.WithContext(context.WithValue(req.Context(), latencyTimerCtx{}, timer))
+		defer timer.observe(req.Context())
+		next.ServeHTTP(w, req)
+	})
+}
+
+// observeResponseLatency ModifyResponse that observes the latency once the
+// upstream response headers arrived
+func observeResponseLatency(resp *http.Response) error {
+	if timer, ok := resp.Request.Context().Value(latencyTimerCtx{}).(*latencyTimer); ok {
+		timer.observe(resp.Request.Context())
+	}
+	return nil
+}
@@ -1,13 +1,16 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
 	"github.com/kenriortega/ngonx/pkg/errors"
 	"github.com/kenriortega/ngonx/pkg/logger"
+	"go.uber.org/zap"
 )
 
 // ServerPool struct for server pool
@@ -29,28 +32,156 @@ func GetRetryFromContext(r *http.Request) int {
 	return 0
 }
 
+// DeadlineBudget wraps the handler with a single deadline for the whole request,
+// all retries and failovers share the same budget. A zero budget disables it
+func DeadlineBudget(budget time.Duration, next http.Handler) http.Handler {
+	if budget <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), budget)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// upstreamCalls upstream calls of a request and its limit
+type upstreamCalls struct {
+	max   int
+	calls int
+}
+
+// MaxUpstreamCalls caps the upstream calls of a request across all the backends,
+// retries and failovers included. A max <= 0 disables it
+func MaxUpstreamCalls(max int, next http.Handler) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), domain.UPSTREAM_CALLS, &upstreamCalls{max: max})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// TakeUpstreamCall count an upstream call of the request, returns false when
+// the request already made the max upstream calls. Retries and failovers of a
+// request run one after the other, the counter doesn`t need a lock
+func TakeUpstreamCall(r *http.Request) bool {
+	limit, ok := r.Context().Value(domain.UPSTREAM_CALLS).(*upstreamCalls)
+	if !ok {
+		return true
+	}
+	if limit.calls >= limit.max {
+		return false
+	}
+	limit.calls++
+	return true
+}
+
+// IsBudgetExhausted returns true when the request deadline budget was consumed
+func IsBudgetExhausted(r *http.Request) bool {
+	return errors.ErrorIs(r.Context().Err(), context.DeadlineExceeded)
+}
+
+// WaitRetry waits the backoff duration unless the request deadline comes first,
+// returns false when the budget was exhausted while waiting
+func WaitRetry(r *http.Request, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
 // Lbalancer load balances the incoming request
 func Lbalancer(w http.ResponseWriter, r *http.Request) {
+	if IsBudgetExhausted(r) {
+		logger.LogInfo(fmt.Sprintf("lb: %s(%s) Deadline budget exhausted, terminating\n", r.RemoteAddr, r.URL.Path))
+		writeResponseMiddleware(w, http.StatusGatewayTimeout, errors.ErrLBDeadlineBudget.Error())
+		return
+	}
 	attempts := GetAttemptsFromContext(r)
 	if attempts > 3 {
 		logger.LogInfo(fmt.Sprintf("lb: %s(%s) Max attempts reached, terminating\n", r.RemoteAddr, r.URL.Path))
-		http.Error(w, errors.ErrLBHttp.Error(), http.StatusServiceUnavailable)
+		writeResponseMiddleware(w, http.StatusServiceUnavailable, errors.ErrLBHttp.Error())
 		return
 	}
 
-	peer := ServerPool.GetNextPeer()
+	peer := affinePeer(w, r)
 	if peer != nil {
+		if !TakeUpstreamCall(r) {
+			logger.LogInfo(fmt.Sprintf("lb: %s(%s) Max upstream calls reached, terminating\n", r.RemoteAddr, r.URL.Path))
+			writeResponseMiddleware(w, http.StatusServiceUnavailable, errors.ErrLBMaxUpstreamCalls.Error())
+			return
+		}
+		defer peer.Begin()()
 		peer.ReverseProxy.ServeHTTP(w, r)
 		return
 	}
-	http.Error(w, errors.ErrLBHttp.Error(), http.StatusServiceUnavailable)
+	writeResponseMiddleware(w, http.StatusServiceUnavailable, errors.ErrLBHttp.Error())
+}
+
+// affinePeer returns the backend pinned by the affinity cookie while it takes
+// traffic, otherwise the next peer pinned for the next requests. Without
+// ServerPool.AffinityCookie, or a client not keeping cookies, it just balances
+func affinePeer(w http.ResponseWriter, r *http.Request) *domain.Backend {
+	name := ServerPool.AffinityCookie
+	if name == "" {
+		return ServerPool.GetNextPeer()
+	}
+	pinned := ""
+	if cookie, err := r.Cookie(name); err == nil {
+		pinned = cookie.Value
+		if peer := ServerPool.PeerByID(pinned); peer != nil {
+			return peer
+		}
+	}
+	peer := ServerPool.GetNextPeer()
+	if peer == nil || peer.ID() == pinned {
+		return peer
+	}
+	if pinned != "" {
+		logger.LogDebug("lb: affinity backend unavailable, re-pinning", zap.String("backend", peer.URL.String()))
+	}
+	// a failover after an error re-pins on the same response, drop the previous pin
+	header := w.Header()
+	cookies := header["Set-Cookie"][:0]
+	for _, value := range header["Set-Cookie"] {
+		if !strings.HasPrefix(value, name+"=") {
+			cookies = append(cookies, value)
+		}
+	}
+	header["Set-Cookie"] = cookies
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    peer.ID(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return peer
 }
 
-// HealthCheck runs a routine for check status of the backends every 2 mins
-func HealthCheck() {
-	t := time.NewTicker(time.Minute * 1)
-	for range t.C {
-		ServerPool.HealthCheck()
-		logger.LogInfo("lb: Health check completed")
+// HealthCheck runs a routine for check status of the backends every interval
+// (0 uses 1 min) until the context is done, a round doesn't wait for the
+// probes of the previous one
+func HealthCheck(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			started, skipped := ServerPool.HealthCheck()
+			logger.LogInfo("lb: Health check started", zap.Int("probes", started), zap.Int("skipped", skipped))
+		case <-ctx.Done():
+			return
+		}
 	}
 }
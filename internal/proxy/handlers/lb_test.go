@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func Test_Lbalancer_AffinityCookie(t *testing.T) {
+	var backends []*domain.Backend
+	for i := 0; i < 2; i++ {
+		name := fmt.Sprintf("backend%d", i)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+		defer backend.Close()
+		target, _ := url.Parse(backend.URL)
+		backends = append(backends, &domain.Backend{URL: target, Alive: true, ReverseProxy: httputil.NewSingleHostReverseProxy(target)})
+	}
+	defer func() { ServerPool = domain.ServerPool{} }()
+	ServerPool = domain.ServerPool{AffinityCookie: "ngonx_backend"}
+	for _, backend := range backends {
+		ServerPool.AddBackend(backend)
+	}
+
+	serve := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		Lbalancer(rec, req)
+		return rec
+	}
+
+	// without cookies the requests are balanced and each answer pins its backend
+	first, second := serve(nil), serve(nil)
+	if first.Body.String() == second.Body.String() {
+		t.Errorf("Expected requests without cookie balanced and result are %q and %q", first.Body.String(), second.Body.String())
+	}
+	cookies := first.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "ngonx_backend" || !cookies[0].HttpOnly {
+		t.Fatalf("Expected the affinity cookie and result are %v", cookies)
+	}
+	pin := cookies[0]
+
+	// the pinned client stays on its backend without a new cookie
+	for i := 0; i < 3; i++ {
+		rec := serve(pin)
+		if rec.Body.String() != first.Body.String() {
+			t.Errorf("Expected %q for the pinned client and result are %q", first.Body.String(), rec.Body.String())
+		}
+		if got := rec.Header().Values("Set-Cookie"); len(got) != 0 {
+			t.Errorf("Expected no new cookie for the pinned client and result are %v", got)
+		}
+	}
+
+	// the pinned backend goes down, the client fails over and is re-pinned
+	ServerPool.MarkBackendStatus(backends[0].URL, false)
+	ServerPool.MarkBackendStatus(backends[1].URL, false)
+	for _, backend := range backends {
+		if backend.ID() != pin.Value {
+			backend.SetAlive(true)
+		}
+	}
+	rec := serve(pin)
+	if rec.Body.String() == first.Body.String() {
+		t.Errorf("Expected a failover from the down backend and result are %q", rec.Body.String())
+	}
+	repinned := rec.Result().Cookies()
+	if len(repinned) != 1 || repinned[0].Value == pin.Value {
+		t.Errorf("Expected the client re-pinned and result are %v", repinned)
+	}
+
+	// a stale or forged pin is balanced as usual
+	if rec := serve(&http.Cookie{Name: "ngonx_backend", Value: "unknown"}); rec.Code != http.StatusOK || len(rec.Result().Cookies()) != 1 {
+		t.Errorf("Expected an unknown pin balanced and re-pinned and result are %d %v", rec.Code, rec.Result().Cookies())
+	}
+}
+
+func Test_ServerPool_RemoveBackend(t *testing.T) {
+	pool := &domain.ServerPool{}
+	var backends []*domain.Backend
+	for i := 0; i < 2; i++ {
+		target, _ := url.Parse(fmt.Sprintf("http://backend%d:8080", i))
+		backend := &domain.Backend{URL: target, Alive: true}
+		backends = append(backends, backend)
+		pool.AddBackend(backend)
+	}
+
+	done := backends[0].Begin()
+	removed := make(chan int)
+	go func() {
+		inflight, _ := pool.RemoveBackend(backends[0].URL, time.Minute)
+		removed <- inflight
+	}()
+	for !backends[0].Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	// out of rotation while its request finishes
+	for i := 0; i < 3; i++ {
+		if peer := pool.GetNextPeer(); peer != backends[1] {
+			t.Errorf("Expected %s while draining and result are %v", backends[1].URL, peer.URL)
+		}
+	}
+	if len(pool.Backends()) != 2 {
+		t.Errorf("Expected the draining backend in the pool and result are %d backends", len(pool.Backends()))
+	}
+	done()
+	if inflight := <-removed; inflight != 0 || len(pool.Backends()) != 1 || pool.Backend(backends[0].URL) != nil {
+		t.Errorf("Expected the drained backend removed and result are %d in flight, %d backends", inflight, len(pool.Backends()))
+	}
+	if _, ok := pool.RemoveBackend(backends[0].URL, time.Minute); ok {
+		t.Errorf("Expected the removed backend not found")
+	}
+}
+
+func Test_ServerPool_AddNewBackend(t *testing.T) {
+	pool := &domain.ServerPool{}
+	target, _ := url.Parse("http://backend:8080")
+	added := make(chan bool)
+	for i := 0; i < 8; i++ {
+		go func() {
+			added <- pool.AddNewBackend(&domain.Backend{URL: target, Alive: true})
+		}()
+	}
+	wins := 0
+	for i := 0; i < 8; i++ {
+		if <-added {
+			wins++
+		}
+	}
+	if wins != 1 || len(pool.Backends()) != 1 {
+		t.Errorf("Expected the backend added once and result are %d adds, %d backends", wins, len(pool.Backends()))
+	}
+}
+
+// Test_ServerPool_HealthCheckTransport the probes of an https backend on a
+// private CA go through its transport and Host override
+func Test_ServerPool_HealthCheckTransport(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "api.internal" {
+			w.WriteHeader(http.StatusMisdirectedRequest)
+		}
+	}))
+	defer backend.Close()
+	caFile, _ := writeTestPKI(t, t.TempDir(), backend)
+	transport, err := NewTransport(TransportOptions{TLS: domain.UpstreamTLS{CAFile: caFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := url.Parse(backend.URL)
+
+	// each backend starts in the opposite state, the probe flips it
+	for name, tc := range map[string]struct {
+		backend *domain.Backend
+		alive   bool
+	}{
+		"private ca":   {&domain.Backend{URL: target, Transport: transport, Host: "api.internal"}, true},
+		"system roots": {&domain.Backend{URL: target, Alive: true, Host: "api.internal"}, false},
+		"no host":      {&domain.Backend{URL: target, Alive: true, Transport: transport}, false},
+	} {
+		pool := &domain.ServerPool{FailThreshold: 1, RiseThreshold: 1, HealthCheckPath: "/healthz"}
+		pool.AddBackend(tc.backend)
+		pool.HealthCheck()
+		deadline := time.Now().Add(2 * time.Second)
+		for tc.backend.IsAlive() != tc.alive && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if tc.backend.IsAlive() != tc.alive {
+			t.Errorf("%s: Expected alive %v after the probe and result is %v", name, tc.alive, tc.backend.IsAlive())
+		}
+	}
+}
+
+// Test_ServerPool_HealthCheckSkipped a round doesn't probe again the backend
+// whose previous probe is still running and counts it skipped
+func Test_ServerPool_HealthCheckSkipped(t *testing.T) {
+	release := make(chan struct{})
+	probes := make(chan struct{}, 4)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes <- struct{}{}
+		<-release
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+	skippedTotal := func() float64 {
+		var m dto.Metric
+		if err := otelify.MetricHealthChecksSkipped.WithLabelValues(target.String()).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	pool := &domain.ServerPool{HealthCheckTimeout: 5 * time.Second}
+	pool.AddBackend(&domain.Backend{URL: target, Alive: true})
+	before := skippedTotal()
+	if started, skipped := pool.HealthCheck(); started != 1 || skipped != 0 {
+		t.Fatalf("Expected 1 started 0 skipped and result are %d %d", started, skipped)
+	}
+	<-probes
+	if started, skipped := pool.HealthCheck(); started != 0 || skipped != 1 {
+		t.Errorf("Expected 0 started 1 skipped while the probe runs and result are %d %d", started, skipped)
+	}
+	if got := skippedTotal() - before; got != 1 {
+		t.Errorf("Expected 1 skipped health check counted and result are %g", got)
+	}
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if started, _ := pool.HealthCheck(); started == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected the backend probed again once its probe ended and result is skipped")
+}
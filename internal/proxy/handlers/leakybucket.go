@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+)
+
+// leakyBucket release the requests of a route one every `1/rate` seconds,
+// bursts wait in a bounded queue and requests over it get 429
+func leakyBucket(endpoint string, opts domain.LeakyBucket, next http.Handler) http.Handler {
+	if opts.Rate <= 0 {
+		return next
+	}
+	interval := time.Duration(float64(time.Second) / opts.Rate)
+	rejected := otelify.MetricLeakyBucketRejected.WithLabelValues(endpoint)
+	var (
+		mu      sync.Mutex
+		slot    time.Time
+		waiting int
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		now := time.Now()
+		if slot.Before(now) {
+			slot = now
+		}
+		wait := slot.Sub(now)
+		if wait > 0 && waiting >= opts.Queue {
+			mu.Unlock()
+			rejected.Inc()
+			writeResponseMiddleware(w, http.StatusTooManyRequests, errors.ErrLeakyBucketFull.Error())
+			return
+		}
+		slot = slot.Add(interval)
+		if wait <= 0 {
+			mu.Unlock()
+			next.ServeHTTP(w, req)
+			return
+		}
+		waiting++
+		mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			// the client left, its slot is lost
+			timer.Stop()
+		}
+		mu.Lock()
+		waiting--
+		mu.Unlock()
+		if req.Context().Err() != nil {
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
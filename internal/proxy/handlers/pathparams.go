@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// pathParams bind the named segments of the pattern (`/users/:id/*`) in the
+// client path and forward them as the configured headers. `:name` binds a
+// segment, `*` matches any segment and a trailing `*` the rest of the path.
+// The configured headers sent by the client are always removed so they
+// can`t be spoofed on paths that don`t match
+func pathParams(pattern string, headers map[string]string, next http.Handler) http.Handler {
+	if pattern == "" || len(headers) == 0 {
+		return next
+	}
+	segments := splitPath(pattern)
+	byParam := make(map[string]string, len(headers))
+	for param, header := range headers {
+		byParam[strings.ToLower(param)] = header
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, header := range byParam {
+			req.Header.Del(header)
+		}
+		if params, ok := matchPath(segments, splitPath(req.URL.Path)); ok {
+			for param, value := range params {
+				if header, ok := byParam[param]; ok {
+					req.Header.Set(header, value)
+				}
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// matchPath returns the named params when the path matches the pattern
+func matchPath(pattern, path []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, segment := range pattern {
+		if segment == "*" && i == len(pattern)-1 {
+			return params, true
+		}
+		if i >= len(path) {
+			return nil, false
+		}
+		switch {
+		case segment == "*":
+		case strings.HasPrefix(segment, ":"):
+			params[strings.ToLower(segment[1:])] = path[i]
+		case segment != path[i]:
+			return nil, false
+		}
+	}
+	return params, len(path) == len(pattern)
+}
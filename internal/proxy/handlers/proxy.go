@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -12,9 +11,11 @@ import (
 	"github.com/kenriortega/ngonx/pkg/errors"
 	"github.com/kenriortega/ngonx/pkg/logger"
 	"github.com/kenriortega/ngonx/pkg/otelify"
+	"github.com/kenriortega/ngonx/pkg/reqid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 
 	"github.com/gbrlsnchs/jwt/v3"
 	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
@@ -38,15 +39,135 @@ type ResponseMiddleware struct {
 // ProxyHandler handler for proxy funcionalities
 type ProxyHandler struct {
 	Service services.DefaultProxyService
+	// AllowDuplicateHeaders when it`s false requests with more than one of a
+	// credential header (CredentialHeaders) are rejected with 400
+	AllowDuplicateHeaders bool
+	// SkipAuthPreflight forwards the CORS preflights of the protected routes
+	// without checking the credentials, browsers never send them on a preflight
+	SkipAuthPreflight bool
+	// AllowPlaintextAPIKeys accepts the api keys stored in plaintext by older
+	// versions and replaces them with their hash on the first valid request
+	AllowPlaintextAPIKeys bool
+	// HMAC options for the `hmac` security type
+	HMAC HMACOptions
+	// SlowThreshold requests slower than it are logged and counted, 0 disables
+	SlowThreshold time.Duration
+	// RequestTimeout default timeout of every request, routes can override it, 0 disables it
+	RequestTimeout time.Duration
+	// Via pseudonym of the gateway in the `Via` header, empty disables it
+	Via string
+	// JWT algorithm and public key of the `jwt` security type
+	JWT JWTOptions
+	// StoreFailOpen forwards the requests of the `apikey` and `hmac` security types
+	// when the key store is unavailable, otherwise they get 503
+	StoreFailOpen bool
 }
 
-// SaveSecretKEY handler for save secrets
-func (ph *ProxyHandler) SaveSecretKEY(engine, key, apikey string) {
-	result, err := ph.Service.SaveSecretKEY(engine, key, apikey)
-	if err != nil {
-		logger.LogError(errors.Errorf("proxy: SaveSecretKEY %v", err).Error())
+// CredentialHeaders headers carrying the credentials of the security types,
+// with the configured jwt and nonce headers, the request capture redacts them
+func (ph *ProxyHandler) CredentialHeaders() []string {
+	headers := append([]string{}, credentialHeaders...)
+	if ph.JWT.Header != "" {
+		headers = append(headers, ph.JWT.Header)
 	}
-	logger.LogInfo("proxy: SaveSecretKEY" + result)
+	return append(headers, HeaderSignature, HeaderTimestamp, ph.HMAC.nonceHeader())
+}
+
+// authenticate check the credentials of protected routes before the
+// request is forwarded, failures get 401 and never reach the upstream.
+// An unavailable key store answers 503 unless StoreFailOpen and a valid jwt
+// without any of the audiences of the route gets 403. CORS preflights skip
+// it with SkipAuthPreflight
+func (ph *ProxyHandler) authenticate(
+	ctx context.Context,
+	start time.Time,
+	securityType,
+	engine,
+	key string,
+	verifier jwt.Algorithm,
+	audiences []string,
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ph.SkipAuthPreflight && isPreflight(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		var err error
+		switch securityType {
+		case "jwt":
+			err = checkJWT(ctx, req, verifier, ph.JWT)
+			if err == nil {
+				claims := jwtClaims(req, ph.JWT)
+				if !allowedAudience(claims, audiences) {
+					logger.LogWarn(
+						"proxy: token audience not allowed",
+						zap.String("path", req.URL.Path),
+						zap.Strings("audiences", audiences),
+						zap.String("request_id", reqid.FromContext(req.Context())),
+					)
+					otelRegisterByRequest(ctx, start, req, errors.ErrTokenAudienceForbidden)
+					writeResponseMiddleware(w, http.StatusForbidden, errors.ErrTokenAudienceForbidden.Error())
+					return
+				}
+				req = req.WithContext(context.WithValue(req.Context(), jwtClaimsCtx{}, claims))
+			}
+		case "apikey":
+			err = checkAPIKEY(ctx, req, ph, engine, key)
+		case "hmac":
+			err = checkHMAC(ctx, req, ph, engine, key)
+		}
+		if errors.ErrorIs(err, errors.ErrSecretStoreUnavailable) {
+			mode := "closed"
+			if ph.StoreFailOpen {
+				mode = "open"
+			}
+			otelify.MetricSecretStoreUnavailable.WithLabelValues(securityType, mode).Inc()
+			logger.LogWarn(
+				"proxy: secret store unavailable",
+				zap.String("path", req.URL.Path),
+				zap.String("security", securityType),
+				zap.String("fail_mode", mode),
+				zap.String("request_id", reqid.FromContext(req.Context())),
+				zap.Error(err),
+			)
+			if ph.StoreFailOpen {
+				next.ServeHTTP(w, withAuthenticated(req))
+				return
+			}
+			otelRegisterByRequest(ctx, start, req, err)
+			writeResponseMiddleware(w, http.StatusServiceUnavailable, errors.ErrSecretStoreUnavailable.Error())
+			return
+		}
+		if errors.ErrorIs(err, errors.ErrRequestBodyTooLarge) {
+			otelRegisterByRequest(ctx, start, req, err)
+			// the rest of the body is never read
+			w.Header().Set("Connection", "close")
+			writeResponseMiddleware(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if err != nil {
+			otelRegisterByRequest(ctx, start, req, err)
+			writeResponseMiddleware(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, withAuthenticated(req))
+	})
+}
+
+// authenticatedCtx context key marking the requests that passed authenticate
+type authenticatedCtx struct{}
+
+// withAuthenticated mark the request as carrying credentials, the cache
+// doesn't share responses among them
+func withAuthenticated(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), authenticatedCtx{}, true))
+}
+
+// isAuthenticated reports whether the request passed authenticate
+func isAuthenticated(req *http.Request) bool {
+	authenticated, _ := req.Context().Value(authenticatedCtx{}).(bool)
+	return authenticated
 }
 
 // ProxyGateway handler for management all request
@@ -56,89 +177,321 @@ func (ph *ProxyHandler) ProxyGateway(
 	key,
 	securityType string,
 ) {
+	table := newRouteTable()
+	if err := ph.buildGateway(endpoints, engine, key, securityType, table); err != nil {
+		return
+	}
+	mergeRoutes(table)
+}
+
+// Reload rebuild the routes of every service and replace the live ones at once,
+// the requests in flight finish on the old chains. When a service fails to build
+// nothing is applied and the error is returned
+func (ph *ProxyHandler) Reload(
+	services []domain.ProxyEndpoint,
+	engine,
+	key,
+	securityType string,
+) error {
+	table := newRouteTable()
+	for _, endpoints := range services {
+		if err := ph.buildGateway(endpoints, engine, key, securityType, table); err != nil {
+			otelify.MetricRouteReloads.WithLabelValues("rejected").Inc()
+			return errors.Errorf("%w: service %s: %v", errors.ErrReloadRoutes, endpoints.Name, err)
+		}
+	}
+	swapRoutes(table)
+	otelify.MetricRouteReloads.WithLabelValues("applied").Inc()
+	logger.LogInfo("proxy: routes reloaded", zap.Int("paths", len(table.routers)))
+	return nil
+}
+
+// buildGateway build the handler chains of the routes of a service into the table
+func (ph *ProxyHandler) buildGateway(
+	endpoints domain.ProxyEndpoint,
+	engine,
+	key,
+	securityType string,
+	table *routeTable,
+) error {
 	ctx, span := otel.Tracer("proxy.gateway").Start(context.Background(), "ProxyGateway")
 	defer span.End()
 	traceID := trace.SpanContextFromContext(ctx).TraceID().String()
+
+	transport, err := NewTransport(TransportOptions{
+		OutboundProxy:          endpoints.OutboundProxy,
+		MaxResponseHeaderBytes: endpoints.MaxResponseHeaderBytes,
+		DialTimeout:            endpoints.DialTimeout,
+		TLSHandshakeTimeout:    endpoints.TLSHandshakeTimeout,
+		ResponseTimeout:        endpoints.ResponseTimeout,
+		ServerName:             endpoints.TLSServerName,
+		TLS:                    endpoints.UpstreamTLS,
+		Protocol:               endpoints.UpstreamProtocol,
+		HTTP2:                  endpoints.HTTP2,
+	})
+	if err != nil {
+		otelify.InstrumentedError(span, "proxy.NewTransport", traceID, err)
+		return err
+	}
+	if endpoints.UpstreamTLS.InsecureSkipVerify {
+		logger.LogWarn(
+			"proxy: INSECURE upstream_tls.insecure_skip_verify, the backend certificate is not verified",
+			zap.String("service", endpoints.Name),
+			zap.String("host_uri", endpoints.HostURI),
+		)
+	}
+	upstreamTransport := TraceUpstream(transport)
+	if h2 := endpoints.HTTP2; h2.MaxConcurrentStreams > 0 {
+		conns := h2.MaxConns
+		if conns <= 0 {
+			conns = 1
+		}
+		upstreamTransport = limitStreams(upstreamTransport, h2.MaxConcurrentStreams*conns)
+	}
+	sign, err := newSigner(endpoints.Signing)
+	if err != nil {
+		otelify.InstrumentedError(span, "proxy.newSigner", traceID, err)
+		return err
+	}
+	deprecation, err := newDeprecation(endpoints.Name, endpoints.Deprecation)
+	if err != nil {
+		otelify.InstrumentedError(span, "proxy.newDeprecation", traceID, err)
+		return err
+	}
+	compress, err := newCompression(endpoints.Compression)
+	if err != nil {
+		otelify.InstrumentedError(span, "proxy.newCompression", traceID, err)
+		return err
+	}
+	access, err := newIPAccess(endpoints.AllowCIDRs, endpoints.DenyCIDRs)
+	if err != nil {
+		otelify.InstrumentedError(span, "proxy.newIPAccess", traceID, err)
+		return err
+	}
+	if err := validSizeRoute(endpoints.SizeRoute); err != nil {
+		otelify.InstrumentedError(span, "proxy.validSizeRoute", traceID, err)
+		return err
+	}
+	var verifier jwt.Algorithm
+	if securityType == "jwt" {
+		if verifier, err = newJWTAlgorithm(ph.JWT, key); err != nil {
+			otelify.InstrumentedError(span, "proxy.newJWTAlgorithm", traceID, err)
+			return err
+		}
+	}
+	// signing must be the last step, it covers the final url
+	rewrite := chainDirector(
+		defaultQuery(endpoints.DefaultQuery, endpoints.OverrideQuery),
+		compress.director(),
+		sign,
+	)
 	for _, endpoint := range endpoints.Endpoints {
 		start := time.Now()
 
+		// with RewriteTo the route builds the whole upstream path
+		upstreamPath := endpoint.PathEndpoint
+		if endpoint.RewriteTo != "" {
+			upstreamPath = ""
+		}
 		target, err := url.Parse(
-			fmt.Sprintf("%s%s", endpoints.HostURI, endpoint.PathEndpoint),
+			fmt.Sprintf("%s%s", endpoints.HostURI, upstreamPath),
 		)
 		if err != nil {
 			span.RecordError(err)
 			span.SetStatus(codes.Error, err.Error())
 			logger.LogError(errors.Errorf("proxy: %v", err).Error())
+			return err
+		}
+		if endpoint.PathToProxy == "" {
+			err := errors.Errorf("%w: %s", errors.ErrRoutePath, endpoint.PathEndpoint)
+			otelify.InstrumentedError(span, "proxy.routePath", traceID, err)
+			return err
 		}
 
-		if endpoint.PathProtected {
-			var err error
-			proxy = httputil.NewSingleHostReverseProxy(target)
-
-			originalDirector := proxy.Director
-			proxy.Director = func(req *http.Request) {
-				originalDirector(req)
-				switch securityType {
-				case "jwt":
-					err = checkJWT(ctx, req, key)
-				case "apikey":
-					err = checkAPIKEY(ctx, req, ph, engine, key)
+		modifiers := []func(*http.Response) error{setProxyHeader, observeResponseLatency}
+		if compress != nil && compress.mode == CompressionDecompress {
+			modifiers = append(modifiers, compress.decompress)
+		}
+		// the buffering policy of the route replaces the blanket buffering of the service
+		buffering := newBufferingPolicy(endpoint.Buffering)
+		switch {
+		case buffering != nil:
+			modifiers = append(modifiers, buffering.modify)
+		case endpoints.BufferResponses:
+			modifiers = append(modifiers, BufferResponse)
+		}
+		if ph.Via != "" {
+			modifiers = append(modifiers, viaResponse(ph.Via))
+		}
+		if deprecation != nil {
+			modifiers = append(modifiers, deprecation(endpoint.PathToProxy))
+		}
+		if len(endpoint.StatusRemap) > 0 {
+			modifiers = append(modifiers, remapStatus(endpoint.StatusRemap))
+		}
+		if normalize := endpoint.NormalizeHeaders; normalize.Canonicalize || len(normalize.Merge) > 0 {
+			modifiers = append(modifiers, normalizeHeaders(normalize))
+		}
+		if endpoint.ExpectedContentType != "" {
+			modifiers = append(modifiers, checkContentType(
+				endpoint.PathToProxy,
+				endpoint.ExpectedContentType,
+				endpoint.RejectUnexpectedContentType,
+			))
+		}
+		hostURIs := []string{endpoints.HostURI}
+		for _, hostURI := range endpoints.CookieRoute.Backends {
+			hostURIs = append(hostURIs, hostURI)
+		}
+		if endpoints.SizeRoute.Threshold > 0 {
+			hostURIs = append(hostURIs, endpoints.SizeRoute.HostURI)
+		}
+		urlsEndpoint := endpoint
+		if endpoint.RewriteTo != "" {
+			urlsEndpoint.PathEndpoint = endpoint.RewriteTo
+		}
+		rewriteURLs := newURLRewriter(endpoint.RewriteURLs, urlsEndpoint, hostURIs...)
+		if rewriteURLs != nil {
+			modifiers = append(modifiers, rewriteURLs.modify)
+		}
+		fb := newFallback(endpoint.Fallback)
+		if fb != nil && endpoint.Fallback.LastKnownGood {
+			modifiers = append(modifiers, fb.record)
+		}
+		if err := validUpstreamScheme(endpoint.UpstreamScheme); err != nil {
+			otelify.InstrumentedError(span, "proxy.validUpstreamScheme", traceID, err)
+			return err
+		}
+		if err := validCache(endpoint.Cache); err != nil {
+			otelify.InstrumentedError(span, "proxy.validCache", traceID, err)
+			return err
+		}
+		latency, ok := otelify.LatencyObserver(endpoint.LatencyClass, endpoint.PathToProxy)
+		if !ok {
+			err := errors.Errorf("%w: %q is not in latency_classes", errors.ErrLatencyClass, endpoint.LatencyClass)
+			otelify.InstrumentedError(span, "proxy.LatencyObserver", traceID, err)
+			return err
+		}
+		cache := newResponseCache(endpoint.Cache)
+		if cache != nil {
+			modifiers = append(modifiers, cache.store)
+		}
+		if compress != nil && compress.mode == CompressionCompress {
+			// last so the cache and the other modifiers see the plain body
+			modifiers = append(modifiers, compress.compress)
+		}
+
+		// stripped before the signing, the signature must not cover them
+		routeRewrite := chainDirector(
+			upstreamScheme(endpoint.UpstreamScheme),
+			methodOverride(endpoint.MethodOverride),
+			StripHeaders(endpoint.StripHeaders),
+			rewrite,
+		)
+		routeTransport := retryAfter(endpoint.PathToProxy, endpoint.RetryAfter, upstreamTransport)
+		newProxy := func(target *url.URL) *httputil.ReverseProxy {
+			var rp *httputil.ReverseProxy
+			if endpoint.PathProtected {
+				rp = httputil.NewSingleHostReverseProxy(target)
+				rp.Transport = routeTransport
+
+				originalDirector := rp.Director
+				rp.Director = func(req *http.Request) {
+					originalDirector(req)
+					if routeRewrite != nil {
+						routeRewrite(req)
+					}
+					otelRegisterByRequest(ctx, start, req, nil)
 				}
-				otelRegisterByRequest(ctx, start, req, err)
+			} else {
+				rp = newFastProxy(target, routeTransport, traceID, start, routeRewrite)
 			}
-			proxy.ModifyResponse = func(resp *http.Response) error {
-				resp.Header.Set("X-Proxy", "Ngonx")
-				if err != nil {
-					return err
-				}
-				return nil
+			if endpoint.StreamIdleTimeout > 0 {
+				// streaming routes send every chunk as soon as it arrives
+				rp.FlushInterval = -1
 			}
-			proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
-				rpm := ResponseMiddleware{
-					Message: err.Error(),
-					Code:    http.StatusBadGateway,
-				}
-				w.WriteHeader(rpm.Code)
-				w.Header().Set("Content-Type", "application/json")
-				bytes, err := json.Marshal(&rpm)
-				if err != nil {
-					logger.LogError(err.Error())
-				}
-				_, err = w.Write(bytes)
-				if err != nil {
-					logger.LogError(err.Error())
-				}
+			rp.ModifyResponse = skipUpgrades(chainModifyResponse(modifiers...))
+			rp.ErrorHandler = proxyErrorHandler(endpoint.PathToProxy, fb, cache)
+			return rp
+		}
+		proxy = newProxy(target)
 
+		var upstream http.Handler = proxy
+		if routes := endpoints.SizeRoute; routes.Threshold > 0 {
+			largeTarget, err := url.Parse(routes.HostURI + upstreamPath)
+			if err != nil {
+				otelify.InstrumentedError(span, "proxy.sizeRoute", traceID, err)
+				return errors.Errorf("%w: %v", errors.ErrSizeRoute, err)
 			}
-			http.Handle(
-				endpoint.PathToProxy,
-				http.StripPrefix(
-					endpoint.PathToProxy,
-					proxy,
-				),
-			)
-		} else {
-
-			proxy = httputil.NewSingleHostReverseProxy(target)
-
-			originalDirector := proxy.Director
-			proxy.Director = func(req *http.Request) {
-				originalDirector(req)
-				otelRegisterByRequest(ctx, start, req, nil)
+			upstream = sizeRoute(routes, newProxy(largeTarget), upstream)
+		}
+		// the cookie wins over the size, the requests carrying it never reach the size backends
+		if routes := endpoints.CookieRoute; routes.Name != "" && len(routes.Backends) > 0 {
+			byValue := make(map[string]http.Handler, len(routes.Backends))
+			for value, hostURI := range routes.Backends {
+				cookieTarget, err := url.Parse(hostURI + upstreamPath)
+				if err != nil {
+					logger.LogError(errors.Errorf("proxy: cookie route %v", err).Error())
+					continue
+				}
+				byValue[value] = newProxy(cookieTarget)
 			}
-			proxy.ModifyResponse = func(resp *http.Response) error {
-				resp.Header.Set("X-Proxy", "Ngonx")
-				return nil
+			upstream = cookieRoute(routes.Name, byValue, upstream)
+			if cache != nil {
+				cache.varyCookie = routes.Name
 			}
+		}
 
-			http.Handle(
-				endpoint.PathToProxy,
-				http.StripPrefix(
-					endpoint.PathToProxy,
-					proxy,
-				),
-			)
+		var handler http.Handler = rewritePrefix(
+			endpoint.PathToProxy,
+			endpoint.RewriteTo,
+			upstream,
+		)
+		handler = pathParams(endpoint.PathParams.Pattern, endpoint.PathParams.Headers, handler)
+		if buffering != nil {
+			handler = buffering.handler(handler)
+		}
+		if rewriteURLs != nil {
+			handler = rewriteURLs.handler(handler)
+		}
+		handler = streamIdleTimeout(endpoint.StreamIdleTimeout, endpoint.WebSocket, handler)
+		if cache != nil {
+			handler = cache.handler(handler)
+			path, warmTarget, warmHandler := endpoint.PathToProxy, target, handler
+			table.onLive = append(table.onLive, func() { cache.warm(path, warmTarget, warmHandler) })
+		}
+		if endpoint.PathProtected {
+			handler = ph.authenticate(ctx, start, securityType, engine, key, verifier, endpoints.Audiences, handler)
+			if !ph.AllowDuplicateHeaders {
+				handler = rejectDuplicateCredentials(ph.CredentialHeaders(), handler)
+			}
+		}
+		// streaming routes are bounded by the idle timeout instead of the gateway and service ones
+		timeout := ph.RequestTimeout
+		if endpoints.Timeout > 0 {
+			timeout = endpoints.Timeout
+		}
+		if endpoint.StreamIdleTimeout > 0 {
+			timeout = 0
+		}
+		if endpoint.Timeout > 0 {
+			timeout = endpoint.Timeout
 		}
+		handler = requestTimeout(timeout, endpoint.WebSocket, handler)
+		handler = bodyReadTimeout(endpoint.BodyReadTimeout, endpoint.WebSocket, handler)
+		handler = bulkhead(endpoint.PathToProxy, endpoint.MaxConcurrent, handler)
+		// queued requests must not hold a bulkhead slot
+		handler = leakyBucket(endpoint.PathToProxy, endpoint.LeakyBucket, handler)
+		// abusive clients are rejected before they take a place in the queue
+		handler = rateLimit(endpoint.PathToProxy, endpoints.RateLimit, table, handler)
+		// the clients off the lists don't spend rate limit tokens either
+		handler = access.handler(endpoint.PathToProxy, handler)
+		handler = via(ph.Via, handler)
+		handler = slowRequests(endpoint.PathToProxy, target.String(), ph.SlowThreshold, handler)
+		handler = observeLatency(latency, handler)
+		handler = countRequests(endpoint.PathToProxy, handler)
+		table.handle(endpoint.PathToProxy, endpoint.Query, handler)
 	}
 	otelify.InstrumentedInfo(span, "proxy.Gateway", traceID)
+	return nil
 }
@@ -0,0 +1,657 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	services "github.com/kenriortega/ngonx/internal/proxy/services"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/genkey"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Test_ProxyGateway_ErrorBodyPassthrough upstream error responses must reach
+// the client unchanged on public and protected routes
+func Test_ProxyGateway_ErrorBodyPassthrough(t *testing.T) {
+	const body = `{"error":"validation failed","fields":["name"]}`
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = io.WriteString(w, body)
+	}))
+	defer backend.Close()
+
+	const key = "secret"
+	token, err := jwt.Sign(JWTPayload{Payload: jwt.Payload{
+		ExpirationTime: jwt.NumericDate(time.Now().Add(time.Hour)),
+	}}, jwt.NewHS256([]byte(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ph := &ProxyHandler{Via: "ngonx"}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:         backend.URL,
+		BufferResponses: true,
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/passthrough/public/", StatusRemap: map[int]int{418: 503}},
+			{PathEndpoint: "/", PathToProxy: "/passthrough/protected/", PathProtected: true},
+		},
+	}, "", key, "jwt")
+
+	for _, path := range []string{"/passthrough/public/users", "/passthrough/protected/users"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer "+string(token))
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: Expected status %d and result are %d", path, http.StatusUnprocessableEntity, rec.Code)
+		}
+		if got := rec.Body.String(); got != body {
+			t.Errorf("%s: Expected body %q and result are %q", path, body, got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s: Expected Content-Type application/json and result are %q", path, got)
+		}
+	}
+}
+
+// Test_ProxyGateway_SkipAuthPreflight CORS preflights of a protected route reach
+// the upstream without a token, other requests without it are still rejected
+func Test_ProxyGateway_SkipAuthPreflight(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{SkipAuthPreflight: true}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI: backend.URL,
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/preflight/protected/", PathProtected: true},
+		},
+	}, "", "secret", "jwt")
+
+	tests := []struct {
+		name          string
+		method        string
+		requestMethod string
+		want          int
+	}{
+		{"preflight", http.MethodOptions, http.MethodPost, http.StatusNoContent},
+		{"options without Access-Control-Request-Method", http.MethodOptions, "", http.StatusUnauthorized},
+		{"actual request", http.MethodPost, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/preflight/protected/users", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		if tt.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+		}
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: Expected status %d and result are %d", tt.name, tt.want, rec.Code)
+		}
+	}
+}
+
+// Test_ProxyGateway_HashedAPIKey api keys are validated against the stored
+// hashes of every client and the plaintext ones only during the migration
+func Test_ProxyGateway_HashedAPIKey(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	const apiKey = "28f0116ef42bf718324946f13d787a1d41274a08335d52ee833d5b577f02a32a"
+	tests := []struct {
+		name      string
+		path      string
+		stored    string
+		plaintext bool
+		header    string
+		want      int
+	}{
+		{"hashed key", "/apikey/hashed/", "", false, apiKey, http.StatusOK},
+		{"hashed wrong key", "/apikey/hashed-wrong/", "", false, "wrong", http.StatusUnauthorized},
+		{"plaintext rejected", "/apikey/plaintext/", apiKey, false, apiKey, http.StatusUnauthorized},
+		{"plaintext migration", "/apikey/migration/", apiKey, true, apiKey, http.StatusOK},
+		{"plaintext migration wrong key", "/apikey/migration-wrong/", apiKey, true, "wrong", http.StatusUnauthorized},
+		{"other client key", "/apikey/other/", "", false, "other-client", http.StatusOK},
+	}
+	for _, tt := range tests {
+		stored := tt.stored
+		if stored == "" {
+			stored, _ = StoredSecret("apikey", apiKey)
+		}
+		ph := &ProxyHandler{
+			Service:               services.NewProxyService(domain.NewProxyRepository()),
+			AllowPlaintextAPIKeys: tt.plaintext,
+		}
+		// another client's key is valid as well
+		other, _ := StoredSecret("apikey", "other-client")
+		for _, k := range []string{other, stored} {
+			if err := ph.Service.AddSecretKEY("memory", "key_apikey", k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ph.ProxyGateway(domain.ProxyEndpoint{
+			HostURI: backend.URL,
+			Endpoints: []domain.Endpoint{
+				{PathEndpoint: "/", PathToProxy: tt.path, PathProtected: true},
+			},
+		}, "memory", "key_apikey", "apikey")
+
+		req := httptest.NewRequest(http.MethodGet, tt.path+"users", nil)
+		req.Header.Set("X-API-KEY", tt.header)
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: Expected status %d and result are %d", tt.name, tt.want, rec.Code)
+		}
+		after, _ := ph.Service.GetKEYs("memory", "key_apikey")
+		if tt.plaintext && tt.want == http.StatusOK && (len(after) != 2 || !genkey.CompareAPIKey(after[1], apiKey)) {
+			t.Errorf("%s: Expected the plaintext key replaced with its hash and result are %q", tt.name, after)
+		}
+	}
+}
+
+// Test_ProxyGateway_IPAccess clients off the allow list or in the deny list get 403
+func Test_ProxyGateway_IPAccess(t *testing.T) {
+	upstream := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:    backend.URL,
+		AllowCIDRs: []string{"10.0.0.0/8", "192.168.1.10"},
+		DenyCIDRs:  []string{"10.0.66.0/24"},
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/internal/only/"},
+		},
+	}, "", "", "none")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       int
+	}{
+		{"allowed cidr", "10.1.2.3:4000", "", http.StatusOK},
+		{"allowed ip", "192.168.1.10:4000", "", http.StatusOK},
+		{"denied inside the allowed cidr", "10.0.66.7:4000", "", http.StatusForbidden},
+		{"not allowed", "172.16.0.1:4000", "", http.StatusForbidden},
+		{"forwarded client", "172.16.0.1:4000", "10.9.9.9", http.StatusOK},
+		{"unparseable client", "172.16.0.1:4000", "unknown", http.StatusForbidden},
+	}
+	allowed := 0
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/internal/only/status", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: Expected status %d and result are %d", tt.name, tt.want, rec.Code)
+		}
+		if tt.want == http.StatusOK {
+			allowed++
+		}
+	}
+	if upstream != allowed {
+		t.Errorf("Expected %d requests on the upstream and result are %d", allowed, upstream)
+	}
+}
+
+// Test_ProxyGateway_SizeRoute large and chunked requests reach the heavy backend
+func Test_ProxyGateway_SizeRoute(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			_, _ = io.WriteString(w, name)
+		}))
+	}
+	light, heavy := backend("light"), backend("heavy")
+	defer light.Close()
+	defer heavy.Close()
+
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:   light.URL,
+		SizeRoute: domain.SizeRoute{Threshold: 1024, HostURI: heavy.URL, Chunked: SizeRouteLarge},
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/size/route/"},
+		},
+	}, "", "", "none")
+
+	tests := []struct {
+		name string
+		size int64
+		want string
+	}{
+		{"no body", 0, "light"},
+		{"small", 10, "light"},
+		{"threshold", 1024, "light"},
+		{"large", 2048, "heavy"},
+		{"chunked", -1, "heavy"},
+	}
+	for _, tt := range tests {
+		length := tt.size
+		if length < 0 {
+			length = 10
+		}
+		req := httptest.NewRequest(http.MethodPost, "/size/route/upload", strings.NewReader(strings.Repeat("x", int(length))))
+		req.ContentLength = tt.size
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("%s: Expected backend %s and result are %s (%d)", tt.name, tt.want, got, rec.Code)
+		}
+	}
+}
+
+// Test_ProxyGateway_CacheAuthenticated authenticated requests bypass the cache
+// unless the route opts in, then the entries are per tenant claim
+func Test_ProxyGateway_CacheAuthenticated(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = io.WriteString(w, r.Header.Get("X-Tenant-ID"))
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI: backend.URL,
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/cache/private/", PathProtected: true, Cache: domain.Cache{TTL: time.Minute}},
+			{PathEndpoint: "/", PathToProxy: "/cache/tenant/", PathProtected: true, Cache: domain.Cache{
+				TTL: time.Minute, KeyBy: []string{"claim:tenant_id"}, Authenticated: true,
+			}},
+		},
+	}, "", "secret", "jwt")
+	// a header chosen by the client can't isolate the tenants, the service fails
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI: backend.URL,
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/cache/header/", PathProtected: true, Cache: domain.Cache{
+				TTL: time.Minute, KeyBy: []string{"header:X-Tenant-ID"}, Authenticated: true,
+			}},
+		},
+	}, "", "secret", "jwt")
+
+	get := func(path, tenant string) *httptest.ResponseRecorder {
+		token, err := jwt.Sign(struct {
+			jwt.Payload
+			TenantID string `json:"tenant_id"`
+		}{jwt.Payload{ExpirationTime: jwt.NumericDate(time.Now().Add(time.Hour))}, tenant}, jwt.NewHS256([]byte("secret")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+string(token))
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := get("/cache/private/me", "a"); rec.Header().Get("X-Ngonx-Cache") != "BYPASS" {
+			t.Errorf("Expected X-Ngonx-Cache BYPASS and result are %q", rec.Header().Get("X-Ngonx-Cache"))
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 requests on the upstream and result are %d", calls)
+	}
+
+	calls = 0
+	get("/cache/tenant/me", "a")
+	if rec := get("/cache/tenant/me", "a"); rec.Header().Get("X-Ngonx-Cache") != "HIT" || rec.Body.String() != "a" {
+		t.Errorf("Expected a cache HIT of tenant a and result are %q %q", rec.Header().Get("X-Ngonx-Cache"), rec.Body.String())
+	}
+	if rec := get("/cache/tenant/me", "b"); rec.Body.String() != "b" {
+		t.Errorf("Expected the response of tenant b and result are %q", rec.Body.String())
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 requests on the upstream and result are %d", calls)
+	}
+	if rec := get("/cache/header/me", "a"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d of the route keyed by a header and result are %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+// Test_responseCache_Vary the responses varying on a header outside the key
+// aren't cached
+func Test_responseCache_Vary(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		keyBy  []string
+		cookie string
+		want   bool
+	}{
+		{"no vary", http.Header{}, nil, "", true},
+		{"identity body", http.Header{"Vary": {"Accept-Encoding"}}, nil, "", true},
+		{"encoded body", http.Header{"Vary": {"Accept-Encoding"}, "Content-Encoding": {"br"}}, nil, "", false},
+		{"any", http.Header{"Vary": {"*"}}, nil, "", false},
+		{"header outside the key", http.Header{"Vary": {"Accept-Language"}}, nil, "", false},
+		{"key_by header", http.Header{"Vary": {"accept-language, Accept-Encoding"}}, []string{"header:Accept-Language"}, "", true},
+		{"cookie route", http.Header{"Vary": {"Cookie"}}, nil, "canary", true},
+		{"cookie outside the key", http.Header{"Vary": {"Cookie"}}, nil, "", false},
+	}
+	for _, tt := range tests {
+		cache := newResponseCache(domain.Cache{TTL: time.Minute, KeyBy: tt.keyBy})
+		cache.varyCookie = tt.cookie
+		req := httptest.NewRequest(http.MethodGet, "/vary", nil)
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     tt.header,
+			Body:       io.NopCloser(strings.NewReader("body")),
+			Request:    req.WithContext(context.WithValue(req.Context(), cacheKeyCtx{}, "/vary")),
+		}
+		if err := cache.store(resp); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := len(cache.entries) == 1; got != tt.want {
+			t.Errorf("%s: Expected cached %v and result are %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// Test_ProxyGateway_UpstreamScheme the scheme of the route wins over the one of host_uri
+func Test_ProxyGateway_UpstreamScheme(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		// the plain http backend configured as https
+		HostURI: strings.Replace(backend.URL, "http://", "https://", 1),
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/scheme/forced/", UpstreamScheme: "http"},
+			{PathEndpoint: "/", PathToProxy: "/scheme/target/"},
+		},
+	}, "", "", "none")
+
+	for path, want := range map[string]int{
+		"/scheme/forced/status": http.StatusOK,
+		"/scheme/target/status": http.StatusBadGateway,
+	} {
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: Expected status %d and result are %d", path, want, rec.Code)
+		}
+	}
+}
+
+func Test_ProxyGateway_LatencyClass(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	if err := otelify.ConfigureLatencyClasses(map[string][]float64{"Fast": {0.001}}); !errors.ErrorIs(err, errors.ErrLatencyClass) {
+		t.Errorf("Expected %v and result are %v", errors.ErrLatencyClass, err)
+	}
+	// an invalid class leaves the valid ones of the same config unregistered
+	if err := otelify.ConfigureLatencyClasses(map[string][]float64{"partial": {0.1}, "bad": {}}); !errors.ErrorIs(err, errors.ErrLatencyClass) {
+		t.Errorf("Expected %v and result are %v", errors.ErrLatencyClass, err)
+	}
+	if _, ok := otelify.LatencyObserver("partial", "/latency/partial/"); ok {
+		t.Error("Expected the partial class not registered and result is registered")
+	}
+	if err := otelify.ConfigureLatencyClasses(map[string][]float64{"fast": {0.01, 0.001, 0.0001}}); err != nil {
+		t.Fatalf("Expected no error and result are %v", err)
+	}
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:   backend.URL,
+		Endpoints: []domain.Endpoint{{PathEndpoint: "/", PathToProxy: "/latency/fast/", LatencyClass: "fast"}},
+	}, "", "", "none")
+	// an unknown class fails the service
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:   backend.URL,
+		Endpoints: []domain.Endpoint{{PathEndpoint: "/", PathToProxy: "/latency/unknown/", LatencyClass: "unknown"}},
+	}, "", "", "none")
+
+	for path, want := range map[string]int{
+		"/latency/fast/":    http.StatusOK,
+		"/latency/unknown/": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: Expected status %d and result are %d", path, want, rec.Code)
+		}
+	}
+
+	histogram := gatherHistogram(t, "ngonx_request_latency_fast_seconds", map[string]string{"endpoint": "/latency/fast/"})
+	if histogram == nil || histogram.GetSampleCount() != 1 || len(histogram.GetBucket()) != 3 {
+		t.Errorf("Expected one sample in the 3 buckets of the fast class and result are %v", histogram)
+	}
+}
+
+func Test_ProxyGateway_RetryAfter(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1)%2 == 1 {
+			w.Header().Set("Retry-After", r.URL.Query().Get("wait"))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI: backend.URL,
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/retryafter/", RetryAfter: domain.RetryAfter{MaxWait: time.Second}},
+			{PathEndpoint: "/", PathToProxy: "/retryafter/deadline/", RetryAfter: domain.RetryAfter{MaxWait: time.Second}, Timeout: 500 * time.Millisecond},
+		},
+	}, "", "", "none")
+
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/retryafter/a?wait=0", http.StatusOK},
+		// over max_wait and past the deadline the 429 is sent as is
+		{http.MethodGet, "/retryafter/a?wait=5", http.StatusTooManyRequests},
+		{http.MethodGet, "/retryafter/deadline/a?wait=1", http.StatusTooManyRequests},
+		// not idempotent
+		{http.MethodPost, "/retryafter/a?wait=0", http.StatusTooManyRequests},
+	} {
+		atomic.StoreInt32(&calls, 0)
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: Expected status %d and result are %d", tt.method, tt.path, tt.want, rec.Code)
+		}
+	}
+	if wait, ok := parseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), time.Now()); !ok || wait != 0 {
+		t.Errorf("Expected no wait for a past date and result are %s %v", wait, ok)
+	}
+}
+
+// gatherHistogram returns the histogram of the default registry with the labels, nil when there is none
+func gatherHistogram(t *testing.T, name string, labels map[string]string) *dto.Histogram {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matched := 0
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] == label.GetValue() {
+					matched++
+				}
+			}
+			if matched == len(labels) {
+				return metric.GetHistogram()
+			}
+		}
+	}
+	return nil
+}
+
+func Test_ProxyGateway_BodySize(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:   backend.URL,
+		Endpoints: []domain.Endpoint{{PathEndpoint: "/", PathToProxy: "/bodysize/"}},
+	}, "", "", "none")
+
+	rec := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/bodysize/upload", strings.NewReader(strings.Repeat("y", 300))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d and result are %d", http.StatusOK, rec.Code)
+	}
+	for direction, want := range map[string]float64{"in": 300, "out": 1000} {
+		histogram := gatherHistogram(t, "ngonx_body_size_bytes", map[string]string{"endpoint": "/bodysize/", "direction": direction})
+		if histogram == nil || histogram.GetSampleCount() != 1 || histogram.GetSampleSum() != want {
+			t.Errorf("%s: Expected one body of %g bytes and result are %v", direction, want, histogram)
+		}
+	}
+}
+
+func Test_NewTransport_UpstreamTLS(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	backend.StartTLS()
+	defer backend.Close()
+
+	dir := t.TempDir()
+	caFile, keyFile := writeTestPKI(t, dir, backend)
+
+	for name, tc := range map[string]struct {
+		opts domain.UpstreamTLS
+		want int
+	}{
+		"system roots": {domain.UpstreamTLS{}, 0},
+		"ca file":      {domain.UpstreamTLS{CAFile: caFile}, http.StatusUnauthorized},
+		"mtls":         {domain.UpstreamTLS{CAFile: caFile, CertFile: caFile, KeyFile: keyFile}, http.StatusNoContent},
+		"insecure":     {domain.UpstreamTLS{InsecureSkipVerify: true}, http.StatusUnauthorized},
+	} {
+		transport, err := NewTransport(TransportOptions{TLS: tc.opts})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		status := 0
+		if resp, err := (&http.Client{Transport: transport}).Get(backend.URL); err == nil {
+			status = resp.StatusCode
+			resp.Body.Close()
+		}
+		if status != tc.want {
+			t.Errorf("%s: Expected status %d and result are %d", name, tc.want, status)
+		}
+	}
+
+	notPEM := filepath.Join(dir, "not.pem")
+	_ = os.WriteFile(notPEM, []byte("not a certificate"), 0600)
+	for name, opts := range map[string]domain.UpstreamTLS{
+		"missing ca": {CAFile: filepath.Join(dir, "missing.pem")},
+		"not pem":    {CAFile: notPEM},
+		"no key":     {CertFile: caFile},
+	} {
+		if _, err := NewTransport(TransportOptions{TLS: opts}); !errors.ErrorIs(err, errors.ErrUpstreamTLS) {
+			t.Errorf("%s: Expected %v and result are %v", name, errors.ErrUpstreamTLS, err)
+		}
+	}
+}
+
+// writeTestPKI write the certificate of the tls backend as the CA bundle of its
+// internal PKI, it doubles as the client certificate with the key file
+func writeTestPKI(t *testing.T, dir string, backend *httptest.Server) (caFile, keyFile string) {
+	cert := backend.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile, keyFile = filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.key")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+	return caFile, keyFile
+}
+
+// Test_ProxyGateway_DuplicateJWTHeader the configured jwt header is checked
+// for duplicates like Authorization
+func Test_ProxyGateway_DuplicateJWTHeader(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{JWT: JWTOptions{Header: "X-Access-Token"}}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:   backend.URL,
+		Endpoints: []domain.Endpoint{{PathEndpoint: "/", PathToProxy: "/duplicate/jwt/", PathProtected: true}},
+	}, "", "secret", "jwt")
+	token, err := jwt.Sign(JWTPayload{Payload: jwt.Payload{
+		ExpirationTime: jwt.NumericDate(time.Now().Add(time.Hour)),
+	}}, jwt.NewHS256([]byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		tokens []string
+		want   int
+	}{
+		"one token":  {[]string{string(token)}, http.StatusOK},
+		"two tokens": {[]string{string(token), "other"}, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/duplicate/jwt/me", nil)
+		req.Header["X-Access-Token"] = tc.tokens
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: Expected status %d and result are %d", name, tc.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// defaultQuery returns the Director step that merges the default query params
+// of the service into the outgoing url, client values are kept unless override
+func defaultQuery(params map[string]string, override bool) func(*http.Request) {
+	if len(params) == 0 {
+		return nil
+	}
+	return func(req *http.Request) {
+		query := req.URL.Query()
+		for k, v := range params {
+			if _, ok := query[k]; ok && !override {
+				continue
+			}
+			query.Set(k, v)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+}
+
+// chainDirector run the Director steps in order, nil steps are skipped
+func chainDirector(steps ...func(*http.Request)) func(*http.Request) {
+	var rewrites []func(*http.Request)
+	for _, step := range steps {
+		if step != nil {
+			rewrites = append(rewrites, step)
+		}
+	}
+	switch len(rewrites) {
+	case 0:
+		return nil
+	case 1:
+		return rewrites[0]
+	}
+	return func(req *http.Request) {
+		for _, rewrite := range rewrites {
+			rewrite(req)
+		}
+	}
+}
+
+// methodOverride returns the Director step that rewrites the method of POST
+// requests with `X-HTTP-Method-Override` when it's in the allowlist, the header
+// is always removed so the upstream can't be reached with other methods
+func methodOverride(allowed []string) func(*http.Request) {
+	if len(allowed) == 0 {
+		return nil
+	}
+	methods := make(map[string]bool, len(allowed))
+	for _, m := range allowed {
+		methods[strings.ToUpper(m)] = true
+	}
+	return func(req *http.Request) {
+		override := strings.ToUpper(strings.TrimSpace(req.Header.Get("X-HTTP-Method-Override")))
+		req.Header.Del("X-HTTP-Method-Override")
+		if req.Method == http.MethodPost && methods[override] {
+			req.Method = override
+		}
+	}
+}
+
+// upstreamScheme forces the scheme toward the backend whatever the one of
+// host_uri, e.g. https to backends behind a tls frontend configured by ip.
+// Empty keeps the scheme of the target
+func upstreamScheme(scheme string) func(*http.Request) {
+	if scheme == "" {
+		return nil
+	}
+	return func(req *http.Request) {
+		req.URL.Scheme = scheme
+	}
+}
+
+// validUpstreamScheme check the scheme override of a route, empty|http|https
+func validUpstreamScheme(scheme string) error {
+	switch scheme {
+	case "", "http", "https":
+		return nil
+	}
+	return errors.Errorf("%w: %q", errors.ErrUpstreamScheme, scheme)
+}
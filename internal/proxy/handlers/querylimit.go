@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"go.uber.org/zap"
+)
+
+// QueryLimit answers 400 to the requests whose raw query string is longer than
+// maxLength bytes or carries more than maxParams parameters (repeated names
+// count every time), so abusive query strings never reach the backends.
+// A limit <= 0 disables its check
+func QueryLimit(maxLength, maxParams int, next http.Handler) http.Handler {
+	if maxLength <= 0 && maxParams <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.RawQuery
+		reason := ""
+		switch {
+		case maxLength > 0 && len(query) > maxLength:
+			reason = "length"
+		case maxParams > 0 && countParams(query) > maxParams:
+			reason = "params"
+		}
+		if reason != "" {
+			otelify.MetricQueryRejected.WithLabelValues(reason).Inc()
+			logger.LogWarn(
+				"proxy: query limit exceeded",
+				zap.String("reason", reason),
+				zap.String("client", extractIpAddr(req)),
+				zap.String("path", req.URL.Path),
+				zap.Int("query_length", len(query)),
+			)
+			writeResponseMiddleware(w, http.StatusBadRequest, errors.ErrQueryLimit.Error())
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// countParams number of non empty `&` separated pairs of a raw query, without
+// decoding them
+func countParams(query string) int {
+	if query == "" {
+		return 0
+	}
+	count := 0
+	for _, pair := range strings.Split(query, "&") {
+		if pair != "" {
+			count++
+		}
+	}
+	return count
+}
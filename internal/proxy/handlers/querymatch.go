@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+)
+
+// routeTable routers of every path registered in the mux, routes of several
+// services can share a path and be split by the query string. The live table
+// is replaced as a whole on reload, requests in flight finish on the old one
+type routeTable struct {
+	routers map[string]*queryRouter
+	// onLive started once the table serves the traffic e.g. the cache warm up
+	onLive []func()
+	// limiters rate limiters of the routes, see rateLimit
+	limiters []*rateLimiter
+}
+
+var (
+	// routesMu serializes the changes of the live table and the mux
+	routesMu   sync.Mutex
+	liveRoutes atomic.Value
+	// muxPaths paths registered in the mux, they can`t be removed from it
+	muxPaths = make(map[string]bool)
+)
+
+func newRouteTable() *routeTable {
+	return &routeTable{routers: make(map[string]*queryRouter)}
+}
+
+// queryRoute route selected when every param of match is in the query
+type queryRoute struct {
+	match   map[string]string
+	handler http.Handler
+}
+
+// queryRouter select the route of a path by the query parameters, the routes
+// with params are evaluated in the config order and the first one whose params
+// all match wins, otherwise the route without params answers (404 without it)
+type queryRouter struct {
+	mu       sync.RWMutex
+	routes   []queryRoute
+	fallback http.Handler
+}
+
+// handle add the handler of a route to the table, routes on the
+// same path are dispatched by their query params
+func (t *routeTable) handle(path string, query map[string]string, handler http.Handler) {
+	router, ok := t.routers[path]
+	if !ok {
+		router = &queryRouter{}
+		t.routers[path] = router
+	}
+	router.add(query, handler)
+}
+
+func (r *queryRouter) add(query map[string]string, handler http.Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(query) == 0 {
+		r.fallback = handler
+		return
+	}
+	match := make(map[string]string, len(query))
+	for name, value := range query {
+		match[strings.ToLower(name)] = value
+	}
+	r.routes = append(r.routes, queryRoute{match: match, handler: handler})
+}
+
+// limiter returns the rate limiter of the route with the same options, nil when there is none
+func (t *routeTable) limiter(endpoint string, opts domain.RateLimit) *rateLimiter {
+	for _, l := range t.limiters {
+		if l.endpoint == endpoint && l.opts == opts {
+			return l
+		}
+	}
+	return nil
+}
+
+// currentRoutes returns the live table
+func currentRoutes() *routeTable {
+	table, _ := liveRoutes.Load().(*routeTable)
+	if table == nil {
+		return newRouteTable()
+	}
+	return table
+}
+
+// mergeRoutes add the routes of a service to the live table,
+// the routers of the other paths are shared with the new table
+func mergeRoutes(t *routeTable) {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	live := currentRoutes()
+	next := newRouteTable()
+	for path, router := range live.routers {
+		next.routers[path] = router
+	}
+	next.limiters = append(append(next.limiters, live.limiters...), t.limiters...)
+	for path, router := range t.routers {
+		current, ok := next.routers[path]
+		if !ok {
+			next.routers[path] = router
+			continue
+		}
+		// the routers of the live table are only mutated during the startup
+		for _, route := range router.routes {
+			current.mu.Lock()
+			current.routes = append(current.routes, route)
+			current.mu.Unlock()
+		}
+		if router.fallback != nil {
+			current.add(nil, router.fallback)
+		}
+	}
+	publishRoutes(next, t.onLive)
+}
+
+// swapRoutes replace the live table, the paths missing in it answer 404
+func swapRoutes(t *routeTable) {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	publishRoutes(t, t.onLive)
+}
+
+// publishRoutes register the new paths in the mux and store the table, the
+// limiters left out of it are stopped. It must be called with routesMu held
+func publishRoutes(t *routeTable, onLive []func()) {
+	for path := range t.routers {
+		if !muxPaths[path] {
+			muxPaths[path] = true
+			http.Handle(path, pathRouter(path))
+		}
+	}
+	previous := currentRoutes()
+	liveRoutes.Store(t)
+	kept := make(map[*rateLimiter]bool, len(t.limiters))
+	for _, l := range t.limiters {
+		kept[l] = true
+		l.start()
+	}
+	for _, l := range previous.limiters {
+		if !kept[l] {
+			l.close()
+		}
+	}
+	for _, fn := range onLive {
+		go fn()
+	}
+}
+
+// pathRouter dispatch the requests of a path with the router of the live table
+func pathRouter(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router, ok := currentRoutes().routers[path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		router.ServeHTTP(w, req)
+	})
+}
+
+func (r *queryRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	handler := r.fallback
+	if len(r.routes) > 0 {
+		query := make(map[string][]string)
+		for name, values := range req.URL.Query() {
+			name = strings.ToLower(name)
+			query[name] = append(query[name], values...)
+		}
+		for _, route := range r.routes {
+			if matchQuery(route.match, query) {
+				handler = route.handler
+				break
+			}
+		}
+	}
+	r.mu.RUnlock()
+	if handler == nil {
+		http.NotFound(w, req)
+		return
+	}
+	handler.ServeHTTP(w, req)
+}
+
+// matchQuery returns true when every param has the expected value,
+// `*` only requires the param to be present
+func matchQuery(match map[string]string, query map[string][]string) bool {
+	for name, expected := range match {
+		values, ok := query[name]
+		if !ok {
+			return false
+		}
+		if expected == "*" {
+			continue
+		}
+		found := false
+		for _, v := range values {
+			if v == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
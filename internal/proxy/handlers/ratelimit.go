@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"go.uber.org/zap"
+)
+
+// defaultRateLimitIdleTTL idle time before the bucket of a client is dropped
+const defaultRateLimitIdleTTL = 10 * time.Minute
+
+// tokenBucket tokens of a client, refilled at the rate of the limiter
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter token buckets by client ip of a route, buckets idle
+// for the ttl are garbage collected once the table of the route is live
+type rateLimiter struct {
+	endpoint string
+	opts     domain.RateLimit
+	stop     chan struct{}
+	started  sync.Once
+	stopped  sync.Once
+	rate     float64
+	burst    float64
+	ttl      time.Duration
+	mu       sync.Mutex
+	clients  map[string]*tokenBucket
+}
+
+func newRateLimiter(endpoint string, opts domain.RateLimit) *rateLimiter {
+	burst := float64(opts.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(opts.RPS))
+	}
+	ttl := opts.IdleTTL
+	if ttl <= 0 {
+		ttl = defaultRateLimitIdleTTL
+	}
+	return &rateLimiter{
+		endpoint: endpoint,
+		opts:     opts,
+		stop:     make(chan struct{}),
+		rate:     opts.RPS,
+		burst:    burst,
+		ttl:      ttl,
+		clients:  make(map[string]*tokenBucket),
+	}
+}
+
+// start the gc of the buckets, the limiters of a rejected reload never start
+func (l *rateLimiter) start() {
+	l.started.Do(func() { go l.gc() })
+}
+
+// close stop the gc once the limiter left the live table
+func (l *rateLimiter) close() {
+	l.stopped.Do(func() { close(l.stop) })
+}
+
+// allow takes a token of the client, otherwise it returns the wait for the next one
+func (l *rateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.clients[ip]
+	if !ok {
+		if len(l.clients) >= maxTrackedIPs {
+			return false, time.Second
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// gc drop the buckets of the clients idle for the ttl
+func (l *rateLimiter) gc() {
+	ticker := time.NewTicker(l.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for ip, b := range l.clients {
+				if now.Sub(b.last) >= l.ttl {
+					delete(l.clients, ip)
+				}
+			}
+			l.mu.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// rateLimit limits the requests per second of each client ip on the route,
+// requests over it get 429 with `Retry-After`. The limiters belong to the route
+// table and survive a reload unless the options of the route changed, the live
+// ones are only replaced once the new table is published. A rps <= 0 disables it
+func rateLimit(endpoint string, opts domain.RateLimit, table *routeTable, next http.Handler) http.Handler {
+	if opts.RPS <= 0 {
+		return next
+	}
+	l := currentRoutes().limiter(endpoint, opts)
+	if l == nil {
+		l = newRateLimiter(endpoint, opts)
+	}
+	table.limiters = append(table.limiters, l)
+	rejected := otelify.MetricRateLimited.WithLabelValues(endpoint)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip := extractIpAddr(req)
+		ok, wait := l.allow(ip, time.Now())
+		if !ok {
+			rejected.Inc()
+			logger.LogWarn(
+				"proxy: rate limit",
+				zap.String("client", ip),
+				zap.String("route", endpoint),
+				zap.String("path", req.URL.Path),
+			)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeResponseMiddleware(w, http.StatusTooManyRequests, errors.ErrRateLimited.Error())
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+)
+
+// isStopped reports whether the gc of the limiter was stopped
+func (l *rateLimiter) isStopped() bool {
+	select {
+	case <-l.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Test_Reload_RateLimiters a rejected reload keeps the live limiters
+// running, an applied one stops the replaced limiters
+func Test_Reload_RateLimiters(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	service := func(rps float64) domain.ProxyEndpoint {
+		return domain.ProxyEndpoint{
+			Name:      "limited",
+			HostURI:   backend.URL,
+			RateLimit: domain.RateLimit{RPS: rps},
+			Endpoints: []domain.Endpoint{{PathEndpoint: "/", PathToProxy: "/ratelimit/reload/"}},
+		}
+	}
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(service(5), "", "", "none")
+	live := currentRoutes().limiter("/ratelimit/reload/", domain.RateLimit{RPS: 5})
+	if live == nil {
+		t.Fatal("Expected the live limiter of the route")
+	}
+
+	broken := domain.ProxyEndpoint{Name: "broken", HostURI: backend.URL, UpstreamProtocol: "bogus"}
+	if err := ph.Reload([]domain.ProxyEndpoint{service(10), broken}, "", "", "none"); err == nil {
+		t.Fatal("Expected the reload rejected")
+	}
+	if live.isStopped() || currentRoutes().limiter("/ratelimit/reload/", domain.RateLimit{RPS: 5}) != live {
+		t.Errorf("Expected the live limiter running after a rejected reload")
+	}
+
+	if err := ph.Reload([]domain.ProxyEndpoint{service(10)}, "", "", "none"); err != nil {
+		t.Fatal(err)
+	}
+	if !live.isStopped() {
+		t.Errorf("Expected the replaced limiter stopped")
+	}
+	next := currentRoutes().limiter("/ratelimit/reload/", domain.RateLimit{RPS: 10})
+	if next == nil || next.isStopped() {
+		t.Errorf("Expected the new limiter live and result are %v", next)
+	}
+
+	// the limiters of the removed routes are stopped too
+	if err := ph.Reload(nil, "", "", "none"); err != nil {
+		t.Fatal(err)
+	}
+	if next != nil && !next.isStopped() {
+		t.Errorf("Expected the limiter of the removed route stopped")
+	}
+}
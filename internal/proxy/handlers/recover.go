@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"github.com/kenriortega/ngonx/pkg/reqid"
+	"go.uber.org/zap"
+)
+
+// Recover catches the panics of the handler chain (middlewares, `Director`,
+// `ModifyResponse`), logs them with the request and the stack and answers 500
+// instead of taking down the process. `http.ErrAbortHandler` is re-panicked,
+// it's the way the reverse proxy aborts a response already sent
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			otelify.MetricPanics.Inc()
+			logger.LogError(
+				errors.Errorf("proxy: %w: %v", errors.ErrPanic, rec).Error(),
+				zap.String("method", req.Method),
+				zap.String("path", req.URL.Path),
+				zap.String("client", extractIpAddr(req)),
+				zap.String("request_id", reqid.FromContext(req.Context())),
+				zap.String("stack", string(debug.Stack())),
+			)
+			writeResponseMiddleware(w, http.StatusInternalServerError, errors.ErrPanic.Error())
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/kenriortega/ngonx/pkg/reqid"
+)
+
+// maxRequestIDLength ids sent by the clients longer than it are replaced
+const maxRequestIDLength = 128
+
+// RequestID forward the request id in the header to the upstream, echo it in
+// the response and carry it in the context for the logs. The id sent by the
+// client is kept, otherwise one is generated. A nil generate only propagates the
+// ids minted upstream, the requests without one go on without it. An empty
+// header disables it
+func RequestID(header string, generate func() string, next http.Handler) http.Handler {
+	if header == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(header)
+		if id == "" || len(id) > maxRequestIDLength {
+			if generate == nil {
+				req.Header.Del(header)
+				next.ServeHTTP(w, req)
+				return
+			}
+			id = generate()
+			req.Header.Set(header, id)
+		}
+		w.Header().Set(header, id)
+		next.ServeHTTP(w, req.WithContext(reqid.NewContext(req.Context(), id)))
+	})
+}
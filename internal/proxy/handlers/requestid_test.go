@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kenriortega/ngonx/pkg/reqid"
+)
+
+// Test_RequestID the ids are kept, generated or, propagating only, left out
+func Test_RequestID(t *testing.T) {
+	generate := func() string { return "generated" }
+	tests := []struct {
+		name     string
+		generate func() string
+		sent     string
+		want     string
+	}{
+		{"client id", generate, "client", "client"},
+		{"generated", generate, "", "generated"},
+		{"propagate only", nil, "upstream", "upstream"},
+		{"propagate only without id", nil, "", ""},
+	}
+	for _, tt := range tests {
+		var forwarded, logged string
+		handler := RequestID("X-Request-ID", tt.generate, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded, logged = r.Header.Get("X-Request-ID"), reqid.FromContext(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.sent != "" {
+			req.Header.Set("X-Request-ID", tt.sent)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if forwarded != tt.want || logged != tt.want || rec.Header().Get("X-Request-ID") != tt.want {
+			t.Errorf("%s: Expected the id %q and result are %q %q %q", tt.name, tt.want, forwarded, logged, rec.Header().Get("X-Request-ID"))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/kenriortega/ngonx/pkg/otelify"
+)
+
+// countRequests counts in `MetricRequests` the requests of the route by method
+// and status code, the status is the one sent to the client so the upstream
+// codes and the gateway errors (401, 429, 502, 504...) are both counted.
+// `MetricBodySize` gets the request body bytes read (in) and the response body
+// bytes written (out), upgraded connections aren`t measured
+func countRequests(endpoint string, next http.Handler) http.Handler {
+	in := otelify.MetricBodySize.WithLabelValues(endpoint, "in")
+	out := otelify.MetricBodySize.WithLabelValues(endpoint, "out")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		body := &countingBody{}
+		if req.Body != nil && req.Body != http.NoBody {
+			body.ReadCloser = req.Body
+			req.Body = body
+		}
+		defer func() {
+			status := sw.status
+			if status == 0 {
+				// nothing written, net/http answers 200
+				status = http.StatusOK
+			}
+			otelify.MetricRequests.WithLabelValues(endpoint, metricMethod(req.Method), strconv.Itoa(status)).Inc()
+			if status != http.StatusSwitchingProtocols {
+				in.Observe(float64(atomic.LoadInt64(&body.n)))
+				out.Observe(float64(sw.bytes))
+			}
+		}()
+		next.ServeHTTP(sw, req)
+	})
+}
+
+// countingBody counts the bytes read of the request body, the transport may
+// read it from another goroutine
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.n, int64(n))
+	return n, err
+}
+
+// metricMethod bounds the method label, clients can send any token
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// statusWriter keeps the status and the body bytes of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the writer
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps upgraded connections (websockets) working through the writer
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
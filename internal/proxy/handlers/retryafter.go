@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"github.com/kenriortega/ngonx/pkg/reqid"
+	"go.uber.org/zap"
+)
+
+// maxDrainRetried bytes of a retried response read so its connection is reused
+const maxDrainRetried = 4 << 10
+
+// retryAfter retries once the idempotent requests without body answered 429
+// or 503 with a `Retry-After` of at most MaxWait, waiting it unless the request
+// deadline comes first. Longer waits or a deadline too close get the response as is
+func retryAfter(endpoint string, opts domain.RetryAfter, rt http.RoundTripper) http.RoundTripper {
+	if opts.MaxWait <= 0 {
+		return rt
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := rt.RoundTrip(req)
+		if err != nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+			return resp, err
+		}
+		// a body can`t be sent twice
+		if !IsIdempotent(req.Method) || (req.Body != nil && req.Body != http.NoBody) {
+			return resp, nil
+		}
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok || wait > opts.MaxWait {
+			return resp, nil
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) <= wait {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainRetried))
+		_ = resp.Body.Close()
+		status := strconv.Itoa(resp.StatusCode)
+		logger.LogDebug("proxy: upstream asked to retry after",
+			zap.String("endpoint", endpoint),
+			zap.String("status", status),
+			zap.Duration("wait", wait),
+			zap.String("request_id", reqid.FromContext(req.Context())),
+		)
+		if !WaitRetry(req, wait) {
+			return nil, req.Context().Err()
+		}
+		otelify.MetricRetryAfterRetries.WithLabelValues(endpoint, status).Inc()
+		return rt.RoundTrip(req)
+	})
+}
+
+// parseRetryAfter reads a `Retry-After` in seconds or as an http date, the
+// dates in the past are no wait
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// rewritePrefix replace the matched prefix of the client path with `to` before
+// forwarding, e.g. `/api/v1/users/5` with prefix `/api/v1/users` and to `/users`
+// reaches the upstream as `/users/5`. The query string is kept, an empty `to`
+// strips the prefix as http.StripPrefix
+func rewritePrefix(prefix, to string, next http.Handler) http.Handler {
+	if to == "" {
+		return http.StripPrefix(prefix, next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, prefix) {
+			http.NotFound(w, req)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *req
+		u := *req.URL
+		r2.URL = &u
+		r2.URL.Path = rewritePath(req.URL.Path, prefix, to)
+		if req.URL.RawPath != "" {
+			r2.URL.RawPath = rewritePath(req.URL.RawPath, prefix, to)
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// rewritePath returns the path with the prefix replaced by `to`, the rest of
+// the path is joined with a single slash and a trailing slash is kept
+func rewritePath(path, prefix, to string) string {
+	rest := strings.TrimPrefix(path, prefix)
+	switch {
+	case rest == "":
+		return to
+	case strings.HasSuffix(to, "/") && strings.HasPrefix(rest, "/"):
+		return to + rest[1:]
+	case !strings.HasSuffix(to, "/") && !strings.HasPrefix(rest, "/"):
+		return to + "/" + rest
+	}
+	return to + rest
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+)
+
+// Test_rewritePath the matched prefix is replaced keeping one slash between the parts
+func Test_rewritePath(t *testing.T) {
+	tests := []struct {
+		path, prefix, to string
+		want             string
+	}{
+		{"/api/v1/users", "/api/v1/users", "/users", "/users"},
+		{"/api/v1/users/5", "/api/v1/users", "/users", "/users/5"},
+		{"/api/v1/users/", "/api/v1/users", "/users", "/users/"},
+		{"/api/v1/users", "/api/v1/", "/", "/users"},
+		{"/api/v1/users/", "/api/v1/", "/", "/users/"},
+		{"/api/v1/users", "/api/v1/", "/v2", "/v2/users"},
+		{"/api/v1/users", "/api/v1/", "/v2/", "/v2/users"},
+		{"/api/v1/", "/api/v1/", "/v2/", "/v2/"},
+		{"/api/v1/", "/api/v1/", "/v2", "/v2"},
+	}
+	for _, tt := range tests {
+		if got := rewritePath(tt.path, tt.prefix, tt.to); got != tt.want {
+			t.Errorf("%s (%s -> %s): Expected %q and result are %q", tt.path, tt.prefix, tt.to, tt.want, got)
+		}
+	}
+}
+
+// Test_ProxyGateway_RewriteTo the upstream gets the rewritten path with the query,
+// without RewriteTo the prefix is stripped as before
+func Test_ProxyGateway_RewriteTo(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI: backend.URL,
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/ignored/", PathToProxy: "/rewrite/api/v1/", RewriteTo: "/"},
+			{PathEndpoint: "/ignored/", PathToProxy: "/rewrite/users", RewriteTo: "/people"},
+			{PathEndpoint: "/api/", PathToProxy: "/rewrite/strip/"},
+		},
+	}, "", "", "")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/rewrite/api/v1/users?page=2&sort=name", "/users?page=2&sort=name"},
+		{"/rewrite/api/v1/users/", "/users/"},
+		{"/rewrite/api/v1/a%2Fb", "/a%2Fb"},
+		{"/rewrite/users?id=5", "/people?id=5"},
+		{"/rewrite/strip/users?id=5", "/api/users?id=5"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("%s: Expected upstream %q and result are %q", tt.path, tt.want, got)
+		}
+	}
+}
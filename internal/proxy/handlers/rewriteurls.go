@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// externalURLCtx context key of the base url of the gateway seen by the client
+type externalURLCtx struct{}
+
+// urlRewriter rewrites the absolute urls of the backends in json bodies,
+// urls under the endpoint path are mapped to the route path of the gateway
+type urlRewriter struct {
+	external string
+	prefix   string
+	backends []string
+	endpoint string
+	fields   map[string]bool
+}
+
+// newURLRewriter returns nil when the route doesn`t rewrite urls
+func newURLRewriter(opts domain.URLRewrite, endpoint domain.Endpoint, hostURIs ...string) *urlRewriter {
+	if !opts.Enable {
+		return nil
+	}
+	rw := &urlRewriter{
+		external: strings.TrimSuffix(opts.ExternalURL, "/"),
+		prefix:   endpoint.PathToProxy,
+		endpoint: endpoint.PathEndpoint,
+	}
+	for _, hostURI := range hostURIs {
+		rw.backends = append(rw.backends, strings.TrimSuffix(hostURI, "/"))
+	}
+	if len(opts.Fields) > 0 {
+		rw.fields = make(map[string]bool, len(opts.Fields))
+		for _, field := range opts.Fields {
+			rw.fields[field] = true
+		}
+	}
+	return rw
+}
+
+// handler keeps the base url of the client request for the ModifyResponse
+func (rw *urlRewriter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		external := rw.external
+		if external == "" {
+			scheme := "http"
+			if req.TLS != nil {
+				scheme = "https"
+			}
+			external = scheme + "://" + req.Host
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), externalURLCtx{}, external)))
+	})
+}
+
+// modify rewrites the json body, identity and gzip encodings are supported and
+// bodies bigger than MaxBufferedResponse are streamed untouched
+func (rw *urlRewriter) modify(resp *http.Response) error {
+	external, _ := resp.Request.Context().Value(externalURLCtx{}).(string)
+	if external == "" || !isJSON(resp.Header.Get("Content-Type")) {
+		return nil
+	}
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if encoding != "" && encoding != "identity" && encoding != "gzip" {
+		return nil
+	}
+
+	raw := &bytes.Buffer{}
+	_, err := io.CopyN(raw, resp.Body, MaxBufferedResponse)
+	if err == nil {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(raw, resp.Body), resp.Body}
+		return nil
+	}
+	_ = resp.Body.Close()
+	if err != io.EOF {
+		return errors.Errorf("%w: %v", errors.ErrUpstreamTruncated, err)
+	}
+	resp.Body = io.NopCloser(raw)
+
+	body := raw.Bytes()
+	if encoding == "gzip" {
+		if body, err = gunzip(body); err != nil {
+			// undecodable bodies are forwarded as they came
+			return nil
+		}
+	}
+	rewritten, ok := rw.rewriteJSON(body, external)
+	if !ok {
+		return nil
+	}
+	if encoding == "gzip" {
+		if rewritten, err = gzipBytes(rewritten); err != nil {
+			return nil
+		}
+	}
+	// the new body has a known length, the upstream one may have been chunked
+	resp.Body = io.NopCloser(bytes.NewReader(rewritten))
+	resp.ContentLength = int64(len(rewritten))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+	return nil
+}
+
+// rewriteJSON returns false when the body isn`t json or has no backend url
+func (rw *urlRewriter) rewriteJSON(body []byte, external string) ([]byte, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, false
+	}
+	changed := false
+	doc = rw.walk(doc, "", external, &changed)
+	if !changed {
+		return nil, false
+	}
+	out := &bytes.Buffer{}
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, false
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), true
+}
+
+// walk rewrites the string values of the configured fields, every string without fields
+func (rw *urlRewriter) walk(value interface{}, field, external string, changed *bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, child := range v {
+			v[name] = rw.walk(child, name, external, changed)
+		}
+	case []interface{}:
+		for i, child := range v {
+			// the items of an array belong to the field of the array
+			v[i] = rw.walk(child, field, external, changed)
+		}
+	case string:
+		if rw.fields != nil && !rw.fields[field] {
+			return v
+		}
+		if rewritten, ok := rw.rewriteURL(v, external); ok {
+			*changed = true
+			return rewritten
+		}
+	}
+	return value
+}
+
+// rewriteURL maps `host_uri + path_endpoints` to `external + path_proxy`,
+// other urls of the backend only get the host replaced
+func (rw *urlRewriter) rewriteURL(value, external string) (string, bool) {
+	for _, backend := range rw.backends {
+		if !strings.HasPrefix(value, backend) {
+			continue
+		}
+		rest := value[len(backend):]
+		if rest != "" && rest[0] != '/' && rest[0] != '?' && rest[0] != '#' {
+			// another port or host sharing the prefix
+			continue
+		}
+		if rw.endpoint != "" && strings.HasPrefix(rest, rw.endpoint) {
+			return external + joinURLPath(rw.prefix, strings.TrimPrefix(rest, rw.endpoint)), true
+		}
+		return external + rest, true
+	}
+	return value, false
+}
+
+// joinURLPath joins the paths with a single slash like the reverse proxy does
+func joinURLPath(a, b string) string {
+	if b == "" {
+		return a
+	}
+	aslash, bslash := strings.HasSuffix(a, "/"), strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// isJSON returns true for application/json and the `+json` media types
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func gunzip(body []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func gzipBytes(body []byte) ([]byte, error) {
+	out := &bytes.Buffer{}
+	zw := gzip.NewWriter(out)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
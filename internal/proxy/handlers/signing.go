@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/sigv4"
+	"go.uber.org/zap"
+)
+
+// SigningSchemeSigV4 AWS Signature Version 4
+const SigningSchemeSigV4 = "aws-sigv4"
+
+// newSigner returns the Director step that signs the forwarded request,
+// nil when the service doesn`t configure a signing scheme
+func newSigner(opts domain.Signing) (func(*http.Request), error) {
+	switch opts.Scheme {
+	case "":
+		return nil, nil
+	case SigningSchemeSigV4:
+	default:
+		return nil, errors.Errorf("%w: %q", errors.ErrSigningScheme, opts.Scheme)
+	}
+
+	creds := sigv4.Credentials{
+		AccessKey:    opts.AccessKey,
+		SecretKey:    opts.SecretKey,
+		SessionToken: opts.SessionToken,
+	}
+	if creds.AccessKey == "" && creds.SecretKey == "" {
+		creds.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		creds.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		creds.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" || opts.Region == "" || opts.Service == "" {
+		return nil, errors.ErrSigningCredentials
+	}
+	signer := sigv4.Signer{Credentials: creds, Region: opts.Region, Service: opts.Service}
+
+	return func(req *http.Request) {
+		// the signed host must be the upstream, not the one the client sent
+		req.Host = req.URL.Host
+		if err := signer.Sign(req, time.Now()); err != nil {
+			logger.LogError(
+				errors.Errorf("proxy: signing %v", err).Error(),
+				zap.String("path", req.URL.Path),
+			)
+		}
+	}, nil
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net/http"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+const (
+	// SizeRouteSmall chunked requests use the default host_uri
+	SizeRouteSmall = "small"
+	// SizeRouteLarge chunked requests use the size_route host_uri
+	SizeRouteLarge = "large"
+)
+
+// validSizeRoute check the size route of a service, a zero Threshold disables it
+func validSizeRoute(opts domain.SizeRoute) error {
+	if opts.Threshold == 0 {
+		return nil
+	}
+	if opts.Threshold < 0 || opts.HostURI == "" {
+		return errors.Errorf("%w: threshold and host_uri are required", errors.ErrSizeRoute)
+	}
+	switch opts.Chunked {
+	case "", SizeRouteSmall, SizeRouteLarge:
+		return nil
+	}
+	return errors.Errorf("%w: chunked %q", errors.ErrSizeRoute, opts.Chunked)
+}
+
+// sizeRoute serve with large the requests whose declared body is bigger than
+// the threshold and, with chunked large, the ones without Content-Length
+func sizeRoute(opts domain.SizeRoute, large, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		size := req.ContentLength
+		if size > opts.Threshold || (size < 0 && opts.Chunked == SizeRouteLarge) {
+			large.ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"github.com/kenriortega/ngonx/pkg/reqid"
+	"go.uber.org/zap"
+)
+
+// slowRequests log with warning level and count the requests of the route
+// whose total time exceeds the threshold, a threshold <= 0 disables it
+func slowRequests(endpoint, backend string, threshold time.Duration, next http.Handler) http.Handler {
+	if threshold <= 0 {
+		return next
+	}
+	counter := otelify.MetricSlowRequests.WithLabelValues(endpoint)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, req)
+		if elapsed := time.Since(start); elapsed > threshold {
+			counter.Inc()
+			logger.LogWarn(
+				"proxy: slow request",
+				zap.String("route", endpoint),
+				zap.String("backend", backend),
+				zap.String("path", req.URL.Path),
+				zap.Duration("duration", elapsed),
+				zap.Duration("threshold", threshold),
+				zap.String("request_id", reqid.FromContext(req.Context())),
+			)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"go.uber.org/zap"
+)
+
+// remapStatus returns the ModifyResponse that rewrites the upstream status
+// using the table of the route, the body is left intact
+func remapStatus(table map[int]int) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if code, ok := table[resp.StatusCode]; ok {
+			resp.StatusCode = code
+			resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+		}
+		return nil
+	}
+}
+
+// checkContentType returns the ModifyResponse that logs and counts upstream
+// responses whose media type isn't the expected one, e.g. an html error page
+// on a json api. The response is only rejected with 502 when reject is true
+func checkContentType(endpoint, expected string, reject bool) func(*http.Response) error {
+	counter := otelify.MetricUnexpectedContentType.WithLabelValues(endpoint)
+	return func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+			return nil
+		}
+		got := resp.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(got)
+		if err == nil && strings.EqualFold(mediaType, expected) {
+			return nil
+		}
+		counter.Inc()
+		logger.LogWarn(
+			"proxy: unexpected upstream content-type",
+			zap.String("route", endpoint),
+			zap.String("path", resp.Request.URL.Path),
+			zap.String("expected", expected),
+			zap.String("content_type", got),
+			zap.Int("status", resp.StatusCode),
+		)
+		if reject {
+			return errors.Errorf("%w: %q", errors.ErrUnexpectedContentType, got)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// streamIdleTimeout reaps streaming responses (SSE, chunked) that don`t send
+// a chunk during `idle`, every chunk resets the timer and extends the write
+// deadline of the connection so active long-lived streams are never cut by
+// the server WriteTimeout. The upgrade handshakes of the websocket routes are
+// exempt as in requestTimeout, the tunnel has its own lifecycle. An idle <= 0 disables it
+func streamIdleTimeout(idle time.Duration, websocket bool, next http.Handler) http.Handler {
+	if idle <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if websocket && isUpgrade(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		timer := time.AfterFunc(idle, cancel)
+		defer timer.Stop()
+
+		rc := http.NewResponseController(w)
+		_ = rc.SetWriteDeadline(time.Now().Add(idle))
+		iw := &idleWriter{ResponseWriter: w, reset: func() {
+			timer.Reset(idle)
+			_ = rc.SetWriteDeadline(time.Now().Add(idle))
+		}}
+		next.ServeHTTP(iw, req.WithContext(ctx))
+	})
+}
+
+// idleWriter resets the idle timer on each chunk written to the client
+type idleWriter struct {
+	http.ResponseWriter
+	reset func()
+}
+
+func (w *idleWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	if n > 0 {
+		w.reset()
+	}
+	return n, err
+}
+
+func (w *idleWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps upgrades working through the writer, outside the websocket
+// routes the idle timeout still closes the tunnel
+func (w *idleWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *idleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
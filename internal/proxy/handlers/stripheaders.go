@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// StripHeaders returns the Director step that removes the request headers
+// that must not reach a less trusted backend e.g. credentials or internal ids,
+// a trailing `*` removes every header with the prefix e.g. `X-Internal-*`
+func StripHeaders(names []string) func(*http.Request) {
+	if len(names) == 0 {
+		return nil
+	}
+	var exact, prefixes []string
+	for _, name := range names {
+		if strings.HasSuffix(name, "*") {
+			prefixes = append(prefixes, textproto.CanonicalMIMEHeaderKey(strings.TrimSuffix(name, "*")))
+			continue
+		}
+		exact = append(exact, name)
+	}
+	return func(req *http.Request) {
+		for _, name := range exact {
+			req.Header.Del(name)
+		}
+		if len(prefixes) == 0 {
+			return
+		}
+		for name := range req.Header {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(textproto.CanonicalMIMEHeaderKey(name), prefix) {
+					req.Header.Del(name)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/httpsrv"
+)
+
+// requestTimeout bounds the whole request with a context deadline, when it
+// fires the ErrorHandler answers 504. On the websocket routes the upgraded
+// connections live beyond it, the deadline would close the tunnel. Elsewhere the
+// upgrade headers don't lift it, any client can send them. A timeout <= 0 disables it
+func requestTimeout(timeout time.Duration, websocket bool, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if websocket && isUpgrade(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// bodyTimeoutCtx context key of the deadlineBody of the request
+type bodyTimeoutCtx struct{}
+
+// bodyReadTimeout bounds the time the client takes to send the request body,
+// counted since the route got the request. Slow uploads (slowloris) are cut and
+// the ErrorHandler answers 408 instead of holding the connection and the
+// backend. HTTP/1 reads are interrupted with a read deadline on the connection
+// (the server needs httpsrv.ConnContext), the other protocols by closing the
+// body. The upgrade handshakes of the websocket routes are exempt as in
+// requestTimeout. A timeout <= 0 disables it
+func bodyReadTimeout(timeout time.Duration, websocket bool, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Body == nil || req.Body == http.NoBody || websocket && isUpgrade(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		body := &deadlineBody{ReadCloser: req.Body}
+		conn := httpsrv.ConnFromContext(req.Context())
+		switch {
+		case conn != nil && req.ProtoMajor == 1:
+			body.interrupt = func() { _ = conn.SetReadDeadline(time.Now()) }
+		case req.ProtoMajor > 1:
+			original := req.Body
+			body.interrupt = func() { _ = original.Close() }
+		default:
+			// closing an HTTP/1 body waits for the read in progress
+			next.ServeHTTP(w, req)
+			return
+		}
+		body.timer = time.AfterFunc(timeout, body.expire)
+		defer body.stop()
+
+		req = req.WithContext(context.WithValue(req.Context(), bodyTimeoutCtx{}, body))
+		req.Body = body
+		next.ServeHTTP(w, req)
+	})
+}
+
+// bodyTimedOut reports whether the body of the request wasn't received in time
+func bodyTimedOut(req *http.Request) bool {
+	body, ok := req.Context().Value(bodyTimeoutCtx{}).(*deadlineBody)
+	return ok && body.timedOut()
+}
+
+// deadlineBody request body interrupted when the timer fires before it's read
+type deadlineBody struct {
+	io.ReadCloser
+	interrupt func()
+	timer     *time.Timer
+
+	mu      sync.Mutex
+	done    bool
+	expired bool
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.stop()
+	}
+	if err != nil && err != io.EOF && b.timedOut() {
+		return n, errors.ErrBodyReadTimeout
+	}
+	return n, err
+}
+
+func (b *deadlineBody) Close() error {
+	b.stop()
+	return b.ReadCloser.Close()
+}
+
+// expire interrupts the read unless the body was already read or closed, once
+// done a read deadline would cancel the request still in flight
+func (b *deadlineBody) expire() {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+	b.done, b.expired = true, true
+	b.mu.Unlock()
+	b.interrupt()
+}
+
+func (b *deadlineBody) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.done {
+		b.done = true
+		b.timer.Stop()
+	}
+}
+
+func (b *deadlineBody) timedOut() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.expired
+}
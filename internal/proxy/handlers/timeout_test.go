@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/httpsrv"
+)
+
+// Test_ProxyGateway_BodyReadTimeout slow uploads get 408, the fast ones reach the backend
+func Test_ProxyGateway_BodyReadTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+
+	ph := &ProxyHandler{}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI: backend.URL,
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/upload/slow/", BodyReadTimeout: 100 * time.Millisecond},
+		},
+	}, "", "", "none")
+
+	gateway := httptest.NewUnstartedServer(http.DefaultServeMux)
+	gateway.Config.ConnContext = httpsrv.ConnContext
+	gateway.Start()
+	defer gateway.Close()
+
+	resp, err := http.Post(gateway.URL+"/upload/slow/files", "text/plain", strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "0123456789" {
+		t.Errorf("Expected status 200 and the body echoed and result are %d %q", resp.StatusCode, body)
+	}
+
+	conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// 2 of the 10 bytes announced and the client stalls
+	_, _ = io.WriteString(conn, "POST /upload/slow/files HTTP/1.1\r\nHost: gateway\r\nContent-Length: 10\r\n\r\n01")
+	slow, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow.Body.Close()
+	if slow.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("Expected status 408 and result are %d", slow.StatusCode)
+	}
+}
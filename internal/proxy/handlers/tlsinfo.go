@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+)
+
+// headers with the client TLS details forwarded to the upstreams
+const (
+	HeaderForwardedTLSVersion = "X-Forwarded-TLS-Version"
+	HeaderForwardedTLSCipher  = "X-Forwarded-TLS-Cipher"
+	HeaderClientCert          = "X-Client-Cert"
+)
+
+// TLSForwardOptions client TLS details forwarded after the termination,
+// ClientCert is `pem` (url escaped) or `fingerprint` (hex sha256), empty skips it
+type TLSForwardOptions struct {
+	Version    bool
+	Cipher     bool
+	ClientCert string
+}
+
+// ForwardTLS set the TLS details of the client connection as request headers,
+// the values sent by the clients are always dropped so they can`t be spoofed
+func ForwardTLS(opts TLSForwardOptions, next http.Handler) http.Handler {
+	if !opts.Version && !opts.Cipher && opts.ClientCert == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.Header.Del(HeaderForwardedTLSVersion)
+		req.Header.Del(HeaderForwardedTLSCipher)
+		req.Header.Del(HeaderClientCert)
+		if state := req.TLS; state != nil {
+			if opts.Version {
+				req.Header.Set(HeaderForwardedTLSVersion, tls.VersionName(state.Version))
+			}
+			if opts.Cipher {
+				req.Header.Set(HeaderForwardedTLSCipher, tls.CipherSuiteName(state.CipherSuite))
+			}
+			if len(state.PeerCertificates) > 0 {
+				raw := state.PeerCertificates[0].Raw
+				switch opts.ClientCert {
+				case "pem":
+					block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})
+					req.Header.Set(HeaderClientCert, url.PathEscape(string(block)))
+				case "fingerprint":
+					sum := sha256.Sum256(raw)
+					req.Header.Set(HeaderClientCert, hex.EncodeToString(sum[:]))
+				}
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// trailing slash modes of TrailingSlash
+const (
+	TrailingSlashStrip    = "strip"
+	TrailingSlashAdd      = "add"
+	TrailingSlashRedirect = "redirect"
+)
+
+// TrailingSlash maps `/api/foo` and `/api/foo/` to the same route of the mux.
+// When the path doesn`t match a route but the other form does, strip removes
+// the trailing slash, add appends it and redirect answers 301 to the other form.
+// An empty mode returns the mux as is
+func TrailingSlash(mode string, mux *http.ServeMux) (http.Handler, error) {
+	switch mode {
+	case "":
+		return mux, nil
+	case TrailingSlashStrip, TrailingSlashAdd, TrailingSlashRedirect:
+	default:
+		return mux, errors.Errorf("%w: %q", errors.ErrTrailingSlashMode, mode)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.Path
+		if path == "/" || routeMatches(mux, req, path) {
+			mux.ServeHTTP(w, req)
+			return
+		}
+		hasSlash := strings.HasSuffix(path, "/")
+		other := toggleSlash(path)
+		if (mode == TrailingSlashStrip && !hasSlash) || (mode == TrailingSlashAdd && hasSlash) ||
+			!routeMatches(mux, req, other) {
+			mux.ServeHTTP(w, req)
+			return
+		}
+		if mode == TrailingSlashRedirect {
+			location := *req.URL
+			location.Path, location.RawPath = other, toggleSlash(req.URL.RawPath)
+			http.Redirect(w, req, location.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+		r2 := req.Clone(req.Context())
+		r2.URL.Path, r2.URL.RawPath = other, toggleSlash(req.URL.RawPath)
+		mux.ServeHTTP(w, r2)
+	}), nil
+}
+
+// routeMatches returns true when a pattern of the mux covers the path itself,
+// not through the redirect of the mux to the subtree pattern
+func routeMatches(mux *http.ServeMux, req *http.Request, path string) bool {
+	r2 := *req
+	u := *req.URL
+	u.Path, u.RawPath = path, ""
+	r2.URL = &u
+	_, pattern := mux.Handler(&r2)
+	if pattern == "" {
+		return false
+	}
+	return pattern == path || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern))
+}
+
+func toggleSlash(path string) string {
+	if path == "" {
+		return ""
+	}
+	if strings.HasSuffix(path, "/") {
+		return strings.TrimSuffix(path, "/")
+	}
+	return path + "/"
+}
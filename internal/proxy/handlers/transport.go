@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/otelify"
+	"golang.org/x/net/http2"
+)
+
+// upstream protocols of TransportOptions
+const (
+	ProtocolAuto  = "auto"
+	ProtocolHTTP1 = "http1"
+	ProtocolHTTP2 = "http2"
+	ProtocolH2C   = "h2c"
+)
+
+// TransportOptions options used to build the upstream transport
+type TransportOptions struct {
+	// OutboundProxy egress proxy url (http://, https:// or socks5://)
+	// when it`s empty `HTTP_PROXY`, `HTTPS_PROXY` and `NO_PROXY` are used
+	OutboundProxy string
+	// MaxResponseHeaderBytes limit of the upstream response headers, 0 uses the default
+	MaxResponseHeaderBytes int64
+	// DialTimeout limit to establish the tcp connection, fails fast on dead backends
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout limit of the tls handshake with the backend
+	TLSHandshakeTimeout time.Duration
+	// ResponseTimeout limit to receive the response headers once the request is sent,
+	// the body is not limited
+	ResponseTimeout time.Duration
+	// ServerName SNI and name verified in the certificate of the backend,
+	// empty uses the host of the url. Backends dialed by ip with a hostname certificate
+	ServerName string
+	// TLS trusted CA, client certificate and verification of the https backends
+	TLS domain.UpstreamTLS
+	// Protocol upstream protocol auto|http1|http2|h2c, auto negotiates over tls
+	Protocol string
+	// HTTP2 multiplexing settings of the http2 connections, ignored with http1
+	HTTP2 domain.HTTP2
+}
+
+// NewTransport return a `*http.Transport` for the reverse proxy
+// based on the default transport
+func NewTransport(opts TransportOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if opts.OutboundProxy != "" {
+		proxyURL, err := url.Parse(opts.OutboundProxy)
+		if err != nil {
+			return nil, errors.Errorf("%w: %v", errors.ErrOutboundProxyURL, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, errors.Errorf("%w: unsupported scheme %q", errors.ErrOutboundProxyURL, proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if opts.MaxResponseHeaderBytes > 0 {
+		transport.MaxResponseHeaderBytes = opts.MaxResponseHeaderBytes
+	}
+	if opts.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	if opts.ResponseTimeout > 0 {
+		transport.ResponseHeaderTimeout = opts.ResponseTimeout
+	}
+
+	if opts.ServerName != "" || opts.TLS != (domain.UpstreamTLS{}) {
+		tlsConfig, err := upstreamTLSConfig(opts.ServerName, opts.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if opts.HTTP2.MaxConns > 0 {
+		transport.MaxConnsPerHost = opts.HTTP2.MaxConns
+	}
+
+	switch opts.Protocol {
+	case "", ProtocolAuto:
+		if opts.HTTP2 != (domain.HTTP2{}) {
+			h2, err := http2.ConfigureTransports(transport)
+			if err != nil {
+				return nil, errors.Errorf("%w: %v", errors.ErrUpstreamProtocol, err)
+			}
+			configureHTTP2(h2, opts.HTTP2)
+		}
+	case ProtocolHTTP1:
+		// a non nil empty map disables the http2 upgrade over tls
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	case ProtocolHTTP2:
+		transport.ForceAttemptHTTP2 = true
+		h2, err := http2.ConfigureTransports(transport)
+		if err != nil {
+			return nil, errors.Errorf("%w: %v", errors.ErrUpstreamProtocol, err)
+		}
+		configureHTTP2(h2, opts.HTTP2)
+	case ProtocolH2C:
+		// http2 with prior knowledge over cleartext for `http://` backends
+		dial := transport.DialContext
+		h2 := &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+		configureHTTP2(h2, opts.HTTP2)
+		transport.RegisterProtocol("http", h2)
+	default:
+		return nil, errors.Errorf("%w: %q", errors.ErrUpstreamProtocol, opts.Protocol)
+	}
+	return transport, nil
+}
+
+// upstreamTLSConfig client tls config of the backends, the CA bundle replaces
+// the system roots so only the internal PKI is trusted
+func upstreamTLSConfig(serverName string, opts domain.UpstreamTLS) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: serverName}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, errors.Errorf("%w: ca_file %v", errors.ErrUpstreamTLS, err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("%w: ca_file %s without pem certificates", errors.ErrUpstreamTLS, opts.CAFile)
+		}
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.Errorf("%w: cert_file and key_file go together", errors.ErrUpstreamTLS)
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, errors.Errorf("%w: client certificate %v", errors.ErrUpstreamTLS, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	cfg.InsecureSkipVerify = opts.InsecureSkipVerify
+	return cfg, nil
+}
+
+// configureHTTP2 apply the multiplexing settings of the service
+func configureHTTP2(h2 *http2.Transport, opts domain.HTTP2) {
+	h2.StrictMaxConcurrentStreams = opts.StrictMaxConcurrentStreams
+	h2.MaxReadFrameSize = opts.MaxReadFrameSize
+	h2.ReadIdleTimeout = opts.ReadIdleTimeout
+	h2.PingTimeout = opts.PingTimeout
+}
+
+// limitStreams caps the in-flight requests of the transport, requests over
+// the limit wait for a free stream until their context is done
+func limitStreams(rt http.RoundTripper, limit int) http.RoundTripper {
+	if limit <= 0 {
+		return rt
+	}
+	sem := make(chan struct{}, limit)
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		select {
+		case sem <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			<-sem
+			return nil, err
+		}
+		// the stream stays open until the body is consumed
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { <-sem }}
+		return resp, nil
+	})
+}
+
+// TraceUpstream records by backend (scheme and host of the request) the duration
+// of the dns lookup, the tcp connect, the tls handshake and the time to the first
+// response byte, new connections are the only ones with dns/connect/tls phases
+func TraceUpstream(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		backend := req.URL.Scheme + "://" + req.URL.Host
+		phase := func(name string, since time.Time) {
+			otelify.MetricUpstreamPhase.WithLabelValues(backend, name).Observe(time.Since(since).Seconds())
+		}
+		var (
+			mu       sync.Mutex
+			connects = make(map[string]time.Time)
+		)
+		var dnsStart, tlsStart, wrote time.Time
+		trace := &httptrace.ClientTrace{
+			DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+			DNSDone:  func(httptrace.DNSDoneInfo) { phase("dns", dnsStart) },
+			// dual stack backends dial the addresses in parallel
+			ConnectStart: func(_, addr string) {
+				mu.Lock()
+				connects[addr] = time.Now()
+				mu.Unlock()
+			},
+			ConnectDone: func(_, addr string, err error) {
+				mu.Lock()
+				start := connects[addr]
+				mu.Unlock()
+				if err == nil {
+					phase("connect", start)
+				}
+			},
+			TLSHandshakeStart: func() { tlsStart = time.Now() },
+			TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+				if err == nil {
+					phase("tls", tlsStart)
+				}
+			},
+			// the request is written and the response read by different goroutines
+			WroteRequest: func(httptrace.WroteRequestInfo) {
+				mu.Lock()
+				wrote = time.Now()
+				mu.Unlock()
+			},
+			GotFirstResponseByte: func() {
+				mu.Lock()
+				start := wrote
+				mu.Unlock()
+				if !start.IsZero() {
+					phase("ttfb", start)
+				}
+			},
+		}
+		return rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// releaseBody release the stream once on Close
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// IsResponseHeaderTooLarge returns true when the upstream response headers
+// exceeded `MaxResponseHeaderBytes`, net/http doesn`t export a typed error
+func IsResponseHeaderTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "server response headers exceeded")
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// isUpgrade returns true for the protocol upgrade handshakes (websockets),
+// `Connection: Upgrade` with the protocol in the `Upgrade` header
+func isUpgrade(req *http.Request) bool {
+	return req.Header.Get("Upgrade") != "" &&
+		httpguts.HeaderValuesContainsToken(req.Header["Connection"], "upgrade")
+}
+
+// skipUpgrades leaves the 101 responses untouched, their body is the hijacked
+// connection and the modifiers (buffering, compression, cache, url rewrites)
+// would break the tunnel
+func skipUpgrades(modify func(*http.Response) error) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			return nil
+		}
+		return modify(resp)
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/gorilla/websocket"
+	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+)
+
+// Test_ProxyGateway_WebSocket the handshake of a protected route is authenticated
+// and the frames are echoed through the tunnel past the request timeout and the
+// stream idle timeout, with the service buffering and the route cache enabled
+func Test_ProxyGateway_WebSocket(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			kind, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(kind, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	const key = "secret"
+	token, err := jwt.Sign(JWTPayload{Payload: jwt.Payload{
+		ExpirationTime: jwt.NumericDate(time.Now().Add(time.Hour)),
+	}}, jwt.NewHS256([]byte(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ph := &ProxyHandler{RequestTimeout: 50 * time.Millisecond}
+	ph.ProxyGateway(domain.ProxyEndpoint{
+		HostURI:         backend.URL,
+		BufferResponses: true,
+		Endpoints: []domain.Endpoint{
+			{PathEndpoint: "/", PathToProxy: "/upgrade/ws/", PathProtected: true, WebSocket: true,
+				StreamIdleTimeout: 50 * time.Millisecond, Cache: domain.Cache{TTL: time.Minute}},
+		},
+	}, "", key, "jwt")
+	gateway := httptest.NewServer(http.DefaultServeMux)
+	defer gateway.Close()
+	wsURL := "ws" + strings.TrimPrefix(gateway.URL, "http") + "/upgrade/ws/echo"
+	// a broken tunnel hangs instead of failing
+	dialer := &websocket.Dialer{HandshakeTimeout: 2 * time.Second}
+
+	_, resp, err := dialer.Dial(wsURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected the handshake without token to get %d and result are %v", http.StatusUnauthorized, err)
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + string(token)}}
+	conn, _, err := dialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("Expected the handshake to succeed and result are %v", err)
+	}
+	defer conn.Close()
+	for _, msg := range []string{"hello", "after the request timeout"} {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		_, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected echo %q and result are %v", msg, err)
+		}
+		if string(got) != msg {
+			t.Errorf("Expected echo %q and result are %q", msg, got)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Test_requestTimeout_Upgrade the upgrade headers lift the deadline only on the
+// websocket routes
+func Test_requestTimeout_Upgrade(t *testing.T) {
+	for _, websocket := range []bool{false, true} {
+		var deadline bool
+		handler := requestTimeout(time.Minute, websocket, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, deadline = r.Context().Deadline()
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if deadline == websocket {
+			t.Errorf("Expected deadline %v with websocket %v and result are %v", !websocket, websocket, deadline)
+		}
+	}
+}
@@ -2,15 +2,18 @@ package proxy
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gbrlsnchs/jwt/v3"
 	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/genkey"
 	"github.com/kenriortega/ngonx/pkg/logger"
 	"github.com/kenriortega/ngonx/pkg/otelify"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/kenriortega/ngonx/pkg/reqid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
@@ -20,10 +23,6 @@ func otelRegisterByRequest(ctx context.Context, start time.Time, req *http.Reque
 
 	traceID := trace.SpanContextFromContext(ctx).TraceID().String()
 
-	otelify.MetricRequestLatencyProxy.(prometheus.ExemplarObserver).ObserveWithExemplar(
-		time.Since(start).Seconds(), prometheus.Labels{"traceID": traceID},
-	)
-
 	if err != nil {
 		logger.LogError(
 			"proxy.Director.Metric",
@@ -42,39 +41,140 @@ func otelRegisterByRequest(ctx context.Context, start time.Time, req *http.Reque
 	)
 }
 
-// checkJWT check jwt for request
-func checkJWT(ctx context.Context, req *http.Request, key string) error {
+// isPreflight returns true for the CORS preflights, an OPTIONS request with
+// the method of the actual request in `Access-Control-Request-Method`
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// WriteError write the error as a `ResponseMiddleware` json response, for the
+// handlers outside the package e.g. the load balancer
+func WriteError(w http.ResponseWriter, code int, err error) {
+	writeResponseMiddleware(w, code, err.Error())
+}
+
+// writeResponseMiddleware write a `ResponseMiddleware` as json response
+func writeResponseMiddleware(w http.ResponseWriter, code int, message string) {
+	rpm := ResponseMiddleware{
+		Message: message,
+		Code:    code,
+	}
+	bytes, err := json.Marshal(&rpm)
+	if err != nil {
+		logger.LogError(err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(rpm.Code)
+	_, err = w.Write(bytes)
+	if err != nil {
+		logger.LogError(err.Error())
+	}
+}
+
+// credentialHeaders headers that carry credentials for protected routes
+var credentialHeaders = []string{"Authorization", "X-API-KEY"}
+
+// rejectDuplicateCredentials reject with 400 requests that send more than one
+// of the `headers` (ProxyHandler.CredentialHeaders), the credentials would be ambiguous
+func rejectDuplicateCredentials(headers []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, h := range headers {
+			if len(req.Header.Values(h)) > 1 {
+				logger.LogError(
+					errors.Errorf("proxy: %v", errors.ErrDuplicateCredentials).Error(),
+					zap.String("header", h),
+					zap.String("path", req.URL.Path),
+				)
+				writeResponseMiddleware(w, http.StatusBadRequest, errors.ErrDuplicateCredentials.Error())
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// checkJWT check jwt for request, the `alg` of the token must be the configured one
+// and the `iss`/`aud` claims the expected ones when they are set
+func checkJWT(ctx context.Context, req *http.Request, alg jwt.Algorithm, opts JWTOptions) error {
 	ctx, span := otel.Tracer("proxy.gateway.checkJWT").Start(ctx, "checkJWT")
 	defer span.End()
 	traceID := trace.SpanContextFromContext(ctx).TraceID().String()
 
-	header := req.Header.Get("Authorization") // pass to constanst
-	hs := jwt.NewHS256([]byte(key))
 	now := time.Now()
-	if !strings.HasPrefix(header, "Bearer ") {
+	token, ok := jwtToken(req, opts)
+	if !ok {
 		otelify.InstrumentedError(span, "checkJWT.bearer", traceID, errors.ErrBearerTokenFormat)
 		return errors.ErrBearerTokenFormat
 	}
 
-	token := strings.Split(header, " ")[1]
 	pl := JWTPayload{}
-	expValidator := jwt.ExpirationTimeValidator(now)
-	validatePayload := jwt.ValidatePayload(&pl.Payload, expValidator)
+	validators := []jwt.Validator{jwt.ExpirationTimeValidator(now)}
+	if opts.Issuer != "" {
+		validators = append(validators, jwt.IssuerValidator(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		validators = append(validators, jwt.AudienceValidator(jwt.Audience{opts.Audience}))
+	}
+	validatePayload := jwt.ValidatePayload(&pl.Payload, validators...)
 
-	_, err := jwt.Verify([]byte(token), hs, &pl, validatePayload)
+	_, err := jwt.Verify([]byte(token), alg, &pl, jwt.ValidateHeader, validatePayload)
 
 	if errors.ErrorIs(err, jwt.ErrExpValidation) {
 		otelify.InstrumentedError(span, "checkJWT.expValidation", traceID, errors.ErrTokenExpValidation)
 		return errors.ErrTokenExpValidation
 	}
+	if errors.ErrorIs(err, jwt.ErrIssValidation) {
+		otelify.InstrumentedError(span, "checkJWT.issValidation", traceID, errors.ErrTokenIssuerValidation)
+		return errors.ErrTokenIssuerValidation
+	}
+	if errors.ErrorIs(err, jwt.ErrAudValidation) {
+		otelify.InstrumentedError(span, "checkJWT.audValidation", traceID, errors.ErrTokenAudienceValidation)
+		return errors.ErrTokenAudienceValidation
+	}
 	if errors.ErrorIs(err, jwt.ErrHMACVerification) {
 		otelify.InstrumentedError(span, "checkJWT.HMACValidation", traceID, errors.ErrTokenHMACValidation)
 		return errors.ErrTokenHMACValidation
 	}
+	if errors.ErrorIs(err, jwt.ErrRSAVerification) || errors.ErrorIs(err, jwt.ErrECDSAVerification) {
+		otelify.InstrumentedError(span, "checkJWT.signatureValidation", traceID, errors.ErrTokenSignatureValidation)
+		return errors.ErrTokenSignatureValidation
+	}
+	if err != nil {
+		// malformed tokens or another `alg`
+		otelify.InstrumentedError(span, "checkJWT.verify", traceID, err)
+		return errors.Errorf("%w: %v", errors.ErrTokenInvalid, err)
+	}
 	otelify.InstrumentedInfo(span, "checkJWT", traceID)
 	return nil
 }
 
+// allowedAudience returns true when the `aud` claim (a string or a list)
+// has one of the allowed audiences, an empty list allows any
+func allowedAudience(claims map[string]interface{}, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	var aud []string
+	switch v := claims["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+	for _, a := range aud {
+		for _, want := range allowed {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // checkAPIKEY check apikey from request
 func checkAPIKEY(
 	ctx context.Context,
@@ -87,13 +187,32 @@ func checkAPIKEY(
 	traceID := trace.SpanContextFromContext(ctx).TraceID().String()
 
 	header := req.Header.Get("X-API-KEY")
-	apikey, err := ph.Service.GetKEY(engine, key)
+	apikeys, err := getAPIKeys(ph, engine, key)
 	if err != nil {
-		otelify.InstrumentedError(span, "checkAPIKEY.GetKEY", traceID, errors.ErrGetkeyView)
-		return errors.ErrGetkeyView
+		otelify.InstrumentedError(span, "checkAPIKEY.GetKEYs", traceID, err)
+		return err
 	}
-	if apikey == header {
+	// every key is compared, the time doesn't tell which one matched
+	matched := -1
+	for i, apikey := range apikeys {
+		if MatchAPIKey(apikey, header) && matched < 0 {
+			matched = i
+		}
+	}
+	if matched >= 0 && !genkey.IsHashedAPIKey(apikeys[matched]) {
+		if !ph.AllowPlaintextAPIKeys {
+			logger.LogWarn("proxy: plaintext api key in the store rejected, save it again with --prevkey or enable allow_plaintext_apikeys", zap.String("request_id", reqid.FromContext(req.Context())))
+			matched = -1
+		} else {
+			rehashAPIKey(ph, engine, key, apikeys[matched])
+		}
+	}
+	if matched >= 0 {
 		otelify.InstrumentedInfo(span, "checkAPIKEY", traceID)
+		logger.LogDebug("proxy: api key accepted",
+			zap.String("request_id", reqid.FromContext(req.Context())),
+			zap.String("key_id", genkey.APIKeyID(apikeys[matched])),
+		)
 		return nil
 	} else {
 		invalidKeyErr := errors.NewError("Invalid API KEY")
@@ -101,3 +220,104 @@ func checkAPIKEY(
 		return invalidKeyErr
 	}
 }
+
+// MatchAPIKey reports in constant time whether the presented key matches the
+// stored one, a salted hash or a plaintext key saved by older versions
+func MatchAPIKey(stored, presented string) bool {
+	if genkey.IsHashedAPIKey(stored) {
+		return genkey.CompareAPIKey(stored, presented)
+	}
+	return genkey.ComparePlaintextAPIKey(stored, presented)
+}
+
+// rehashAPIKey replaces a plaintext stored api key with its hash, the request
+// is served whether it fails or not
+func rehashAPIKey(ph *ProxyHandler, engine, key, apikey string) {
+	hashed, err := genkey.HashAPIKey(apikey)
+	if err == nil {
+		err = ph.Service.UpdateKEYs(engine, key, func(keys []string) []string {
+			for i, k := range keys {
+				if k == apikey {
+					keys[i] = hashed
+				}
+			}
+			return keys
+		})
+	}
+	if err != nil {
+		logger.LogError(errors.Errorf("proxy: rehash plaintext api key: %v", err).Error())
+		return
+	}
+	logger.LogWarn("proxy: plaintext api key in the store replaced with its hash")
+}
+
+// StoredSecret value saved in the store for the secret of the security type,
+// api keys are kept as salted hashes while jwt and hmac need the secret itself
+func StoredSecret(securityType, secret string) (string, error) {
+	if securityType != "apikey" {
+		return secret, nil
+	}
+	return genkey.HashAPIKey(secret)
+}
+
+// getSecret read the key of the store backed security types, a missing key
+// is ErrGetkeyView and a failing store (down, timeout) ErrSecretStoreUnavailable
+func getSecret(ph *ProxyHandler, engine, key string) (string, error) {
+	secret, err := ph.Service.GetKEY(engine, key)
+	if err == nil {
+		return secret, nil
+	}
+	if errors.ErrorIs(err, errors.ErrGetkeyNotFound) || errors.ErrorIs(err, errors.ErrGetkeyMemory) {
+		return "", errors.ErrGetkeyView
+	}
+	return "", errors.Errorf("%w: %v", errors.ErrSecretStoreUnavailable, err)
+}
+
+// getAPIKeys read the set of api keys, the errors are the getSecret ones
+func getAPIKeys(ph *ProxyHandler, engine, key string) ([]string, error) {
+	keys, err := ph.Service.GetKEYs(engine, key)
+	if err == nil {
+		return keys, nil
+	}
+	if errors.ErrorIs(err, errors.ErrGetkeyNotFound) || errors.ErrorIs(err, errors.ErrGetkeyMemory) {
+		return nil, errors.ErrGetkeyView
+	}
+	return nil, errors.Errorf("%w: %v", errors.ErrSecretStoreUnavailable, err)
+}
+
+// jwtClaimsCtx context key of the claims of a verified jwt
+type jwtClaimsCtx struct{}
+
+// jwtToken read the token from the configured header, without the scheme prefix.
+// It returns false when the scheme is expected but missing
+func jwtToken(req *http.Request, opts JWTOptions) (string, bool) {
+	header, scheme := opts.Header, opts.Scheme
+	if header == "" {
+		header, scheme = "Authorization", "Bearer"
+	}
+	value := req.Header.Get(header)
+	if scheme == "" {
+		return value, value != ""
+	}
+	if !strings.HasPrefix(value, scheme+" ") {
+		return "", false
+	}
+	return strings.TrimSpace(value[len(scheme)+1:]), true
+}
+
+// jwtClaims decode the claims of the bearer token, it must be called
+// after checkJWT verified the token
+func jwtClaims(req *http.Request, opts JWTOptions) map[string]interface{} {
+	claims := map[string]interface{}{}
+	token, _ := jwtToken(req, opts)
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims
+	}
+	_ = json.Unmarshal(payload, &claims)
+	return claims
+}
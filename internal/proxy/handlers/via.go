@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// viaValue the `Via` entry of the gateway e.g. `1.1 ngonx`
+func viaValue(major, minor int, pseudonym string) string {
+	return fmt.Sprintf("%d.%d %s", major, minor, pseudonym)
+}
+
+// viaLoop returns true when the pseudonym is already in the `Via` chain
+func viaLoop(h http.Header, pseudonym string) bool {
+	for _, value := range h.Values("Via") {
+		for _, hop := range strings.Split(value, ",") {
+			fields := strings.Fields(hop)
+			if len(fields) >= 2 && strings.EqualFold(fields[1], pseudonym) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// via answer 508 to requests that already went through the gateway,
+// otherwise append the gateway to the `Via` of the forwarded request
+func via(pseudonym string, next http.Handler) http.Handler {
+	if pseudonym == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if viaLoop(req.Header, pseudonym) {
+			writeResponseMiddleware(w, http.StatusLoopDetected, errors.ErrViaLoop.Error())
+			return
+		}
+		req.Header.Add("Via", viaValue(req.ProtoMajor, req.ProtoMinor, pseudonym))
+		next.ServeHTTP(w, req)
+	})
+}
+
+// viaResponse returns the ModifyResponse that append the gateway to the
+// `Via` of the upstream response
+func viaResponse(pseudonym string) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		resp.Header.Add("Via", viaValue(resp.ProtoMajor, resp.ProtoMinor, pseudonym))
+		return nil
+	}
+}
@@ -2,8 +2,10 @@ package proxy
 
 import (
 	"context"
+	"strings"
 
 	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
+	"github.com/kenriortega/ngonx/pkg/errors"
 	"github.com/kenriortega/ngonx/pkg/otelify"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
@@ -13,8 +15,15 @@ import (
 type ProxyService interface {
 	SaveSecretKEY(string, string, string) error
 	GetKEY(string, string) (string, error)
+	GetKEYs(string, string) ([]string, error)
+	AddSecretKEY(string, string, string) error
+	UpdateKEYs(string, string, func([]string) []string) error
 }
 
+// keySeparator separates the keys of a set in the stored value, a value saved
+// by SaveSecretKEY is a set of one
+const keySeparator = "\n"
+
 // DefaultProxyService struct for management proxy repository
 type DefaultProxyService struct {
 	repo domain.ProxyRepository
@@ -52,3 +61,42 @@ func (s DefaultProxyService) GetKEY(engine, key string) (string, error) {
 	otelify.InstrumentedInfo(span, "service.GetKey", traceID)
 	return result, nil
 }
+
+// GetKEYs get the set of keys saved under key e.g. the api keys of the
+// clients, or the old and the new one during a rotation
+func (s DefaultProxyService) GetKEYs(engine, key string) ([]string, error) {
+	value, err := s.GetKEY(engine, key)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, k := range strings.Split(value, keySeparator) {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// AddSecretKEY add a key to the set saved under key, the others stay valid
+func (s DefaultProxyService) AddSecretKEY(engine, key, apikey string) error {
+	return s.UpdateKEYs(engine, key, func(keys []string) []string {
+		for _, k := range keys {
+			if k == apikey {
+				return keys
+			}
+		}
+		return append(keys, apikey)
+	})
+}
+
+// UpdateKEYs replace the set saved under key with the one update returns, a
+// missing key is an empty set. It isn't atomic, concurrent updates may be lost
+func (s DefaultProxyService) UpdateKEYs(engine, key string, update func([]string) []string) error {
+	keys, err := s.GetKEYs(engine, key)
+	if err != nil && !errors.ErrorIs(err, errors.ErrGetkeyNotFound) && !errors.ErrorIs(err, errors.ErrGetkeyMemory) {
+		return err
+	}
+	_, err = s.SaveSecretKEY(engine, key, strings.Join(update(keys), keySeparator))
+	return err
+}
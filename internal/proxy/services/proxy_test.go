@@ -31,3 +31,42 @@ func Test_GetKEY(t *testing.T) {
 		t.Error("Expected apikey as a value for `key`")
 	}
 }
+
+func Test_MemoryEngine(t *testing.T) {
+	service := NewProxyService(domain.NewProxyRepository())
+	if _, err := service.SaveSecretKEY("memory", "key", "apikey"); err != nil {
+		t.Errorf("Error to created key %v", err)
+	}
+	result, err := service.GetKEY("memory", "key")
+	if err != nil {
+		t.Errorf("Error to get key %v", err)
+	}
+	if result != "apikey" {
+		t.Error("Expected apikey as a value for `key`")
+	}
+	if _, err := service.GetKEY("memory", "missing"); err == nil {
+		t.Error("Expected an error for a missing key")
+	}
+}
+
+func Test_MemoryKeySet(t *testing.T) {
+	service := NewProxyService(domain.NewProxyRepository())
+	for _, k := range []string{"old", "new", "new"} {
+		if err := service.AddSecretKEY("memory", "set", k); err != nil {
+			t.Errorf("Error to add key %v", err)
+		}
+	}
+	keys, err := service.GetKEYs("memory", "set")
+	if err != nil {
+		t.Errorf("Error to get keys %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "old" || keys[1] != "new" {
+		t.Errorf("Expected keys [old new] and result are %v", keys)
+	}
+	if err := service.UpdateKEYs("memory", "set", func(keys []string) []string { return keys[1:] }); err != nil {
+		t.Errorf("Error to update keys %v", err)
+	}
+	if keys, _ := service.GetKEYs("memory", "set"); len(keys) != 1 || keys[0] != "new" {
+		t.Errorf("Expected keys [new] and result are %v", keys)
+	}
+}
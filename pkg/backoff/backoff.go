@@ -18,6 +18,32 @@ var Default = BackoffPolicy{
 	[]int{0, 10, 10, 100, 100, 500, 500, 3000, 3000, 5000},
 }
 
+// Exponential returns a policy starting at min and growing by factor on every
+// wait cycle until it saturates at max, a factor < 1 keeps the min delay.
+// A min of 0 retries right away the first time and then grows from 10ms like Default
+func Exponential(min, max time.Duration, factor float64) BackoffPolicy {
+	if max < min {
+		max = min
+	}
+	if factor <= 1 {
+		return BackoffPolicy{[]int{int(min.Milliseconds())}}
+	}
+	var millis []int
+	delay := float64(min.Milliseconds())
+	for i := 0; i < 32; i++ {
+		if delay >= float64(max.Milliseconds()) {
+			break
+		}
+		millis = append(millis, int(delay))
+		if delay == 0 {
+			delay = 10
+			continue
+		}
+		delay *= factor
+	}
+	return BackoffPolicy{append(millis, int(max.Milliseconds()))}
+}
+
 // Duration returns the time duration of the n'th wait cycle in a
 // backoff policy. This is b.Millis[n], randomized to avoid thundering
 // herds.
@@ -0,0 +1,264 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Redacted value written instead of the secrets, replay drops the headers carrying it
+const Redacted = "[REDACTED]"
+
+// defaultMaxBodyBytes bytes of the request and response bodies kept when
+// Options.MaxBodyBytes is 0
+const defaultMaxBodyBytes = 64 << 10
+
+// defaultMaxBackups rotated capture files kept when Options.MaxBackups is 0
+const defaultMaxBackups = 3
+
+// defaultRedact headers always redacted
+var defaultRedact = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-API-KEY", "X-Signature"}
+
+// Options options of the request capture
+type Options struct {
+	Path string
+	// SampleRate share (0-1) of the requests captured
+	SampleRate float64
+	// Bodies keep the request and response bodies, off only the headers are written
+	Bodies bool
+	// MaxBodyBytes bytes kept of each body, longer ones are truncated, 0 uses 64KiB
+	MaxBodyBytes int64
+	// MaxSize megabytes of the file before rotating it, 0 uses 100
+	MaxSize int
+	// MaxBackups rotated files kept, 0 uses 3
+	MaxBackups int
+	// Redact headers and query params (case-insensitive) whose values are
+	// replaced by Redacted, on top of the credential headers
+	Redact []string
+}
+
+// Record a captured request and the response it got, one json line of the file
+type Record struct {
+	Time              time.Time   `json:"time"`
+	Method            string      `json:"method"`
+	URI               string      `json:"uri"`
+	Host              string      `json:"host"`
+	Header            http.Header `json:"header"`
+	Body              []byte      `json:"body,omitempty"`
+	BodyTruncated     bool        `json:"body_truncated,omitempty"`
+	Status            int         `json:"status"`
+	ContentType       string      `json:"content_type,omitempty"`
+	ResponseBody      []byte      `json:"response_body,omitempty"`
+	ResponseTruncated bool        `json:"response_truncated,omitempty"`
+}
+
+// Capture writes a sample of the requests served to a file
+type Capture struct {
+	opts   Options
+	redact map[string]bool
+	mu     sync.Mutex
+	file   *lumberjack.Logger
+	enc    *json.Encoder
+}
+
+// New returns a capture appending to `opts.Path`, rotated at opts.MaxSize
+func New(opts Options) (*Capture, error) {
+	if opts.Path == "" {
+		return nil, errors.Errorf("%w: path is required", errors.ErrCaptureFile)
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		return nil, errors.Errorf("%w: sample rate %g out of (0-1]", errors.ErrCaptureFile, opts.SampleRate)
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = defaultMaxBackups
+	}
+	// lumberjack opens the file on the first write, fail at startup instead
+	f, err := os.OpenFile(opts.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Errorf("%w: %v", errors.ErrCaptureFile, err)
+	}
+	_ = f.Close()
+	file := &lumberjack.Logger{Filename: opts.Path, MaxSize: opts.MaxSize, MaxBackups: opts.MaxBackups}
+	redact := make(map[string]bool)
+	for _, name := range append(append([]string{}, defaultRedact...), opts.Redact...) {
+		redact[strings.ToLower(name)] = true
+	}
+	return &Capture{opts: opts, redact: redact, file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Handler capture a sample of the requests served by next, the bodies are
+// forwarded in full whatever was kept
+func (c *Capture) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64() >= c.opts.SampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+		record := Record{
+			Time:   time.Now(),
+			Method: r.Method,
+			URI:    c.redactQuery(r.URL),
+			Host:   r.Host,
+			Header: c.redactHeader(r.Header),
+		}
+		hasBody := r.Body != nil && r.Body != http.NoBody
+		// without Bodies they count as truncated, replay skips them
+		if hasBody && !c.opts.Bodies {
+			record.BodyTruncated = true
+		} else if hasBody {
+			read, err := io.ReadAll(io.LimitReader(r.Body, c.opts.MaxBodyBytes+1))
+			if err != nil {
+				logger.LogError(errors.Errorf("capture: %v", err).Error())
+			}
+			record.Body = read
+			if int64(len(read)) > c.opts.MaxBodyBytes {
+				record.Body, record.BodyTruncated = read[:c.opts.MaxBodyBytes], true
+			}
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(read), r.Body), r.Body}
+		}
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		if c.opts.Bodies {
+			rec.limit = c.opts.MaxBodyBytes
+		}
+		next.ServeHTTP(rec, r)
+		record.Status = rec.status
+		record.ContentType = rec.Header().Get("Content-Type")
+		record.ResponseBody = rec.body.Bytes()
+		record.ResponseTruncated = rec.truncated
+		c.write(record)
+	})
+}
+
+func (c *Capture) write(record Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(record); err != nil {
+		logger.LogError(errors.Errorf("capture: %w: %v", errors.ErrCaptureFile, err).Error())
+	}
+}
+
+// redactHeader copy of the headers with the secrets redacted
+func (c *Capture) redactHeader(header http.Header) http.Header {
+	out := header.Clone()
+	for name, values := range out {
+		if c.redact[strings.ToLower(name)] {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return out
+}
+
+// redactQuery request uri with the secret query params redacted, the others
+// keep their original encoding
+func (c *Capture) redactQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		name := pair
+		if at := strings.IndexByte(pair, '='); at >= 0 {
+			name = pair[:at]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil && c.redact[strings.ToLower(unescaped)] {
+			pairs[i] = name + "=" + url.QueryEscape(Redacted)
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(pairs, "&")
+	return redacted.RequestURI()
+}
+
+// Close closes the file
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
+
+// Read decodes the records of a capture file
+func Read(r io.Reader) ([]Record, error) {
+	var records []Record
+	dec := json.NewDecoder(r)
+	for {
+		var record Record
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, errors.Errorf("%w: record %d: %v", errors.ErrCaptureFile, len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// recorder keeps the status and the first `limit` bytes of the response, a
+// limit of 0 keeps none
+type recorder struct {
+	http.ResponseWriter
+	status    int
+	limit     int64
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (r *recorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if room := r.limit - int64(r.body.Len()); int64(len(b)) <= room {
+		r.body.Write(b)
+	} else {
+		r.body.Write(b[:room])
+		r.truncated = true
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the recorder
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps upgraded connections (websockets) working through the recorder
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
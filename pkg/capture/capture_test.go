@@ -0,0 +1,58 @@
+package capture
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test_Capture_Bodies the bodies are written only when enabled and the
+// redacted headers never reach the file
+func Test_Capture_Bodies(t *testing.T) {
+	for _, bodies := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "capture.jsonl")
+		c, err := New(Options{Path: path, SampleRate: 1, Bodies: bodies, Redact: []string{"X-Nonce"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var forwarded string
+		handler := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			read, _ := io.ReadAll(r.Body)
+			forwarded = string(read)
+			_, _ = w.Write([]byte("secret response"))
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/capture", strings.NewReader("secret request"))
+		req.Header.Set("X-Nonce", "n-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if forwarded != "secret request" {
+			t.Errorf("Expected the body forwarded in full and result are %q", forwarded)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := Read(f)
+		_ = f.Close()
+		if err != nil || len(records) != 1 {
+			t.Fatalf("Expected 1 record and result are %d %v", len(records), err)
+		}
+		record := records[0]
+		if got := record.Header.Get("X-Nonce"); got != Redacted {
+			t.Errorf("Expected the nonce %q and result are %q", Redacted, got)
+		}
+		if bodies && (string(record.Body) != "secret request" || string(record.ResponseBody) != "secret response") {
+			t.Errorf("Expected the bodies captured and result are %q %q", record.Body, record.ResponseBody)
+		}
+		if !bodies && (len(record.Body) > 0 || len(record.ResponseBody) > 0 || !record.BodyTruncated) {
+			t.Errorf("Expected no bodies and a truncated request and result are %q %q %v", record.Body, record.ResponseBody, record.BodyTruncated)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	domain "github.com/kenriortega/ngonx/internal/proxy/domain"
 	"github.com/kenriortega/ngonx/pkg/errors"
@@ -16,6 +17,17 @@ type Config struct {
 	ProxyGateway `mapstructure:"proxy"`
 	GrpcProxy    `mapstructure:"grpc"`
 	StaticServer `mapstructure:"static_server"`
+	Admin        `mapstructure:"admin"`
+}
+
+// Admin struct for the admin api and metrics listeners
+type Admin struct {
+	// TrustedCIDRs only peers from these networks can reach the listeners, empty trust all
+	TrustedCIDRs []string `mapstructure:"trusted_cidrs"`
+	// DropUntrusted close the connection without response instead of 403
+	DropUntrusted bool `mapstructure:"drop_untrusted"`
+	// APIKeysFile hashes of the api keys of the lb admin api, see `lb --genadminkey`
+	APIKeysFile string `mapstructure:"api_keys_file"`
 }
 
 // GrpcProxy ...
@@ -48,6 +60,86 @@ type ProxyGateway struct {
 	ProxySecurity     ProxySecurity          `mapstructure:"security"`
 	ProxyCache        ProxyCache             `mapstructure:"cache_proxy"`
 	EnpointsProxy     []domain.ProxyEndpoint `mapstructure:"services_proxy"`
+	// SlowRequestThreshold requests slower than it are logged and counted, 0 disables
+	SlowRequestThreshold time.Duration `mapstructure:"slow_request_threshold"`
+	AccessLog            AccessLog     `mapstructure:"access_log"`
+	Capture              Capture       `mapstructure:"capture"`
+	// RequestTimeout default timeout of every request, routes can override it, 0 disables it
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+	// DrainTimeout wait for the requests in flight on SIGINT/SIGTERM before closing them, 0 uses 30s
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+	// MaxConnsPerIP simultaneous requests of a client ip, over it 429, 0 unlimited
+	MaxConnsPerIP int `mapstructure:"max_conns_per_ip"`
+	// MaxConnections concurrent connections of the listener, 0 unlimited,
+	// MaxConnectionsMode wait|reject the connections over it
+	MaxConnections     int    `mapstructure:"max_connections"`
+	MaxConnectionsMode string `mapstructure:"max_connections_mode"`
+	// MaxQueryLength bytes of the raw query string and MaxQueryParams parameters
+	// of a request, over them 400, 0 unlimited
+	MaxQueryLength int `mapstructure:"max_query_length"`
+	MaxQueryParams int `mapstructure:"max_query_params"`
+	// LatencyClasses latency buckets (seconds) by route class, the routes with a
+	// latency_class are measured in the histogram of the class
+	LatencyClasses map[string][]float64 `mapstructure:"latency_classes"`
+	// TrustedProxies of the `X-Forwarded-For` header that resolves the client ip
+	TrustedProxies TrustedProxies `mapstructure:"trusted_proxies"`
+	// Via pseudonym added to the `Via` header of requests and responses, empty disables it
+	Via string `mapstructure:"via"`
+	// TrailingSlash strip|add|redirect, `/api/foo` and `/api/foo/` match the same route
+	TrailingSlash string `mapstructure:"trailing_slash"`
+	// RequestID header and format of the request ids
+	RequestID RequestID `mapstructure:"request_id"`
+	// DebugDelay latency injected only to the matching clients
+	DebugDelay DebugDelay `mapstructure:"debug_delay"`
+}
+
+// TrustedProxies struct for the proxies in front of the gateway, the client is
+// the Count-th address from the right of `X-Forwarded-For` (0 the leftmost) and
+// with CIDRs the header is only honored when the direct peer belongs to them
+type TrustedProxies struct {
+	Count int      `mapstructure:"count"`
+	CIDRs []string `mapstructure:"cidrs"`
+}
+
+// RequestID struct for the request ids, an empty header disables them,
+// Format is uuidv4|uuidv7|ulid. PropagateOnly never generates them, only the
+// ones minted upstream are forwarded and logged
+type RequestID struct {
+	Header        string `mapstructure:"header"`
+	Format        string `mapstructure:"format"`
+	PropagateOnly bool   `mapstructure:"propagate_only"`
+}
+
+// DebugDelay struct for the targeted latency injection, requests from the
+// ClientCIDRs or carrying the Header are delayed, the rest is untouched
+type DebugDelay struct {
+	Enable      bool          `mapstructure:"enable"`
+	Delay       time.Duration `mapstructure:"delay"`
+	ClientCIDRs []string      `mapstructure:"client_cidrs"`
+	Header      string        `mapstructure:"header"`
+}
+
+// Capture struct for the request capture replayed by `ngonxctl replay`, the
+// credential headers and the Redact names are never written. An empty path disables it
+type Capture struct {
+	Path       string  `mapstructure:"path"`
+	SampleRate float64 `mapstructure:"sample_rate"`
+	// Bodies keep the request and response bodies up to MaxBodyBytes
+	Bodies       bool     `mapstructure:"bodies"`
+	MaxBodyBytes int64    `mapstructure:"max_body_bytes"`
+	MaxSizeMB    int      `mapstructure:"max_size_mb"`
+	MaxBackups   int      `mapstructure:"max_backups"`
+	Redact       []string `mapstructure:"redact"`
+}
+
+// AccessLog struct for the rotating access log file, an empty path disables it
+type AccessLog struct {
+	Path        string        `mapstructure:"path"`
+	MaxSizeMB   int           `mapstructure:"max_size_mb"`
+	MaxBackups  int           `mapstructure:"max_backups"`
+	MaxAgeDays  int           `mapstructure:"max_age_days"`
+	Compress    bool          `mapstructure:"compress"`
+	RotateEvery time.Duration `mapstructure:"rotate_every"`
 }
 
 // OptionSSL struct for the ssl options
@@ -56,11 +148,59 @@ type OptionSSL struct {
 	SSLPort int    `mapstructure:"ssl_port"`
 	CrtFile string `mapstructure:"crt_file"`
 	KeyFile string `mapstructure:"key_file"`
+	HTTP3   bool   `mapstructure:"http3"`
+	// ClientCAFile verifies the client certificates when they are sent, empty doesn`t ask for them
+	ClientCAFile string `mapstructure:"client_ca_file"`
+	// MinVersion 1.2|1.3 and CipherSuites of TLS 1.2 (names of crypto/tls), empty keep the defaults
+	MinVersion   string   `mapstructure:"min_version"`
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	// ForwardTLS client TLS details forwarded to the upstreams, only for the proxy
+	ForwardTLS ForwardTLS `mapstructure:"forward_tls"`
+}
+
+// ForwardTLS struct for the client TLS details sent as headers after the termination,
+// ClientCert is pem|fingerprint
+type ForwardTLS struct {
+	Version    bool   `mapstructure:"version"`
+	Cipher     bool   `mapstructure:"cipher"`
+	ClientCert string `mapstructure:"client_cert"`
 }
 
 // ProxySecurity struct for security object
 type ProxySecurity struct {
-	Type string `mapstructure:"type"`
+	Type                  string `mapstructure:"type"`
+	AllowDuplicateHeaders bool   `mapstructure:"allow_duplicate_headers"`
+	// SkipAuthPreflight forwards the CORS preflights of the protected routes
+	// without credentials, the backend answers them
+	SkipAuthPreflight bool `mapstructure:"skip_auth_preflight"`
+	// AllowPlaintextAPIKeys accepts the api keys stored in plaintext by older
+	// versions during the migration, each one is hashed on its first use
+	AllowPlaintextAPIKeys bool `mapstructure:"allow_plaintext_apikeys"`
+	// JWTAlg HS256|RS256|ES256, RS256 and ES256 verify with the PEM JWTPublicKey
+	JWTAlg       string `mapstructure:"jwt_alg"`
+	JWTPublicKey string `mapstructure:"jwt_public_key"`
+	// JWTHeaderName and JWTScheme where the token is read e.g. `X-Access-Token`
+	// without scheme, an empty header name uses `Authorization: Bearer`
+	JWTHeaderName string `mapstructure:"jwt_header_name"`
+	JWTScheme     string `mapstructure:"jwt_scheme"`
+	// JWTIssuer and JWTAudience expected `iss` and `aud` claims, empty skips them
+	JWTIssuer   string `mapstructure:"jwt_issuer"`
+	JWTAudience string `mapstructure:"jwt_audience"`
+	// StoreFailMode closed|open, apikey and hmac requests when the key store is unavailable
+	StoreFailMode string      `mapstructure:"store_fail_mode"`
+	HMAC          HMACOptions `mapstructure:"hmac"`
+}
+
+// HMACOptions struct for the hmac security type
+type HMACOptions struct {
+	Tolerance   time.Duration `mapstructure:"tolerance"`
+	NonceHeader string        `mapstructure:"nonce_header"`
+	// MaxBody max bytes of a signed body, bigger requests get 413
+	MaxBody int64 `mapstructure:"max_body"`
+	// NonceStore memory|redis, empty disables the replay protection
+	NonceStore string `mapstructure:"nonce_store"`
+	RedisURI   string `mapstructure:"redis_uri"`
+	RedisPass  string `mapstructure:"redis_pass"`
 }
 
 // ProxyCache struct for cache options object
@@ -93,6 +233,10 @@ func (c *Config) CreateSettingFile(setingFile string) {
 	f, err := os.Create(fmt.Sprintf("./%s", setingFile))
 	ymldata :=
 		`
+admin:
+  trusted_cidrs: [] # e.g. [10.0.0.0/8, 127.0.0.1] empty trust all
+  drop_untrusted: false
+  api_keys_file: ./key/lb_admin_keys # api key hashes of the lb admin api, lb --genadminkey
 static_server:
   host_server: 0.0.0.0
   port_server: 8080
@@ -116,16 +260,77 @@ proxy:
   host_proxy: 0.0.0.0
   port_proxy: 30000
   port_exporter_proxy: 10000
+  slow_request_threshold: 0s # e.g. 1s, slower requests are logged and counted
+  request_timeout: 0s # default timeout of every request (504), routes can override it
+  drain_timeout: 30s # on SIGINT/SIGTERM requests in flight finish within it before the connections close
+  max_conns_per_ip: 0 # simultaneous requests of a client ip, over it 429, 0 unlimited
+  max_connections: 0 # concurrent connections of the listener, 0 unlimited
+  max_connections_mode: wait # wait|reject (closed right away) the connections over it
+  max_query_length: 0 # bytes of the query string, over it 400, 0 unlimited
+  max_query_params: 0 # params of the query string (repeated ones count every time), over it 400, 0 unlimited
+  latency_classes: {} # buckets in seconds by route class for the routes with latency_class e.g. fast: [0.0005, 0.001, 0.005, 0.01]
+  trusted_proxies: # X-Forwarded-For of the proxies in front of the gateway, the client ip of rate limits and cidr lists
+    count: 0 # proxies appending to the header, the client is the count-th address from the right, 0 the leftmost
+    cidrs: [] # honor the header only from these direct peers, empty from any
+  via: ngonx # Via pseudonym, requests already carrying it get 508, empty disables it
+  trailing_slash: "" # strip|add|redirect (301) so /api/foo and /api/foo/ match the same route, empty disables it
+  request_id: # forwarded, echoed in the response and logged, empty header disables it
+    header: X-Request-ID # e.g. X-Correlation-ID, Request-Id
+    format: uuidv4 # uuidv4|uuidv7|ulid (sortable)
+    propagate_only: false # forward the ids minted upstream without generating them
+  debug_delay: # latency injected only to matching clients, toggle it at /api/v1/mngt/debug-delay
+    enable: false
+    delay: 0s # e.g. 5s
+    client_cidrs: [] # e.g. [10.1.2.3, 192.168.0.0/24]
+    header: X-Ngonx-Debug-Delay # requests carrying it are delayed, empty disables the match
+  access_log: # rotating access log file, empty path disables it
+    path: ""
+    max_size_mb: 100
+    max_backups: 7
+    max_age_days: 28
+    compress: false
+    rotate_every: 24h # 0s only rotates by size
+  capture: # sample of the requests and responses for ngonxctl replay, empty path disables it
+    path: ""
+    sample_rate: 0.01 # share (0-1] of the requests captured
+    bodies: false # keep the request/response bodies, off only the headers are written
+    max_body_bytes: 65536 # longer request/response bodies are truncated
+    max_size_mb: 100 # the file is rotated at this size
+    max_backups: 3 # rotated files kept
+    redact: [] # headers and query params written as [REDACTED] on top of the credential headers
   ssl_proxy:
     enable: true
     ssl_port: 443
     crt_file: ./key/server.crt
     key_file: ./key/server.key
+    http3: false # serve also over HTTP/3 (QUIC, udp)
+    client_ca_file: "" # verify the client certificates when they are sent
+    min_version: "1.2" # 1.2|1.3
+    cipher_suites: [] # TLS 1.2 suites e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, empty TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384
+    forward_tls: # client TLS details forwarded to the upstreams as headers
+      version: false # X-Forwarded-TLS-Version
+      cipher: false # X-Forwarded-TLS-Cipher
+      client_cert: "" # X-Client-Cert pem|fingerprint, empty disables it
   cache_proxy:
-    engine: badger # local|badgerDB|redis
+    engine: badger # local|badgerDB|redis|memory (tests and local dev)
     key: secretKey
   security:
-    type: apikey # apikey|jwt|none
+    type: apikey # apikey|jwt|hmac|none
+    allow_duplicate_headers: false # reject with 400 multiple Authorization|X-API-KEY|jwt_header_name|hmac headers
+    skip_auth_preflight: false # CORS preflights (OPTIONS + Access-Control-Request-Method) reach the backend without credentials
+    allow_plaintext_apikeys: false # accept api keys stored in plaintext by older versions, they are replaced with their hash on first use
+    jwt_alg: HS256 # HS256 (secret key)|RS256|ES256 (jwt_public_key)
+    jwt_public_key: "" # PEM public key or certificate of the identity provider
+    jwt_issuer: "" # expected iss claim, empty skips it
+    jwt_audience: "" # expected aud claim, empty skips it
+    jwt_header_name: Authorization # header of the token e.g. X-Access-Token
+    jwt_scheme: Bearer # prefix of the token, empty reads the header value as is
+    store_fail_mode: closed # closed (503)|open (forward) when the key store is unavailable
+    hmac:
+      tolerance: 5m
+      nonce_header: X-Nonce
+      max_body: 10485760 # bytes of a signed body, bigger requests get 413
+      nonce_store: memory # memory|redis, empty disables replay protection
   # maps of microservices with routes
   services_proxy:
       - name: microA
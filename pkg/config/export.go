@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Redacted value exported instead of the secrets
+const Redacted = "[REDACTED]"
+
+// secretFields keys whose values are never exported
+var secretFields = map[string]bool{
+	"redis_pass":    true,
+	"access_key":    true,
+	"secret_key":    true,
+	"session_token": true,
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Export returns the config keyed by the yaml names, for auditing and drift
+// detection against the file in source control. The secrets are redacted and
+// so are the passwords of the urls, durations are strings e.g. `1m30s`
+func Export(c Config) map[string]interface{} {
+	out, _ := exportValue(reflect.ValueOf(c)).(map[string]interface{})
+	return out
+}
+
+func exportValue(v reflect.Value) interface{} {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		out := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			if secretFields[name] && !v.Field(i).IsZero() {
+				out[name] = Redacted
+				continue
+			}
+			out[name] = exportValue(v.Field(i))
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = exportValue(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = exportValue(iter.Value())
+		}
+		return out
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return exportValue(v.Elem())
+	case reflect.String:
+		return redactURL(v.String())
+	}
+	return v.Interface()
+}
+
+// redactURL hides the password of urls with credentials e.g. an outbound proxy
+func redactURL(s string) string {
+	if !strings.Contains(s, "@") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
@@ -10,6 +10,10 @@ var (
 	ErrWritingSettingFile  = NewError("security: error on write setting file")
 	// loadbalancer
 	ErrIsBackendAlive = NewError("ngonx healthcheck: Site unreachcable dial tcp")
+	ErrHealthProbe    = NewError("ngonx healthcheck: health probe failed")
+	// ipfilter
+	ErrInvalidCIDR   = NewError("ipfilter: error invalid cidr")
+	ErrUntrustedPeer = NewError("ipfilter: error forbidden")
 	// repositoryDB
 	ErrSavekeyUpdateTX     = NewError("badgerdb: error executing TX to save apikey")
 	ErrSavekeyUpdate       = NewError("badgerdb: error to save apikey")
@@ -18,9 +22,67 @@ var (
 	ErrGetkeyTX            = NewError("baderdb: error executing TX to get value")
 	ErrGetkeyValue         = NewError("baderdb: error executing get item value")
 	ErrGetkeyView          = NewError("baderdb: error executing get view")
+	ErrGetkeyMemory        = NewError("memory: error key not found")
+	ErrGetkeyNotFound      = NewError("repository: error key not found")
 	// lbHandler
-	ErrLBHttp              = NewError("lb: error service not availeble")
-	ErrBearerTokenFormat   = NewError("proxyHandler: error Format is Authorization: Bearer [token]")
-	ErrTokenExpValidation  = NewError("proxyHandler: error token expired")
-	ErrTokenHMACValidation = NewError("proxyHandler: error HMAC verification failed")
+	ErrLBHttp                   = NewError("lb: error service not availeble")
+	ErrLBMaxUpstreamCalls       = NewError("lb: error max upstream calls of the request reached")
+	ErrLBStrategy               = NewError("lb: error unknown balancing strategy")
+	ErrLBDeadlineBudget         = NewError("lb: error request deadline budget exhausted")
+	ErrLBBackendInvalid         = NewError("lb: error invalid backend")
+	ErrLBBackendExists          = NewError("lb: error backend already in the pool")
+	ErrLBBackendNotFound        = NewError("lb: error backend not in the pool")
+	ErrBearerTokenFormat        = NewError("proxyHandler: error Format is Authorization: Bearer [token]")
+	ErrTokenExpValidation       = NewError("proxyHandler: error token expired")
+	ErrTokenHMACValidation      = NewError("proxyHandler: error HMAC verification failed")
+	ErrTokenSignatureValidation = NewError("proxyHandler: error token signature verification failed")
+	ErrTokenIssuerValidation    = NewError("proxyHandler: error token iss claim validation failed")
+	ErrTokenAudienceValidation  = NewError("proxyHandler: error token aud claim validation failed")
+	ErrTokenAudienceForbidden   = NewError("proxyHandler: error token aud not allowed on the route")
+	ErrTokenInvalid             = NewError("proxyHandler: error invalid token")
+	ErrUnsupportedJWTAlg        = NewError("proxyHandler: error unsupported jwt algorithm")
+	ErrSecretStoreUnavailable   = NewError("proxyHandler: error secret store unavailable")
+	ErrJWTPublicKey             = NewError("proxyHandler: error loading the jwt public key")
+	ErrOutboundProxyURL         = NewError("proxyHandler: error invalid outbound proxy url")
+	ErrDuplicateCredentials     = NewError("proxyHandler: error multiple credential headers")
+	ErrUpstreamTruncated        = NewError("proxyHandler: error upstream closed the connection mid-response")
+	ErrConcurrencyLimit         = NewError("proxyHandler: error too many concurrent requests")
+	ErrClientCAFile             = NewError("httpsrv: error loading the client ca file")
+	ErrTLSConfig                = NewError("httpsrv: error invalid tls settings")
+	ErrRequestIDFormat          = NewError("reqid: error unsupported request id format")
+	ErrPanic                    = NewError("proxyHandler: error internal server error")
+	ErrReloadRoutes             = NewError("proxyHandler: error invalid routes, reload rejected")
+	ErrRoutePath                = NewError("proxyHandler: error empty path_proxy of the route")
+	ErrCompressionMode          = NewError("proxyHandler: error unknown compression mode")
+	ErrDeprecationDate          = NewError("proxyHandler: error invalid deprecation date")
+	ErrTrailingSlashMode        = NewError("proxyHandler: error unknown trailing slash mode")
+	ErrRateLimited              = NewError("proxyHandler: error rate limit exceeded")
+	ErrLeakyBucketFull          = NewError("proxyHandler: error rate limit queue is full")
+	ErrUpstreamHeaderTooLarge   = NewError("proxyHandler: error upstream response headers too large")
+	ErrHMACSignatureFormat      = NewError("proxyHandler: error Format is X-Signature: hex(hmac-sha256)")
+	ErrHMACTimestamp            = NewError("proxyHandler: error signature timestamp out of tolerance")
+	ErrHMACNonce                = NewError("proxyHandler: error missing or unverifiable nonce")
+	ErrAdminAPIKey              = NewError("lb: error invalid admin api key")
+	ErrHMACReplay               = NewError("proxyHandler: error replayed request")
+	ErrRequestBodyTooLarge      = NewError("proxyHandler: error request body too large")
+	ErrSigningScheme            = NewError("proxyHandler: error unsupported outbound signing scheme")
+	ErrSigningCredentials       = NewError("proxyHandler: error missing outbound signing credentials")
+	ErrClientConcurrencyLimit   = NewError("proxyHandler: error too many concurrent requests from the client")
+	ErrRequestTimeout           = NewError("proxyHandler: error request timeout")
+	ErrUpstreamTimeout          = NewError("proxyHandler: error upstream timeout")
+	ErrBodyReadTimeout          = NewError("proxyHandler: error request body not received in time")
+	ErrUpstreamUnavailable      = NewError("proxyHandler: error upstream unavailable")
+	ErrUnexpectedContentType    = NewError("proxyHandler: error unexpected upstream content-type")
+	ErrUpstreamProtocol         = NewError("proxyHandler: error unsupported upstream protocol")
+	ErrUpstreamTLS              = NewError("proxyHandler: error invalid upstream_tls")
+	ErrUpstreamScheme           = NewError("proxyHandler: error unsupported upstream scheme")
+	ErrViaLoop                  = NewError("proxyHandler: error loop detected in the Via chain")
+	ErrQueryLimit               = NewError("proxyHandler: error query string too long or with too many params")
+	ErrClientIPForbidden        = NewError("proxyHandler: error client ip not allowed")
+	ErrSizeRoute                = NewError("proxyHandler: error invalid size_route")
+	ErrCacheConfig              = NewError("proxyHandler: error invalid cache")
+	ErrLatencyClass             = NewError("proxyHandler: error invalid latency class")
+	ErrCaptureFile              = NewError("capture: error invalid request capture file")
+	ErrReplayTarget             = NewError("replay: error the --target backend is required")
+	ErrReplayHeader             = NewError("replay: error Format is --header \"Name: value\"")
 )
@@ -1,9 +1,13 @@
 package genkey
 
 import (
+	crand "crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/kenriortega/ngonx/pkg/errors"
@@ -47,3 +51,63 @@ func StringWithCharset() string {
 	}
 	return string(b)
 }
+
+// hashPrefix marks the api keys stored as `sha256$<salt>$<hash>`
+const hashPrefix = "sha256$"
+
+// HashAPIKey salted sha256 of the api key, the value saved in the store
+// instead of the key itself
+func HashAPIKey(apikey string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := crand.Read(salt); err != nil {
+		return "", errors.Errorf("%w: %v", errors.ErrApiKeyGenerator, err)
+	}
+	return hashPrefix + hex.EncodeToString(salt) + "$" + hex.EncodeToString(saltedHash(salt, apikey)), nil
+}
+
+// IsHashedAPIKey reports whether the stored value is a HashAPIKey hash,
+// plaintext keys were saved by older versions
+func IsHashedAPIKey(stored string) bool {
+	return strings.HasPrefix(stored, hashPrefix)
+}
+
+// CompareAPIKey reports in constant time whether the presented key matches the
+// stored HashAPIKey hash
+func CompareAPIKey(stored, presented string) bool {
+	parts := strings.Split(strings.TrimPrefix(stored, hashPrefix), "$")
+	if !IsHashedAPIKey(stored) || len(parts) != 2 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(saltedHash(salt, presented), want) == 1
+}
+
+// APIKeyID short id of a stored api key for the logs, the start of the salt of
+// the hashed ones, safe to show
+func APIKeyID(stored string) string {
+	if !IsHashedAPIKey(stored) || len(stored) < len(hashPrefix)+8 {
+		return "plaintext"
+	}
+	return stored[len(hashPrefix) : len(hashPrefix)+8]
+}
+
+// ComparePlaintextAPIKey reports in constant time whether the presented key is
+// the plaintext stored one, the digests keep the length from leaking
+func ComparePlaintextAPIKey(stored, presented string) bool {
+	a, b := sha256.Sum256([]byte(stored)), sha256.Sum256([]byte(presented))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+func saltedHash(salt []byte, apikey string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(apikey))
+	return h.Sum(nil)
+}
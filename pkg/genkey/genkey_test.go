@@ -12,3 +12,32 @@ func Test_genkey(t *testing.T) {
 		t.Error("Expected that ApiKeyGenerator return this hash [28f0116ef42bf718324946f13d787a1d41274a08335d52ee833d5b577f02a32a]")
 	}
 }
+
+// Test_HashAPIKey test the salted hashes of the stored api keys
+func Test_HashAPIKey(t *testing.T) {
+	apiKey := ApiKeyGenerator("1q2w3e4r5t")
+	hashed, err := HashAPIKey(apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHashedAPIKey(hashed) || hashed == apiKey {
+		t.Errorf("Expected a salted hash and result are %s", hashed)
+	}
+	if again, _ := HashAPIKey(apiKey); again == hashed {
+		t.Error("Expected a different salt on each hash and result are the same hash")
+	}
+	if !CompareAPIKey(hashed, apiKey) {
+		t.Error("Expected that CompareAPIKey accept the key")
+	}
+	for _, presented := range []string{"", "wrong", apiKey + "x"} {
+		if CompareAPIKey(hashed, presented) {
+			t.Errorf("Expected that CompareAPIKey reject %q", presented)
+		}
+	}
+	if CompareAPIKey(apiKey, apiKey) {
+		t.Error("Expected that CompareAPIKey reject a plaintext stored key")
+	}
+	if !ComparePlaintextAPIKey(apiKey, apiKey) || ComparePlaintextAPIKey(apiKey, "wrong") {
+		t.Error("Expected that ComparePlaintextAPIKey match only the same key")
+	}
+}
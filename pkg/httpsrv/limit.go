@@ -0,0 +1,110 @@
+package httpsrv
+
+import (
+	"net"
+	"sync"
+
+	"github.com/kenriortega/ngonx/pkg/otelify"
+)
+
+// LimitListener caps the connections accepted at once by the listener, over max
+// new connections wait for a free slot or, with reject, are closed right away.
+// A max <= 0 doesn`t limit them, the open connections are still exported
+func LimitListener(l net.Listener, max int, reject bool) net.Listener {
+	addr := l.Addr().String()
+	ll := &limitListener{
+		Listener: l,
+		reject:   reject,
+		done:     make(chan struct{}),
+		addr:     addr,
+	}
+	if max > 0 {
+		ll.sem = make(chan struct{}, max)
+	}
+	return ll
+}
+
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	reject    bool
+	done      chan struct{}
+	closeOnce sync.Once
+	addr      string
+}
+
+// acquire returns false once the listener is closed
+func (l *limitListener) acquire() bool {
+	if l.sem == nil {
+		return true
+	}
+	select {
+	case <-l.done:
+		return false
+	case l.sem <- struct{}{}:
+		return true
+	}
+}
+
+func (l *limitListener) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	if !l.reject {
+		if !l.acquire() {
+			return nil, net.ErrClosed
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+		return l.track(c), nil
+	}
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.sem == nil {
+			return l.track(c), nil
+		}
+		select {
+		case l.sem <- struct{}{}:
+			return l.track(c), nil
+		default:
+			otelify.MetricRejectedConnections.WithLabelValues(l.addr).Inc()
+			_ = c.Close()
+		}
+	}
+}
+
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// track counts the connection until it`s closed
+func (l *limitListener) track(c net.Conn) net.Conn {
+	otelify.MetricOpenConnections.WithLabelValues(l.addr).Inc()
+	return &limitConn{Conn: c, release: func() {
+		otelify.MetricOpenConnections.WithLabelValues(l.addr).Dec()
+		l.release()
+	}}
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
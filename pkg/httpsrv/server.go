@@ -3,20 +3,33 @@ package httpsrv
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/kenriortega/ngonx/pkg/errors"
 	"github.com/kenriortega/ngonx/pkg/logger"
+	"github.com/quic-go/quic-go/http3"
 )
 
 type server struct {
 	*http.Server
+	h3 *http3.Server
+	// maxConns and rejectConns of the tcp listener, see LimitListener
+	maxConns    int
+	rejectConns bool
+	// drain wait for the requests in flight on shutdown
+	drain time.Duration
 }
 
+// defaultDrainTimeout wait for the requests in flight on shutdown
+const defaultDrainTimeout = 30 * time.Second
+
 func NewServer(host string, port int, mux http.Handler) *server {
 
 	s := &http.Server{
@@ -26,22 +39,13 @@ func NewServer(host string, port int, mux http.Handler) *server {
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 		IdleTimeout:  15 * time.Second,
+		ConnContext:  ConnContext,
 	}
-	return &server{s}
+	return &server{Server: s}
 }
 
 func NewServerSSL(host string, port int, mux http.Handler) *server {
-	cfg := &tls.Config{
-		MinVersion:               tls.VersionTLS12,
-		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-		PreferServerCipherSuites: true,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			// tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-			// tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-			// tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-		},
-	}
+	cfg := defaultTLSConfig()
 
 	s := &http.Server{
 		Handler: mux,
@@ -50,10 +54,27 @@ func NewServerSSL(host string, port int, mux http.Handler) *server {
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 		IdleTimeout:  15 * time.Second,
+		ConnContext:  ConnContext,
 		TLSConfig:    cfg,
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
 	}
-	return &server{s}
+	return &server{Server: s}
+}
+
+// connCtx context key of the connection of the request, see ConnFromContext
+type connCtx struct{}
+
+// ConnContext http.Server.ConnContext keeping the connection in the context of
+// its requests, the servers of NewServer and NewServerSSL set it
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connCtx{}, c)
+}
+
+// ConnFromContext connection (tcp or tls) the request was read from, nil for
+// HTTP/3 and the servers without ConnContext
+func ConnFromContext(ctx context.Context) net.Conn {
+	c, _ := ctx.Value(connCtx{}).(net.Conn)
+	return c
 }
 
 // Start runs ListenAndServe on the http.Server with graceful shutdown
@@ -61,7 +82,11 @@ func (srv *server) Start() {
 	logger.LogInfo("ngonx: starting server...")
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		l, err := srv.listen()
+		if err == nil {
+			err = srv.Serve(l)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.LogError(errors.Errorf("could not listen on %s due to %s", srv.Addr, err).Error())
 		}
 	}()
@@ -69,12 +94,102 @@ func (srv *server) Start() {
 	srv.gracefulShutdown()
 }
 
-// Start runs ListenAndServe on the http.Server with graceful shutdown
+// EnableHTTP3 serve the same handler (routes and middleware) over HTTP/3 (QUIC)
+// on the udp port of the server, the tcp listener advertise it with `Alt-Svc`.
+// It only takes effect with StartSSL
+func (srv *server) EnableHTTP3() {
+	handler := srv.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	srv.h3 = &http3.Server{
+		Addr:    srv.Addr,
+		Handler: handler,
+	}
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := srv.h3.SetQuicHeaders(w.Header()); err != nil {
+			logger.LogError(errors.Errorf("could not set Alt-Svc header %s", err).Error())
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// LimitConnections caps the concurrent connections of the tcp listener, over max
+// they wait for a free slot or are closed with reject. The http3 listener (udp)
+// isn`t limited
+func (srv *server) LimitConnections(max int, reject bool) {
+	srv.maxConns = max
+	srv.rejectConns = reject
+}
+
+// DrainTimeout wait for the requests in flight on SIGINT/SIGTERM, the
+// connections still open after it are closed. 0 uses 30s
+func (srv *server) DrainTimeout(timeout time.Duration) {
+	srv.drain = timeout
+}
+
+// listen open the tcp listener of the server
+func (srv *server) listen() (net.Listener, error) {
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return LimitListener(l, srv.maxConns, srv.rejectConns), nil
+}
+
+// VerifyClientCerts ask the clients for a certificate and verify the ones sent
+// against the CAs of caFile, clients without a certificate are still accepted.
+// Only the tcp listener asks for them
+func (srv *server) VerifyClientCerts(caFile string) error {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return errors.Errorf("%w: %v", errors.ErrClientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return errors.Errorf("%w: no certificates in %s", errors.ErrClientCAFile, caFile)
+	}
+	srv.TLSConfig.ClientCAs = pool
+	srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// ConfigureTLS set the min version, cipher suites and certificate files of the
+// server, see TLSOptions. Without it StartSSL uses the defaults
+func (srv *server) ConfigureTLS(opts TLSOptions) error {
+	return applyTLS(srv.TLSConfig, opts)
+}
+
+// StartSSL runs ServeTLS on the http.Server with graceful shutdown, the
+// certificate is reloaded when the files change
 func (srv *server) StartSSL(crt, key string) {
 	logger.LogInfo("ngonx: starting server...")
 
+	if srv.TLSConfig.GetCertificate == nil {
+		if err := applyTLS(srv.TLSConfig, TLSOptions{CrtFile: crt, KeyFile: key}); err != nil {
+			logger.LogError(errors.Errorf("could not listen on %s due to %s", srv.Addr, err).Error())
+			return
+		}
+	}
+	if srv.h3 != nil {
+		// only the tcp listener asks for the client certificates
+		h3TLS := srv.TLSConfig.Clone()
+		h3TLS.ClientAuth, h3TLS.ClientCAs = tls.NoClientCert, nil
+		srv.h3.TLSConfig = http3.ConfigureTLSConfig(h3TLS)
+		go func() {
+			if err := srv.h3.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.LogError(errors.Errorf("could not listen http3 on %s due to %s", srv.Addr, err).Error())
+			}
+		}()
+		logger.LogInfo(fmt.Sprintf("ngonx: http3 server is ready to handle requests %s", srv.Addr))
+	}
+
 	go func() {
-		if err := srv.ListenAndServeTLS(crt, key); err != nil && err != http.ErrServerClosed {
+		l, err := srv.listen()
+		if err == nil {
+			err = srv.ServeTLS(l, "", "")
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.LogError(errors.Errorf("could not listen on %s due to %s", srv.Addr, err).Error())
 		}
 	}()
@@ -85,11 +200,16 @@ func (srv *server) StartSSL(crt, key string) {
 func (srv *server) gracefulShutdown() {
 	quit := make(chan os.Signal, 1)
 
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	sig := <-quit
 	logger.LogInfo(fmt.Sprintf("ngonx: server is shutting down %s", sig.String()))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	drain := srv.drain
+	if drain <= 0 {
+		drain = defaultDrainTimeout
+	}
+	// new connections are refused, the requests in flight finish on theirs
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
 	defer cancel()
 
 	srv.SetKeepAlivesEnabled(false)
@@ -97,5 +217,10 @@ func (srv *server) gracefulShutdown() {
 		logger.LogError(errors.Errorf("could not gracefully shutdown the server %s", err).Error())
 
 	}
+	if srv.h3 != nil {
+		if err := srv.h3.Close(); err != nil {
+			logger.LogError(errors.Errorf("could not shutdown the http3 server %s", err).Error())
+		}
+	}
 	logger.LogInfo("ngonx: server stopped")
 }
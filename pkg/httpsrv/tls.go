@@ -0,0 +1,149 @@
+package httpsrv
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// TLSOptions options of the TLS termination, MinVersion 1.2|1.3 (1.2 when it`s
+// empty) and CipherSuites names of tls.CipherSuites for TLS 1.2, empty keeps the
+// defaults. The TLS 1.3 suites aren`t configurable
+type TLSOptions struct {
+	CrtFile      string
+	KeyFile      string
+	MinVersion   string
+	CipherSuites []string
+}
+
+// certCheckInterval time between the checks of the certificate files on disk
+const certCheckInterval = 10 * time.Second
+
+// defaultTLSConfig TLS settings of the servers without TLSOptions
+func defaultTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:               tls.VersionTLS12,
+		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
+		PreferServerCipherSuites: true,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			// tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			// tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			// tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		},
+	}
+}
+
+// NewTLSConfig returns the TLS config of the options on top of the defaults,
+// the certificate is reloaded when the files change e.g. renewed by certbot
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := defaultTLSConfig()
+	if err := applyTLS(cfg, opts); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyTLS set the version, cipher suites and certificate of the options
+func applyTLS(cfg *tls.Config, opts TLSOptions) error {
+	switch opts.MinVersion {
+	case "", "1.2":
+		cfg.MinVersion = tls.VersionTLS12
+	case "1.3":
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return errors.Errorf("%w: min version %q, use 1.2|1.3", errors.ErrTLSConfig, opts.MinVersion)
+	}
+	if len(opts.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		cfg.CipherSuites = nil
+		for _, name := range opts.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return errors.Errorf("%w: unknown or insecure cipher suite %q", errors.ErrTLSConfig, name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	reloader, err := newCertReloader(opts.CrtFile, opts.KeyFile)
+	if err != nil {
+		return err
+	}
+	cfg.GetCertificate = reloader.GetCertificate
+	return nil
+}
+
+// certReloader serves the certificate of the files, reloaded when their
+// modification time changes. A failed reload keeps the previous certificate
+type certReloader struct {
+	crt, key string
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+func newCertReloader(crt, key string) (*certReloader, error) {
+	r := &certReloader{crt: crt, key: key, checked: time.Now()}
+	modTime, err := r.latestModTime()
+	if err == nil {
+		err = r.load(modTime)
+	}
+	if err != nil {
+		return nil, errors.Errorf("%w: %v", errors.ErrTLSConfig, err)
+	}
+	return r, nil
+}
+
+// GetCertificate tls.Config.GetCertificate checking the files at most every certCheckInterval
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.checked) >= certCheckInterval {
+		r.checked = now
+		modTime, err := r.latestModTime()
+		if err == nil && !modTime.Equal(r.modTime) {
+			err = r.load(modTime)
+			if err == nil {
+				logger.LogInfo("httpsrv: certificate reloaded", zap.String("crt_file", r.crt))
+			}
+		}
+		if err != nil {
+			logger.LogError(errors.Errorf("httpsrv: certificate reload failed, serving the previous one %v", err).Error())
+		}
+	}
+	return r.cert, nil
+}
+
+// load the key pair, the caller holds mu or owns r
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.crt, r.key)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+// latestModTime modification time of the newest of the two files
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.crt, r.key} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,123 @@
+package httpsrv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// writeCert writes a self-signed certificate for the name and its key
+func writeCert(t *testing.T, crt, key, name string, modTime time.Time) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(crt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{crt, key} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func Test_CertReloader(t *testing.T) {
+	dir := t.TempDir()
+	crt, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, crt, key, "first", time.Now().Add(-time.Minute))
+
+	cfg, err := NewTLSConfig(TLSOptions{CrtFile: crt, KeyFile: key, MinVersion: "1.3"})
+	if err != nil {
+		t.Fatalf("Expected no error and result are %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 and result are %x", cfg.MinVersion)
+	}
+	cert, _ := cfg.GetCertificate(nil)
+	if name := commonName(t, cert); name != "first" {
+		t.Errorf("Expected the first certificate and result are %s", name)
+	}
+
+	reloader, err := newCertReloader(crt, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renewed on disk, served once the check interval elapsed
+	writeCert(t, crt, key, "renewed", time.Now())
+	cert, _ = reloader.GetCertificate(nil)
+	if name := commonName(t, cert); name != "first" {
+		t.Errorf("Expected the first certificate within the check interval and result are %s", name)
+	}
+	reloader.checked = time.Time{}
+	cert, _ = reloader.GetCertificate(nil)
+	if name := commonName(t, cert); name != "renewed" {
+		t.Errorf("Expected the renewed certificate and result are %s", name)
+	}
+
+	// a broken renewal keeps the previous certificate
+	if err := os.WriteFile(key, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloader.checked = time.Time{}
+	cert, _ = reloader.GetCertificate(nil)
+	if name := commonName(t, cert); name != "renewed" {
+		t.Errorf("Expected the previous certificate after a failed reload and result are %s", name)
+	}
+}
+
+func Test_NewTLSConfig_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	crt, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, crt, key, "ngonx", time.Now())
+
+	cfg, err := NewTLSConfig(TLSOptions{CrtFile: crt, KeyFile: key, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}})
+	if err != nil || len(cfg.CipherSuites) != 1 || cfg.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Expected the configured cipher suite and result are %v", err)
+	}
+	for _, opts := range []TLSOptions{
+		{CrtFile: crt, KeyFile: key, MinVersion: "1.0"},
+		{CrtFile: crt, KeyFile: key, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CrtFile: filepath.Join(dir, "missing.pem"), KeyFile: key},
+	} {
+		if _, err := NewTLSConfig(opts); !errors.ErrorIs(err, errors.ErrTLSConfig) {
+			t.Errorf("%+v: Expected %v and result are %v", opts, errors.ErrTLSConfig, err)
+		}
+	}
+}
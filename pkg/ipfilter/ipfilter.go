@@ -0,0 +1,91 @@
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+	"github.com/kenriortega/ngonx/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// ParseCIDRs parse a list of CIDRs into `*net.IPNet`, a single ip
+// is accepted as a /32 (or /128 for ipv6)
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Errorf("%w: %q", errors.ErrInvalidCIDR, cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Errorf("%w: %q", errors.ErrInvalidCIDR, cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Contains returns true when the ip belongs to one of the nets
+func Contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteIP returns the ip of the direct peer of the request
+func RemoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// TrustedOnly middleware that only serves requests whose direct peer
+// belongs to the trusted nets, others get 403 or the connection
+// is dropped without response when `drop` is true.
+// Headers like X-Forwarded-For are ignored on purpose.
+// An empty list of nets trust everyone
+func TrustedOnly(nets []*net.IPNet, drop bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(nets) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := RemoteIP(r)
+			if ip != nil && Contains(nets, ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logger.LogWarn(
+				"ipfilter: untrusted peer rejected",
+				zap.String("remoteAddr", r.RemoteAddr),
+				zap.String("path", r.URL.Path),
+			)
+			if drop {
+				if hj, ok := w.(http.Hijacker); ok {
+					if conn, _, err := hj.Hijack(); err == nil {
+						_ = conn.Close()
+						return
+					}
+				}
+			}
+			http.Error(w, errors.ErrUntrustedPeer.Error(), http.StatusForbidden)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kenriortega/ngonx/pkg/reqid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileOptions options of a rotating log file, the file is rotated when it reaches
+// MaxSize or every RotateEvery, whichever comes first
+type FileOptions struct {
+	Path string
+	// MaxSize megabytes before rotating, 0 uses 100
+	MaxSize int
+	// MaxBackups rotated files kept, 0 keeps all
+	MaxBackups int
+	// MaxAge days a rotated file is kept, 0 keeps them forever
+	MaxAge   int
+	Compress bool
+	// RotateEvery time based rotation, 0 disables it
+	RotateEvery time.Duration
+}
+
+// AccessLog writes one json line per request to a rotating file
+type AccessLog struct {
+	log  *zap.Logger
+	file *lumberjack.Logger
+	stop chan struct{}
+}
+
+// NewAccessLog returns an access log writing to `opts.Path`
+func NewAccessLog(opts FileOptions) (*AccessLog, error) {
+	if opts.Path == "" {
+		return nil, errors.New("logger: access log path is required")
+	}
+	file := &lumberjack.Logger{
+		Filename:   opts.Path,
+		MaxSize:    opts.MaxSize,
+		MaxBackups: opts.MaxBackups,
+		MaxAge:     opts.MaxAge,
+		Compress:   opts.Compress,
+	}
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderConfig.CallerKey = ""
+	encoderConfig.StacktraceKey = ""
+
+	a := &AccessLog{
+		log: zap.New(zapcore.NewCore(
+			zapcore.NewJSONEncoder(encoderConfig),
+			zapcore.AddSync(file),
+			zap.InfoLevel,
+		)),
+		file: file,
+		stop: make(chan struct{}),
+	}
+	if opts.RotateEvery > 0 {
+		go a.rotate(opts.RotateEvery)
+	}
+	return a, nil
+}
+
+func (a *AccessLog) rotate(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := a.file.Rotate(); err != nil {
+				LogError("logger: access log rotation " + err.Error())
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Handler log every request served by next
+func (a *AccessLog) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		a.log.Info(
+			"access",
+			zap.String("remote", r.RemoteAddr),
+			zap.String("method", r.Method),
+			zap.String("host", r.Host),
+			zap.String("uri", r.RequestURI),
+			zap.String("proto", r.Proto),
+			zap.Int("status", rec.status),
+			zap.Int64("bytes", rec.bytes),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("user_agent", r.UserAgent()),
+			zap.String("request_id", reqid.FromContext(r.Context())),
+		)
+	})
+}
+
+// Close stops the time based rotation and closes the file
+func (a *AccessLog) Close() error {
+	close(a.stop)
+	_ = a.log.Sync()
+	return a.file.Close()
+}
+
+// statusRecorder keeps the status and size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps upgraded connections (websockets) working through the recorder
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -2,6 +2,7 @@ package logger
 
 import (
 	"os"
+	"sync/atomic"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -15,7 +16,13 @@ func GetEnv(key, fallback string) string {
 	return fallback
 }
 
-var log *zap.Logger
+// log current *zap.Logger, swapped atomically while other goroutines log e.g. by EnableOTLP
+var log atomic.Value
+
+// current returns the *zap.Logger in use
+func current() *zap.Logger {
+	return log.Load().(*zap.Logger)
+}
 
 func init() {
 	config := zap.NewProductionConfig()
@@ -39,25 +46,25 @@ func init() {
 		w,
 		zap.InfoLevel,
 	)
-	log = zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
+	log.Store(zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1)))
 }
 
 // LogInfo wrap for log.info
 func LogInfo(message string, fields ...zap.Field) {
-	log.Info(message, fields...)
+	current().Info(message, fields...)
 }
 
 // LogDebug wrap for log.Debug
 func LogDebug(message string, fields ...zap.Field) {
-	log.Debug(message, fields...)
+	current().Debug(message, fields...)
 }
 
 // LogError wrap for log.Error
 func LogError(message string, fields ...zap.Field) {
-	log.Error(message, fields...)
+	current().Error(message, fields...)
 }
 
 // LogWarn wrap for log.Warn
 func LogWarn(message string, fields ...zap.Field) {
-	log.Warn(message, fields...)
+	current().Warn(message, fields...)
 }
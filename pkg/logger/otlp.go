@@ -0,0 +1,249 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+)
+
+// swapMu serializes the changes of the logger in use
+var swapMu sync.Mutex
+
+const (
+	otlpBatchSize     = 512
+	otlpQueueSize     = 4096
+	otlpFlushInterval = 2 * time.Second
+	otlpExportTimeout = 5 * time.Second
+)
+
+// EnableOTLP tee every log entry to an OTLP collector using the grpc logs service,
+// entries with a `traceID` or `spanID` field are correlated with their trace.
+// Returns a func to flush and close the exporter
+func EnableOTLP(endpoint, serviceName string) (func(), error) {
+	conn, err := grpc.Dial(endpoint, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	exp := &otlpExporter{
+		conn:    conn,
+		client:  collogspb.NewLogsServiceClient(conn),
+		records: make(chan *logspb.LogRecord, otlpQueueSize),
+		done:    make(chan struct{}),
+		resource: &resourcepb.Resource{
+			Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: stringValue(serviceName)},
+			},
+		},
+	}
+	go exp.run()
+
+	otlp := &otlpCore{LevelEnabler: zap.InfoLevel, exp: exp}
+	swapMu.Lock()
+	log.Store(current().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, otlp)
+	})))
+	swapMu.Unlock()
+
+	return exp.shutdown, nil
+}
+
+// otlpCore zapcore.Core that converts entries into OTLP log records
+type otlpCore struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+	exp    *otlpExporter
+}
+
+func (c *otlpCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field{}, c.fields...), fields...)
+	return &clone
+}
+
+func (c *otlpCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *otlpCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	record := &logspb.LogRecord{
+		TimeUnixNano:   uint64(ent.Time.UnixNano()),
+		SeverityNumber: severityNumber(ent.Level),
+		SeverityText:   ent.Level.CapitalString(),
+		Body:           stringValue(ent.Message),
+	}
+	if ent.Caller.Defined {
+		record.Attributes = append(record.Attributes, &commonpb.KeyValue{
+			Key:   "caller",
+			Value: stringValue(ent.Caller.TrimmedPath()),
+		})
+	}
+	for k, v := range enc.Fields {
+		switch k {
+		case "traceID":
+			if id, err := trace.TraceIDFromHex(fmt.Sprint(v)); err == nil {
+				record.TraceId = id[:]
+				continue
+			}
+		case "spanID":
+			if id, err := trace.SpanIDFromHex(fmt.Sprint(v)); err == nil {
+				record.SpanId = id[:]
+				continue
+			}
+		}
+		record.Attributes = append(record.Attributes, &commonpb.KeyValue{
+			Key:   k,
+			Value: anyValue(v),
+		})
+	}
+	c.exp.enqueue(record)
+	return nil
+}
+
+func (c *otlpCore) Sync() error {
+	return nil
+}
+
+// otlpExporter batches log records and exports them to the collector
+type otlpExporter struct {
+	conn     *grpc.ClientConn
+	client   collogspb.LogsServiceClient
+	resource *resourcepb.Resource
+	records  chan *logspb.LogRecord
+	done     chan struct{}
+	once     sync.Once
+	// mu guards closed, records is never written once it`s closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// enqueue never blocks the caller, records are dropped when the queue is full
+// or the exporter is shut down
+func (e *otlpExporter) enqueue(record *logspb.LogRecord) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.records <- record:
+	default:
+	}
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+	t := time.NewTicker(otlpFlushInterval)
+	defer t.Stop()
+
+	batch := make([]*logspb.LogRecord, 0, otlpBatchSize)
+	for {
+		select {
+		case record, ok := <-e.records:
+			if !ok {
+				e.export(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= otlpBatchSize {
+				e.export(batch)
+				batch = batch[:0]
+			}
+		case <-t.C:
+			e.export(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (e *otlpExporter) export(batch []*logspb.LogRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+	defer cancel()
+
+	_, err := e.client.Export(ctx, &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: e.resource,
+			InstrumentationLibraryLogs: []*logspb.InstrumentationLibraryLogs{{
+				InstrumentationLibrary: &commonpb.InstrumentationLibrary{Name: "ngonx/logger"},
+				Logs:                   append([]*logspb.LogRecord{}, batch...),
+			}},
+		}},
+	})
+	if err != nil {
+		// the logger itself can`t be used here, it would feed the exporter again
+		fmt.Fprintf(os.Stderr, "logger: failed to export otlp logs: %v\n", err)
+	}
+}
+
+func (e *otlpExporter) shutdown() {
+	e.once.Do(func() {
+		e.mu.Lock()
+		e.closed = true
+		close(e.records)
+		e.mu.Unlock()
+		<-e.done
+		_ = e.conn.Close()
+	})
+}
+
+func severityNumber(level zapcore.Level) logspb.SeverityNumber {
+	switch level {
+	case zapcore.DebugLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG
+	case zapcore.InfoLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+	case zapcore.WarnLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	case zapcore.ErrorLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+	default:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL
+	}
+}
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+func anyValue(v interface{}) *commonpb.AnyValue {
+	switch v := v.(type) {
+	case string:
+		return stringValue(v)
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}
+	case int:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case time.Duration:
+		return stringValue(v.String())
+	default:
+		return stringValue(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,35 @@
+package logger
+
+import (
+	"sync"
+	"testing"
+
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+// Test_otlpExporter_ShutdownWhileLogging records written during the shutdown are dropped
+func Test_otlpExporter_ShutdownWhileLogging(t *testing.T) {
+	// the collector is never reached, the records are dropped before the export
+	shutdown, err := EnableOTLP("127.0.0.1:1", "ngonx-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				LogInfo("logging while the exporter shuts down")
+			}
+		}()
+	}
+	shutdown()
+	wg.Wait()
+
+	exp := &otlpExporter{records: make(chan *logspb.LogRecord, 1), done: make(chan struct{}), closed: true}
+	exp.enqueue(&logspb.LogRecord{})
+	if len(exp.records) != 0 {
+		t.Errorf("Expected no record queued after the shutdown and result are %d", len(exp.records))
+	}
+}
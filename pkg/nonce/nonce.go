@@ -0,0 +1,69 @@
+package nonce
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Store records nonces during a ttl to detect replayed requests
+type Store interface {
+	// Seen records the nonce and returns true when it was already recorded
+	Seen(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// MemoryStore in-memory nonce store, expired nonces are swept lazily
+type MemoryStore struct {
+	mu        sync.Mutex
+	items     map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryStore return a new MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]time.Time)}
+}
+
+// Seen records the nonce and returns true when it was already recorded
+func (s *MemoryStore) Seen(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > ttl {
+		for k, exp := range s.items {
+			if now.After(exp) {
+				delete(s.items, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if exp, ok := s.items[nonce]; ok && now.Before(exp) {
+		return true, nil
+	}
+	s.items[nonce] = now.Add(ttl)
+	return false, nil
+}
+
+// RedisStore nonce store shared by every gateway instance
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore return a new RedisStore, keys are saved as `prefix+nonce`
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Seen records the nonce and returns true when it was already recorded
+func (s *RedisStore) Seen(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	ok, err := s.client.SetNX(ctx, s.prefix+nonce, 1, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
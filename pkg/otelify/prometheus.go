@@ -3,22 +3,295 @@ package otelify
 import (
 	"fmt"
 	"net/http"
+	"regexp"
+	"sort"
+	"sync"
 
+	"github.com/kenriortega/ngonx/pkg/errors"
 	"github.com/kenriortega/ngonx/pkg/logger"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var MetricRequestLatencyProxy = promauto.NewHistogram(prometheus.HistogramOpts{
+// MetricRequestLatencyProxy time from the request receipt to the upstream
+// response headers (or the error response) by endpoint
+var MetricRequestLatencyProxy = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Namespace: "ngonx",
 	Name:      "request_latency_seconds",
 	Help:      "Request Latency",
 	Buckets:   prometheus.ExponentialBuckets(.0001, 2, 50),
+}, []string{"endpoint"})
+
+// latencyClasses histograms of the route classes by name, see ConfigureLatencyClasses
+var (
+	latencyClasses   = map[string]*prometheus.HistogramVec{}
+	latencyClassesMu sync.RWMutex
+	latencyClassName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// ConfigureLatencyClasses registers a `ngonx_request_latency_<class>_seconds`
+// histogram by endpoint for each class with its own buckets (seconds, sorted
+// on registration), so fast and slow routes get a resolution of their own.
+// Every class is validated before any is registered, an error registers none.
+// The classes are registered once per process, the gateway settings need a restart
+func ConfigureLatencyClasses(classes map[string][]float64) error {
+	latencyClassesMu.Lock()
+	defer latencyClassesMu.Unlock()
+	sorted := make(map[string][]float64, len(classes))
+	for class, buckets := range classes {
+		if !latencyClassName.MatchString(class) {
+			return errors.Errorf("%w: name %q, use lowercase letters, digits and _", errors.ErrLatencyClass, class)
+		}
+		if len(buckets) == 0 {
+			return errors.Errorf("%w: %q without buckets", errors.ErrLatencyClass, class)
+		}
+		buckets = append([]float64{}, buckets...)
+		sort.Float64s(buckets)
+		for i, bucket := range buckets {
+			if bucket <= 0 || (i > 0 && bucket == buckets[i-1]) {
+				return errors.Errorf("%w: %q buckets must be positive and unique", errors.ErrLatencyClass, class)
+			}
+		}
+		sorted[class] = buckets
+	}
+
+	registered := make(map[string]*prometheus.HistogramVec, len(sorted))
+	for class, buckets := range sorted {
+		if _, ok := latencyClasses[class]; ok {
+			continue
+		}
+		histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ngonx",
+			Name:      "request_latency_" + class + "_seconds",
+			Help:      "Request Latency of the " + class + " routes",
+			Buckets:   buckets,
+		}, []string{"endpoint"})
+		if err := prometheus.Register(histogram); err != nil {
+			for _, histogram := range registered {
+				prometheus.Unregister(histogram)
+			}
+			return errors.Errorf("%w: %q %v", errors.ErrLatencyClass, class, err)
+		}
+		registered[class] = histogram
+	}
+	for class, histogram := range registered {
+		latencyClasses[class] = histogram
+	}
+	return nil
+}
+
+// LatencyObserver returns the latency observer of the endpoint in the
+// histogram of its class, `MetricRequestLatencyProxy` without class. False
+// when the class was not configured
+func LatencyObserver(class, endpoint string) (prometheus.Observer, bool) {
+	if class == "" {
+		return MetricRequestLatencyProxy.WithLabelValues(endpoint), true
+	}
+	latencyClassesMu.RLock()
+	histogram, ok := latencyClasses[class]
+	latencyClassesMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return histogram.WithLabelValues(endpoint), true
+}
+
+// MetricRequests requests by endpoint, method and status code sent to the client
+var MetricRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "requests_total",
+	Help:      "Requests by endpoint, method and status code",
+}, []string{"endpoint", "method", "status"})
+
+// MetricBodySize body bytes by endpoint and direction, in the request bodies read
+// from the clients and out the response bodies sent to them
+var MetricBodySize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "ngonx",
+	Name:      "body_size_bytes",
+	Help:      "Request (in) and response (out) body bytes by endpoint",
+	Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+}, []string{"endpoint", "direction"})
+
+// MetricDeprecatedRequests responses of deprecated services by service and endpoint
+var MetricDeprecatedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "deprecated_requests_total",
+	Help:      "Responses of deprecated services by service and endpoint",
+}, []string{"service", "endpoint"})
+
+// MetricRouteInflight current in-flight requests by endpoint
+var MetricRouteInflight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "ngonx",
+	Name:      "route_inflight_requests",
+	Help:      "Current in-flight requests by endpoint",
+}, []string{"endpoint"})
+
+// MetricRouteConcurrencyLimit configured concurrency limit by endpoint
+var MetricRouteConcurrencyLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "ngonx",
+	Name:      "route_concurrency_limit",
+	Help:      "Configured max concurrent requests by endpoint",
+}, []string{"endpoint"})
+
+// MetricTrackedClientIPs client ips with in-flight requests tracked by the per ip limit
+var MetricTrackedClientIPs = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "ngonx",
+	Name:      "tracked_client_ips",
+	Help:      "Client ips with in-flight requests tracked by the per ip concurrency limit",
+})
+
+// MetricUnexpectedContentType upstream responses with an unexpected content-type by endpoint
+var MetricUnexpectedContentType = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "unexpected_content_type_total",
+	Help:      "Upstream responses whose content-type isn't the expected one by endpoint",
+}, []string{"endpoint"})
+
+// MetricSlowRequests requests over the slow request threshold by endpoint
+var MetricSlowRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "slow_requests_total",
+	Help:      "Requests slower than the slow request threshold by endpoint",
+}, []string{"endpoint"})
+
+// MetricCacheWarm cache warming requests by endpoint and result (success|failure)
+var MetricCacheWarm = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "cache_warm_total",
+	Help:      "Cache warming requests by endpoint and result",
+}, []string{"endpoint", "result"})
+
+// MetricLeakyBucketRejected requests rejected by the full leaky bucket queue by endpoint
+var MetricLeakyBucketRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "leaky_bucket_rejected_total",
+	Help:      "Requests rejected by the full leaky bucket queue by endpoint",
+}, []string{"endpoint"})
+
+// MetricRateLimited requests over the rate limit of the client ip by endpoint
+var MetricRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "rate_limited_total",
+	Help:      "Requests rejected by the per client ip rate limit by endpoint",
+}, []string{"endpoint"})
+
+// MetricBackendEWMA moving average of the response time of the lb backends in seconds
+var MetricBackendEWMA = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "ngonx",
+	Name:      "backend_response_time_ewma_seconds",
+	Help:      "Exponentially weighted moving average of the response time by lb backend",
+}, []string{"backend"})
+
+// MetricHealthChecksSkipped health checks of a lb backend skipped because the previous one was still running
+var MetricHealthChecksSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "health_checks_skipped_total",
+	Help:      "Health checks of a lb backend skipped while its previous probe was in flight",
+}, []string{"backend"})
+
+// MetricBackendOutstandingBytes response bytes in flight of the lb backends, not yet read
+var MetricBackendOutstandingBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "ngonx",
+	Name:      "backend_outstanding_bytes",
+	Help:      "Response bytes not yet read by lb backend",
+}, []string{"backend"})
+
+// MetricRouteReloads reloads of the proxy routes by result (applied|rejected)
+var MetricRouteReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "route_reloads_total",
+	Help:      "Reloads of the proxy routes by result",
+}, []string{"result"})
+
+// MetricClientDisconnects requests abandoned by the client before the upstream answered
+var MetricClientDisconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "client_disconnects_total",
+	Help:      "Requests whose client disconnected before the upstream answered by endpoint",
+}, []string{"endpoint"})
+
+// MetricCircuitOpens times the circuit breaker of a lb backend opened
+var MetricCircuitOpens = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "circuit_opens_total",
+	Help:      "Times the circuit breaker of a lb backend opened",
+}, []string{"backend"})
+
+// MetricQueryRejected requests rejected by the query limits by reason (length|params)
+var MetricQueryRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "query_rejected_total",
+	Help:      "Requests rejected by the query string limits by reason",
+}, []string{"reason"})
+
+// MetricClientIPRejected requests rejected by the allow_cidrs|deny_cidrs of a service by endpoint and list
+var MetricClientIPRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "client_ip_rejected_total",
+	Help:      "Requests rejected by the client ip lists by endpoint and list (allow|deny)",
+}, []string{"endpoint", "list"})
+
+// MetricBodyReadTimeouts requests answered with 408 because the client was too slow sending the body
+var MetricBodyReadTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "body_read_timeouts_total",
+	Help:      "Requests whose body wasn't received within the body_read_timeout by endpoint",
+}, []string{"endpoint"})
+
+// MetricRetryAfterRetries requests retried after the `Retry-After` of a 429 or 503 by endpoint and status
+var MetricRetryAfterRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "upstream_retry_after_total",
+	Help:      "Requests retried after the Retry-After of an upstream 429 or 503 by endpoint and status",
+}, []string{"endpoint", "status"})
+
+// MetricPanics panics recovered in the handler chain
+var MetricPanics = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "panics_total",
+	Help:      "Panics recovered in the handler chain",
 })
 
-func ExposeMetricServer(configPort int) {
-	http.Handle("/metrics", promhttp.Handler())
+// MetricUpstreamPhase duration of the phases (dns|connect|tls|ttfb) of the requests by backend
+var MetricUpstreamPhase = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "ngonx",
+	Name:      "upstream_phase_duration_seconds",
+	Help:      "Duration of the dns, connect, tls handshake and time to first byte of the upstream requests",
+	Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+}, []string{"backend", "phase"})
+
+// MetricSecretStoreUnavailable authentications that could not read the key store
+// by security type and fail mode (open|closed)
+var MetricSecretStoreUnavailable = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "secret_store_unavailable_total",
+	Help:      "Authentications that could not read the key store by security type and fail mode",
+}, []string{"security", "fail_mode"})
+
+// MetricOpenConnections open client connections by listener address
+var MetricOpenConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "ngonx",
+	Name:      "open_connections",
+	Help:      "Open client connections by listener address",
+}, []string{"listener"})
+
+// MetricRejectedConnections connections closed over the max connections of the listener
+var MetricRejectedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ngonx",
+	Name:      "rejected_connections_total",
+	Help:      "Connections rejected over the max connections by listener address",
+}, []string{"listener"})
+
+// ExposeMetricServer serve `/metrics` on its own listener,
+// middlewares wrap the handler e.g. the ip filter of the admin listeners
+func ExposeMetricServer(configPort int, middlewares ...func(http.Handler) http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	var handler http.Handler = mux
+	for _, m := range middlewares {
+		handler = m(handler)
+	}
 	port := fmt.Sprintf(":%d", configPort)
-	logger.LogError(http.ListenAndServe(port, nil).Error())
+	logger.LogError(http.ListenAndServe(port, handler).Error())
 }
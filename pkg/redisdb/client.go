@@ -11,7 +11,7 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
-//Used to execute client creation procedure only once.
+// Used to execute client creation procedure only once.
 var redisOnce sync.Once
 
 func GetRedisDbClient(redisUri, redisPass string) *redis.Client {
@@ -26,7 +26,7 @@ func GetRedisDbClient(redisUri, redisPass string) *redis.Client {
 			WriteTimeout: 60 * time.Second,
 		})
 
-		_, err := clientInstance.Ping(context.TODO()).Result()
+		_, err := client.Ping(context.TODO()).Result()
 		if err != nil {
 			logger.LogError(errors.Errorf("redis: %v", err).Error())
 
@@ -0,0 +1,87 @@
+// Package reqid generates the request ids of the gateway and carries
+// them in the request context so every log can reference them
+package reqid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/kenriortega/ngonx/pkg/errors"
+)
+
+// formats of the request ids
+const (
+	FormatUUIDv4 = "uuidv4"
+	FormatUUIDv7 = "uuidv7"
+	FormatULID   = "ulid"
+)
+
+// crockford alphabet of the ulids
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type ctxKey struct{}
+
+// NewGenerator returns the generator of the format, empty is uuidv4
+func NewGenerator(format string) (func() string, error) {
+	switch strings.ToLower(format) {
+	case "", FormatUUIDv4:
+		return func() string { return uuid.NewV4().String() }, nil
+	case FormatUUIDv7:
+		return func() string { return newUUIDv7(time.Now()) }, nil
+	case FormatULID:
+		return func() string { return newULID(time.Now()) }, nil
+	}
+	return nil, errors.Errorf("%w: %q", errors.ErrRequestIDFormat, format)
+}
+
+// NewContext returns a copy of ctx carrying the request id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request id of ctx, empty when there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// newUUIDv7 unix milliseconds followed by random bits (RFC 9562), sortable by time
+func newUUIDv7(now time.Time) string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	ms := uint64(now.UnixNano() / int64(time.Millisecond))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(b[:6], ts[2:])
+	b[6] = 0x70 | b[6]&0x0f // version 7
+	b[8] = 0x80 | b[8]&0x3f // variant 10
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// newULID 48 bits of unix milliseconds and 80 random bits in crockford base32
+func newULID(now time.Time) string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	ms := uint64(now.UnixNano() / int64(time.Millisecond))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(b[:6], ts[2:])
+
+	// 128 bits in 26 characters of 5 bits, the first one only holds 3
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
@@ -0,0 +1,38 @@
+package reqid
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_NewGenerator(t *testing.T) {
+	formats := map[string]*regexp.Regexp{
+		FormatUUIDv4: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		FormatUUIDv7: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		FormatULID:   regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+	}
+	for format, re := range formats {
+		generate, err := NewGenerator(format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if id := generate(); !re.MatchString(id) {
+			t.Errorf("%s: unexpected id %q", format, id)
+		}
+	}
+	if _, err := NewGenerator("snowflake"); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+}
+
+func Test_SortableByTime(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Millisecond)
+	if a, b := newULID(now), newULID(later); a >= b {
+		t.Errorf("Expected %q < %q", a, b)
+	}
+	if a, b := newUUIDv7(now), newUUIDv7(later); a >= b {
+		t.Errorf("Expected %q < %q", a, b)
+	}
+}
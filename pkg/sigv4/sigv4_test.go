@@ -0,0 +1,31 @@
+package sigv4
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Test_Sign_GetVanilla vector `get-vanilla` from the aws sig v4 test suite
+func Test_Sign_GetVanilla(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signer := Signer{
+		Credentials: Credentials{
+			AccessKey: "AKIDEXAMPLE",
+			SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		},
+		Region:  "us-east-1",
+		Service: "service",
+	}
+	now, _ := time.Parse(timeFormat, "20150830T123600Z")
+	if err := signer.Sign(req, now); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Expected Authorization %q and result are %q", expected, got)
+	}
+}